@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+var (
+	allowedNetworks string
+	trustedProxies  string
+
+	// proxyNetworks holds the parsed -trusted-proxies.
+	proxyNetworks []netip.Prefix
+)
+
+// parseNetworks parses a comma-separated list of CIDRs, such as
+// 192.168.1.0/24,100.64.0.0/10. An address alone is a network of one
+// address.
+func parseNetworks(value string) ([]netip.Prefix, error) {
+	var networks []netip.Prefix
+	for _, network := range strings.Split(value, ",") {
+		network = strings.TrimSpace(network)
+		if network == "" {
+			continue
+		}
+
+		if !strings.Contains(network, "/") {
+			addr, err := netip.ParseAddr(network)
+			if err != nil {
+				return nil, err
+			}
+			networks = append(networks, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(network)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, prefix.Masked())
+	}
+
+	return networks, nil
+}
+
+func networksContain(networks []netip.Prefix, addr netip.Addr) bool {
+	for _, network := range networks {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowNetworks rejects the requests of the clients outside of the allowed
+// networks, before any handler.
+func allowNetworks(allowed []netip.Prefix, proxies []netip.Prefix, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr, ok := requestAddr(r, proxies)
+		if !ok || !networksContain(allowed, addr) {
+			debug("Rejected request from %s", r.RemoteAddr)
+			httpError(w, r, "Access denied", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestAddr returns the address of the client. Behind trusted proxies, it
+// is the last address of X-Forwarded-For that is not one of them; the
+// header is ignored for the other clients, which could forge it.
+func requestAddr(r *http.Request, proxies []netip.Prefix) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	addr = addr.Unmap()
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0 && networksContain(proxies, addr); i-- {
+		value := strings.TrimSpace(forwarded[i])
+		if value == "" {
+			continue
+		}
+		next, err := netip.ParseAddr(value)
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		addr = next.Unmap()
+	}
+
+	return addr, true
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestParseNetworks(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []netip.Prefix
+		wantErr bool
+	}{
+		{value: "", want: nil},
+		{value: "192.168.1.0/24", want: []netip.Prefix{netip.MustParsePrefix("192.168.1.0/24")}},
+		{value: "192.168.1.7/24", want: []netip.Prefix{netip.MustParsePrefix("192.168.1.0/24")}},
+		{value: "127.0.0.1", want: []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}},
+		{value: "::ffff:10.0.0.1", want: []netip.Prefix{netip.MustParsePrefix("10.0.0.1/32")}},
+		{value: "::1", want: []netip.Prefix{netip.MustParsePrefix("::1/128")}},
+		{
+			value: " 10.0.0.0/8 , 100.64.0.0/10,",
+			want:  []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("100.64.0.0/10")},
+		},
+		{value: "10.0.0.0/33", wantErr: true},
+		{value: "localhost", wantErr: true},
+	}
+
+	for _, test := range tests {
+		networks, err := parseNetworks(test.value)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseNetworks(%q) error = %v, want error %v", test.value, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && !reflect.DeepEqual(networks, test.want) {
+			t.Errorf("parseNetworks(%q) = %v, want %v", test.value, networks, test.want)
+		}
+	}
+}
+
+func TestRequestAddr(t *testing.T) {
+	proxies := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+		wantOK     bool
+	}{
+		{name: "direct", remoteAddr: "192.168.1.2:1234", want: "192.168.1.2", wantOK: true},
+		{name: "mapped", remoteAddr: "[::ffff:192.168.1.2]:1234", want: "192.168.1.2", wantOK: true},
+		{name: "forged", remoteAddr: "192.168.1.2:1234", forwarded: []string{"6.6.6.6"}, want: "192.168.1.2", wantOK: true},
+		{name: "proxied", remoteAddr: "10.0.0.1:1234", forwarded: []string{"203.0.113.5"}, want: "203.0.113.5", wantOK: true},
+		{name: "proxy chain", remoteAddr: "10.0.0.1:1234", forwarded: []string{"203.0.113.5, 10.0.0.2"}, want: "203.0.113.5", wantOK: true},
+		{name: "forged through proxy", remoteAddr: "10.0.0.1:1234", forwarded: []string{"6.6.6.6, 203.0.113.5"}, want: "203.0.113.5", wantOK: true},
+		{name: "several headers", remoteAddr: "10.0.0.1:1234", forwarded: []string{"6.6.6.6", "203.0.113.5"}, want: "203.0.113.5", wantOK: true},
+		{name: "proxy without header", remoteAddr: "10.0.0.1:1234", want: "10.0.0.1", wantOK: true},
+		{name: "invalid forwarded", remoteAddr: "10.0.0.1:1234", forwarded: []string{"garbage"}, wantOK: false},
+		{name: "invalid remote", remoteAddr: "garbage", wantOK: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = test.remoteAddr
+			for _, value := range test.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+
+			addr, ok := requestAddr(r, proxies)
+			if ok != test.wantOK {
+				t.Fatalf("requestAddr() ok = %v, want %v", ok, test.wantOK)
+			}
+			if ok && addr.String() != test.want {
+				t.Errorf("requestAddr() = %s, want %s", addr, test.want)
+			}
+		})
+	}
+}
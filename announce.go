@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	discordWebhookURL string
+	slackWebhookURL   string
+	announceName      string
+)
+
+func announcementsEnabled() bool {
+	return discordWebhookURL != "" || slackWebhookURL != ""
+}
+
+// startAnnouncements posts a chat message when a video or a whole folder is
+// completed, e.g. "Alice finished 12 - Lecture of CS50".
+func startAnnouncements() {
+	subscribe(func(event Event) {
+		message := announcementText(event)
+		if message == "" {
+			return
+		}
+
+		if discordWebhookURL != "" {
+			postAnnouncement("Discord", discordWebhookURL, map[string]string{"content": message})
+		}
+		if slackWebhookURL != "" {
+			postAnnouncement("Slack", slackWebhookURL, map[string]string{"text": message})
+		}
+	})
+}
+
+func announcementText(event Event) string {
+	folder := event.Folder
+	if folder == "" {
+		folder = event.Library
+	}
+
+	var message string
+	switch event.Type {
+	case eventVideoCompleted:
+		message = "finished " + strings.TrimSuffix(event.Video, filepath.Ext(event.Video)) + " of " + folder
+	case eventCourseCompleted:
+		message = "completed " + folder + " 🎉"
+	default:
+		return ""
+	}
+
+	if announceName == "" {
+		return strings.ToUpper(message[:1]) + message[1:]
+	}
+
+	return announceName + " " + message
+}
+
+func postAnnouncement(service string, url string, payload map[string]string) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Error marshaling %s message: %v", service, err)
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Error sending %s notification: %v", service, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	sendNotification(service, req)
+}
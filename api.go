@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiPrefix is the versioned JSON API, for alternative frontends and
+// scripts. Its responses keep their fields within a version, new versions
+// being added beside it for incompatible changes.
+const apiPrefix = "/api/v1/"
+
+// APIVideo is a video of the API, with its saved watch state. Title is the
+// displayed name, and Missing whether its file was missing when last opened.
+type APIVideo struct {
+	ID       string
+	Name     string
+	Title    string
+	Folder   string
+	Added    time.Time
+	Viewed   bool
+	Progress float64
+	Current  time.Time
+	ReviewAt *time.Time `json:",omitempty"`
+	Missing  bool       `json:",omitempty"`
+}
+
+func newAPIVideo(video VideoFile) APIVideo {
+	return APIVideo{
+		ID:       video.ID,
+		Name:     video.Name,
+		Title:    video.DisplayName(),
+		Folder:   video.Folder,
+		Added:    video.Added,
+		Viewed:   video.Viewed,
+		Progress: video.Progress,
+		Current:  video.Current,
+		ReviewAt: video.ReviewAt,
+		Missing:  videoMissing(video),
+	}
+}
+
+func newAPIVideos(videoFiles []VideoFile) []APIVideo {
+	if videoFiles == nil {
+		return nil
+	}
+
+	list := make([]APIVideo, 0, len(videoFiles))
+	for _, video := range videoFiles {
+		list = append(list, newAPIVideo(video))
+	}
+
+	return list
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// handleAPI routes the requests of the API:
+//
+//	GET /api/v1/videos[?folder=&viewed=true|false]
+//	GET /api/v1/videos/<id>
+//	GET, PATCH /api/v1/videos/<id>/progress
+//	PUT, DELETE /api/v1/videos/<id>/viewed
+//	GET /api/v1/stats[?days=]
+func handleAPI(w http.ResponseWriter, r *http.Request, videoFiles []VideoFile, path string) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, apiPrefix), "/"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] == "videos":
+		handleAPIVideos(w, r, videoFiles, path)
+	case len(parts) == 2 && parts[0] == "videos":
+		handleAPIVideo(w, r, videoFiles, path, parts[1])
+	case len(parts) == 3 && parts[0] == "videos" && parts[2] == "progress":
+		handleAPIProgress(w, r, path, parts[1])
+	case len(parts) == 3 && parts[0] == "videos" && parts[2] == "viewed":
+		handleAPIViewed(w, r, videoFiles, path, parts[1])
+	case len(parts) == 1 && parts[0] == "stats":
+		handleAPIStats(w, r, videoFiles, path)
+	default:
+		notFound(w, r)
+	}
+}
+
+func handleAPIVideos(w http.ResponseWriter, r *http.Request, videoFiles []VideoFile, path string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, "GET, HEAD")
+		return
+	}
+
+	query := r.URL.Query()
+	var viewed *bool
+	if value := query.Get("viewed"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			httpError(w, r, "Invalid viewed filter", http.StatusBadRequest)
+			return
+		}
+		viewed = &parsed
+	}
+
+	videos, err := savedVideoStates(path, videoFiles)
+	if err != nil {
+		log.Printf("Error loading video progress: %v", err)
+		httpError(w, r, "Error loading video progress", http.StatusInternalServerError)
+		return
+	}
+
+	list := []APIVideo{}
+	for _, video := range videos {
+		if query.Has("folder") && video.Folder != strings.Trim(query.Get("folder"), "/") {
+			continue
+		}
+		if viewed != nil && video.Viewed != *viewed {
+			continue
+		}
+		list = append(list, newAPIVideo(video))
+	}
+
+	writeJSON(w, http.StatusOK, struct{ Videos []APIVideo }{list})
+}
+
+func handleAPIVideo(w http.ResponseWriter, r *http.Request, videoFiles []VideoFile, path string, id string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, "GET, HEAD")
+		return
+	}
+
+	i := findVideoFile(videoFiles, id)
+	if i < 0 {
+		notFound(w, r)
+		return
+	}
+
+	videos, err := savedVideoStates(path, videoFiles[i:i+1])
+	if err != nil {
+		log.Printf("Error loading video progress: %v", err)
+		httpError(w, r, "Error loading video progress", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newAPIVideo(videos[0]))
+}
+
+// handleAPIProgress serves the watch state of the video, with its revision
+// in the ETag header, and applies the partial updates, as /api/progress/.
+func handleAPIProgress(w http.ResponseWriter, r *http.Request, path string, id string) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		handleGetProgress(w, r, path, id)
+	case http.MethodPatch:
+		handlePatchProgress(w, r, path, id)
+	default:
+		methodNotAllowed(w, r, "GET, HEAD, PATCH")
+	}
+}
+
+// handleAPIViewed marks the video as watched (PUT) or not (DELETE), and
+// returns it.
+func handleAPIViewed(w http.ResponseWriter, r *http.Request, videoFiles []VideoFile, path string, id string) {
+	var viewed bool
+	switch r.Method {
+	case http.MethodPut:
+		viewed = true
+	case http.MethodDelete:
+	default:
+		methodNotAllowed(w, r, "PUT, DELETE")
+		return
+	}
+
+	video, err := setViewed(videoFiles, path, id, viewed)
+	if errors.Is(err, errVideoNotFound) {
+		notFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("Error saving video progress: %v", err)
+		httpError(w, r, "Error saving video progress: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if viewed {
+		audit(r, path, auditViewed, video.Name, "")
+	} else {
+		audit(r, path, auditUnviewed, video.Name, "")
+	}
+
+	writeJSON(w, http.StatusOK, newAPIVideo(video))
+}
+
+func handleAPIStats(w http.ResponseWriter, r *http.Request, videoFiles []VideoFile, path string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, "GET, HEAD")
+		return
+	}
+
+	days, ok := statsDays(r)
+	if !ok {
+		httpError(w, r, "Invalid number of days", http.StatusBadRequest)
+		return
+	}
+
+	stats, err := libraryStats(path, videoFiles, days)
+	if err != nil {
+		log.Printf("Error loading video progress: %v", err)
+		httpError(w, r, "Error loading video progress", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, stats)
+}
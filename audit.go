@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	auditLogFile = "video_audit.log"
+
+	auditViewed        = "viewed"
+	auditUnviewed      = "unviewed"
+	auditProgressReset = "progress reset"
+	auditReview        = "review"
+	auditNotes         = "notes"
+	auditSettings      = "settings"
+	auditPlan          = "plan"
+	auditPlaylists     = "playlists"
+	auditUpload        = "upload"
+	auditRescan        = "rescan"
+	auditRestore       = "restore"
+	auditSync          = "sync"
+	auditStopSession   = "session stopped"
+	auditPurge         = "purge"
+	auditRelink        = "relink"
+)
+
+// AuditEntry records who changed the state of the library, and when.
+type AuditEntry struct {
+	Time   time.Time
+	User   string `json:",omitempty"`
+	IP     string
+	Action string
+	Video  string `json:",omitempty"`
+	Detail string `json:",omitempty"`
+}
+
+var auditMu sync.Mutex
+
+// requestUser returns the user identified by Tailscale, or authenticated by
+// a trusted reverse proxy in front of the viewer, if any. The headers of the
+// other clients are ignored, as they could name anyone.
+func requestUser(r *http.Request) string {
+	if user, ok := r.Context().Value(tailscaleUserKey{}).(string); ok {
+		return user
+	}
+	if addr, ok := requestAddr(r, nil); !ok || !networksContain(proxyNetworks, addr) {
+		return ""
+	}
+
+	if user, _, ok := r.BasicAuth(); ok {
+		return user
+	}
+	if user := r.Header.Get("Remote-User"); user != "" {
+		return user
+	}
+
+	return r.Header.Get("X-Forwarded-User")
+}
+
+// clientIP returns the address of the client, read from X-Forwarded-For
+// only behind the trusted proxies.
+func clientIP(r *http.Request) string {
+	if addr, ok := requestAddr(r, proxyNetworks); ok {
+		return addr.String()
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// audit appends an entry to the audit log of the library, one JSON object
+// per line.
+func audit(r *http.Request, path string, action string, video string, detail string) {
+	entry := AuditEntry{
+		Time:   time.Now(),
+		User:   requestUser(r),
+		IP:     clientIP(r),
+		Action: action,
+		Video:  video,
+		Detail: detail,
+	}
+
+	jsonData, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Error marshaling audit entry: %v", err)
+		return
+	}
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	file, err := os.OpenFile(filepath.Join(path, auditLogFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Printf("Error writing audit log: %v", err)
+		return
+	}
+	defer file.Close()
+
+	file.Write(append(jsonData, '\n'))
+}
+
+// loadAuditLog returns the entries of the audit log, the most recent first.
+func loadAuditLog(path string) ([]AuditEntry, error) {
+	file, err := os.Open(filepath.Join(path, auditLogFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	slices.Reverse(entries)
+
+	return entries, scanner.Err()
+}
+
+func createAuditTemplate() *template.Template {
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Audit log - {{.Title}}</title>
+    <style>
+        body {
+            font-family: Arial, sans-serif;
+            margin: 20px;
+        }
+        table {
+            border-collapse: collapse;
+        }
+        th, td {
+            border-bottom: 1px solid #ddd;
+            padding: 6px 10px;
+            text-align: left;
+        }
+    </style>
+</head>
+<body>
+    <p><a href="/">Back to the library</a></p>
+    <h1>Audit log</h1>
+    <p><a href="/audit?format=csv">Export as CSV</a></p>
+    {{if .Entries}}
+    <table>
+        <tr><th>Time</th><th>User</th><th>IP</th><th>Action</th><th>Video</th><th>Detail</th></tr>
+        {{range .Entries}}
+        <tr>
+            <td>{{.Time.Format "2006-01-02 15:04:05"}}</td>
+            <td>{{.User}}</td>
+            <td>{{.IP}}</td>
+            <td>{{.Action}}</td>
+            <td>{{.Video}}</td>
+            <td>{{.Detail}}</td>
+        </tr>
+        {{end}}
+    </table>
+    {{else}}
+    <p>No changes recorded yet.</p>
+    {{end}}
+</body>
+</html>`
+
+	return template.Must(template.New("audit").Parse(tmpl))
+}
+
+// handleAudit lists the audit log as a page, as JSON for API clients, or as
+// a CSV file with format=csv.
+func handleAudit(w http.ResponseWriter, r *http.Request, path string, tmpl *template.Template) {
+	entries, err := loadAuditLog(path)
+	if err != nil {
+		log.Printf("Error reading audit log: %v", err)
+		httpError(w, r, "Error reading audit log", http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case r.URL.Query().Get("format") == "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"Time", "User", "IP", "Action", "Video", "Detail"})
+		for _, entry := range entries {
+			writer.Write([]string{entry.Time.Format(time.RFC3339), entry.User, entry.IP, entry.Action, entry.Video, entry.Detail})
+		}
+		writer.Flush()
+	case acceptsJSON(r):
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	default:
+		data := struct {
+			Title   string
+			Entries []AuditEntry
+		}{
+			Title:   pageTitle,
+			Entries: entries,
+		}
+		tmpl.Execute(w, data)
+	}
+}
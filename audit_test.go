@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestRequestUser(t *testing.T) {
+	defer func(networks []netip.Prefix) { proxyNetworks = networks }(proxyNetworks)
+	proxyNetworks = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     string
+		value      string
+		want       string
+	}{
+		{name: "proxy Remote-User", remoteAddr: "10.0.0.1:1234", header: "Remote-User", value: "alice", want: "alice"},
+		{name: "proxy X-Forwarded-User", remoteAddr: "10.0.0.1:1234", header: "X-Forwarded-User", value: "alice", want: "alice"},
+		{name: "proxy basic auth", remoteAddr: "10.0.0.1:1234", header: "Authorization", value: "Basic YWxpY2U6c2VjcmV0", want: "alice"},
+		{name: "client Remote-User", remoteAddr: "192.168.1.2:1234", header: "Remote-User", value: "alice", want: ""},
+		{name: "client basic auth", remoteAddr: "192.168.1.2:1234", header: "Authorization", value: "Basic YWxpY2U6c2VjcmV0", want: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = test.remoteAddr
+			r.Header.Set(test.header, test.value)
+
+			if user := requestUser(r); user != test.want {
+				t.Errorf("requestUser() = %q, want %q", user, test.want)
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	defer func(networks []netip.Prefix) { proxyNetworks = networks }(proxyNetworks)
+	proxyNetworks = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	tests := []struct {
+		remoteAddr string
+		forwarded  string
+		want       string
+	}{
+		{remoteAddr: "192.168.1.2:1234", want: "192.168.1.2"},
+		{remoteAddr: "192.168.1.2:1234", forwarded: "6.6.6.6", want: "192.168.1.2"},
+		{remoteAddr: "10.0.0.1:1234", forwarded: "203.0.113.5", want: "203.0.113.5"},
+		{remoteAddr: "10.0.0.1:1234", forwarded: "garbage", want: "10.0.0.1"},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remoteAddr
+		if test.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+
+		if ip := clientIP(r); ip != test.want {
+			t.Errorf("clientIP() from %s forwarding %q = %q, want %q", test.remoteAddr, test.forwarded, ip, test.want)
+		}
+	}
+}
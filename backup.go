@@ -0,0 +1,148 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// stateFiles lists the files the viewer keeps in the library directory.
+var stateFiles = []string{videoDataFile, stateEventsFile, settingsFile, intakeLogFile, checksumFile, statsFile, notesFile, auditLogFile, queueFile, playedFile}
+
+func runBackup(path string, args []string) int {
+	archive := "videos-viewer-backup-" + time.Now().Format("20060102-150405") + ".zip"
+	if len(args) > 0 {
+		archive = args[0]
+	}
+
+	file, err := os.Create(archive)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating backup: %v\n", err)
+		return 1
+	}
+	defer file.Close()
+
+	count, err := writeBackup(file, path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing backup: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("%d files written to %s\n", count, archive)
+
+	return 0
+}
+
+// writeBackup writes the state files of the library as a zip archive, and
+// returns the number of files.
+func writeBackup(w io.Writer, path string) (int, error) {
+	zipWriter := zip.NewWriter(w)
+
+	count := 0
+	for _, name := range stateFiles {
+		content, err := os.ReadFile(filepath.Join(path, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("reading %s: %w", name, err)
+		}
+
+		writer, err := zipWriter.Create(name)
+		if err == nil {
+			_, err = writer.Write(content)
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		debug("Backed up %s", name)
+		count++
+	}
+
+	return count, zipWriter.Close()
+}
+
+func runRestore(path string, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "The restore command expects a backup file or --from-remote\n\n")
+		return 1
+	}
+
+	if args[0] == "--from-remote" {
+		return runRemoteRestore(path)
+	}
+
+	reader, err := zip.OpenReader(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening backup: %v\n", err)
+		return 1
+	}
+	defer reader.Close()
+
+	count, err := restoreBackup(&reader.Reader, path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("%d files restored to %s\n", count, path)
+
+	return 0
+}
+
+// restoreBackup puts the state files of the archive back into the library,
+// and returns the number of files.
+func restoreBackup(reader *zip.Reader, path string) (int, error) {
+	known := make(map[string]bool)
+	for _, name := range stateFiles {
+		known[name] = true
+	}
+
+	count := 0
+	for _, entry := range reader.File {
+		if !known[entry.Name] {
+			fmt.Fprintf(os.Stderr, "Skipping unknown file %s\n", entry.Name)
+			continue
+		}
+
+		if err := restoreFile(entry, filepath.Join(path, entry.Name)); err != nil {
+			return count, fmt.Errorf("restoring %s: %w", entry.Name, err)
+		}
+
+		debug("Restored %s", entry.Name)
+		count++
+	}
+
+	return count, nil
+}
+
+// restoreFile writes the entry next to its target first so an interrupted
+// restore never leaves a truncated state file behind.
+func restoreFile(entry *zip.File, target string) error {
+	source, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	tmp := target + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(file, source)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, target)
+}
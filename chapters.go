@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+type Chapter struct {
+	Title string
+	Start float64
+	End   float64
+}
+
+func handleChapters(w http.ResponseWriter, r *http.Request, videoFiles []VideoFile) {
+	i := findVideoFile(videoFiles, strings.TrimPrefix(r.URL.Path, "/chapters/"))
+	if i < 0 {
+		notFound(w, r)
+		return
+	}
+
+	chapters := []Chapter{}
+	if ffprobePath != "" {
+		probed, err := probeChapters(videoFiles[i].Path)
+		if err != nil {
+			log.Printf("Error reading chapters: %v", err)
+		} else {
+			chapters = probed
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(chapters)
+}
+
+func probeChapters(videoPath string) ([]Chapter, error) {
+	output, err := exec.Command(ffprobePath, "-v", "error", "-print_format", "json", "-show_chapters", videoPath).Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Chapters []struct {
+			StartTime string `json:"start_time"`
+			EndTime   string `json:"end_time"`
+			Tags      struct {
+				Title string `json:"title"`
+			} `json:"tags"`
+		} `json:"chapters"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return nil, err
+	}
+
+	chapters := make([]Chapter, 0, len(result.Chapters))
+	for i, c := range result.Chapters {
+		start, _ := strconv.ParseFloat(c.StartTime, 64)
+		end, _ := strconv.ParseFloat(c.EndTime, 64)
+
+		title := c.Tags.Title
+		if title == "" {
+			title = "Chapter " + strconv.Itoa(i+1)
+		}
+
+		chapters = append(chapters, Chapter{
+			Title: title,
+			Start: start,
+			End:   end,
+		})
+	}
+
+	return chapters, nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The manifest uses the sha256sum format, so it can also be checked with
+// `sha256sum -c video_checksums.sha256` from the library directory.
+const checksumFile = "video_checksums.sha256"
+
+// libraryFiles returns the files of the library relative to its root, except
+// the files written by the viewer itself.
+func libraryFiles(path string) ([]string, error) {
+	ignored := make(map[string]bool, len(stateFiles))
+	for _, name := range stateFiles {
+		ignored[name] = true
+	}
+
+	var files []string
+	err := filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if file == filepath.Join(path, snapshotDir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(path, file)
+		if err != nil {
+			return err
+		}
+
+		rel = filepath.ToSlash(rel)
+		if ignored[rel] || strings.HasSuffix(rel, ".part") || strings.HasSuffix(rel, watchedMarkerExt) {
+			return nil
+		}
+
+		files = append(files, rel)
+		return nil
+	})
+
+	sort.Strings(files)
+
+	return files, err
+}
+
+func hashFile(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// readManifest returns the checksums of a manifest by file.
+func readManifest(file string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseManifest(f)
+}
+
+func parseManifest(r io.Reader) (map[string]string, error) {
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		sum, file, ok := strings.Cut(scanner.Text(), "  ")
+		if !ok {
+			continue
+		}
+		sums[file] = sum
+	}
+
+	return sums, scanner.Err()
+}
+
+func runChecksum(path string) int {
+	count, err := writeChecksumManifest(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing manifest: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("%d files written to %s\n", count, filepath.Join(path, checksumFile))
+
+	return 0
+}
+
+// writeChecksumManifest hashes the library files into the manifest, and
+// returns their number.
+func writeChecksumManifest(path string) (int, error) {
+	files, err := libraryFiles(path)
+	if err != nil {
+		return 0, fmt.Errorf("listing files: %w", err)
+	}
+
+	var manifest strings.Builder
+	for _, file := range files {
+		sum, err := hashFile(filepath.Join(path, filepath.FromSlash(file)))
+		if err != nil {
+			return 0, fmt.Errorf("hashing %s: %w", file, err)
+		}
+
+		debug("%s  %s", sum, file)
+		fmt.Fprintf(&manifest, "%s  %s\n", sum, file)
+	}
+
+	if err := os.WriteFile(filepath.Join(path, checksumFile), []byte(manifest.String()), 0644); err != nil {
+		return 0, err
+	}
+
+	return len(files), nil
+}
+
+func runVerify(path string) int {
+	expected, err := readManifest(filepath.Join(path, checksumFile))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading manifest: %v\n", err)
+		return 1
+	}
+
+	files, err := libraryFiles(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error listing files: %v\n", err)
+		return 1
+	}
+
+	var changed, added []string
+	for _, file := range files {
+		sum, ok := expected[file]
+		if !ok {
+			added = append(added, file)
+			continue
+		}
+		delete(expected, file)
+
+		actual, err := hashFile(filepath.Join(path, filepath.FromSlash(file)))
+		if err != nil || actual != sum {
+			changed = append(changed, file)
+		}
+	}
+
+	var missing []string
+	for file := range expected {
+		missing = append(missing, file)
+	}
+	sort.Strings(missing)
+
+	for _, group := range []struct {
+		title string
+		files []string
+	}{
+		{"Changed", changed},
+		{"Missing", missing},
+		{"New", added},
+	} {
+		for _, file := range group.files {
+			fmt.Printf("%s: %s\n", group.title, file)
+		}
+	}
+
+	fmt.Printf("%d files verified, %d changed, %d missing, %d new\n", len(files)-len(added), len(changed), len(missing), len(added))
+
+	if len(changed) > 0 || len(missing) > 0 {
+		return 2
+	}
+
+	return 0
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLibraryFiles(t *testing.T) {
+	root := t.TempDir()
+
+	files := append([]string{"a.mp4", "Show/e01.mp4", "Show/e01.srt", "b.mp4.part", "a.mp4" + watchedMarkerExt, snapshotDir + "/2024-01-01.json"}, stateFiles...)
+	for _, file := range files {
+		name := filepath.Join(root, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(file), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	listed, err := libraryFiles(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"Show/e01.mp4", "Show/e01.srt", "a.mp4"}
+	if !reflect.DeepEqual(listed, want) {
+		t.Errorf("libraryFiles() = %v, want %v", listed, want)
+	}
+}
+
+func TestWriteChecksumManifest(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "a.mp4"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := writeChecksumManifest(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("writeChecksumManifest() = %d, want 1", count)
+	}
+
+	sums, err := readManifest(filepath.Join(root, checksumFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"a.mp4": "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb"}
+	if !reflect.DeepEqual(sums, want) {
+		t.Errorf("readManifest() = %v, want %v", sums, want)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const commandsUsage = `
+Commands:
+  backup        bundle the library state files into an archive: backup <directory_path> [archive]
+  restore       restore the library state files from an archive: restore <directory_path> <archive|--from-remote>
+  import        merge the watch state of another library, newest changes winning: import <directory_path> <video_data.json|video_events.log|archive>
+  export-notes  write the notes and bookmarks as Markdown files: export-notes <directory_path> <output_dir> [base_url]
+  export-anki   write the bookmarks as Anki flashcards: export-anki <directory_path> <output_file> [base_url]
+  report        print a health report of the library (exits with status 2 when issues are found)
+  checksum      write a SHA-256 manifest of the library files
+  digest        print the weekly progress digest of the library
+  update        replace the binary with the latest release, its checksum and signature verified: update [check|force]
+  verify        verify the library files against their manifest (exits with status 2 when files changed or are missing)
+`
+
+func runCommand(command string, path string, args []string) int {
+	switch command {
+	case "backup":
+		return runBackup(path, args)
+	case "restore":
+		return runRestore(path, args)
+	case "import":
+		return runImport(path, args)
+	case "export-notes":
+		return runExportNotes(path, args)
+	case "export-anki":
+		return runExportAnki(path, args)
+	case "report":
+		return runReport(path)
+	case "checksum":
+		return runChecksum(path)
+	case "digest":
+		return runDigest(path)
+	case "verify":
+		return runVerify(path)
+	}
+
+	fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", command)
+
+	return 1
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// preventCrossOrigin rejects the state-changing requests sent by other
+// websites, so that a page cannot mark videos or change the settings on
+// behalf of the user. Browsers tell where a request comes from with the
+// Sec-Fetch-Site header, or with the Origin header for the older ones.
+// Requests carrying neither, such as those of scripts, are not from a
+// browser and are accepted.
+func preventCrossOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !sameOrigin(r) {
+			httpError(w, r, "Cross-origin request rejected", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func sameOrigin(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return true
+	case "":
+	default:
+		return false
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+
+	return err == nil && u.Host == r.Host
+}
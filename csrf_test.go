@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSameOrigin(t *testing.T) {
+	tests := []struct {
+		name      string
+		fetchSite string
+		origin    string
+		want      bool
+	}{
+		{name: "script", want: true},
+		{name: "same origin", fetchSite: "same-origin", want: true},
+		{name: "typed URL", fetchSite: "none", want: true},
+		{name: "same site", fetchSite: "same-site", want: false},
+		{name: "cross site", fetchSite: "cross-site", want: false},
+		{name: "cross site with same Origin", fetchSite: "cross-site", origin: "http://videos.local:8080", want: false},
+		{name: "same Origin", origin: "http://videos.local:8080", want: true},
+		{name: "other Origin", origin: "http://evil.example", want: false},
+		{name: "other port", origin: "http://videos.local:9090", want: false},
+		{name: "null Origin", origin: "null", want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "http://videos.local:8080/viewed", nil)
+			if test.fetchSite != "" {
+				r.Header.Set("Sec-Fetch-Site", test.fetchSite)
+			}
+			if test.origin != "" {
+				r.Header.Set("Origin", test.origin)
+			}
+
+			if got := sameOrigin(r); got != test.want {
+				t.Errorf("sameOrigin() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const digestPeriod = 7 * 24 * time.Hour
+
+var (
+	smtpAddr     string
+	smtpUser     string
+	smtpPassword string
+	smtpFrom     string
+	digestTo     string
+)
+
+func digestEnabled() bool {
+	return smtpAddr != "" && digestTo != ""
+}
+
+func digestFile(path string) string {
+	return filepath.Join(libraryCacheDir(path), "digest-sent")
+}
+
+// startDigest emails the weekly digest, checking every hour whether a week
+// passed since the previous one. The first digest is sent a week after it is
+// enabled.
+func startDigest(path string) {
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+
+		for ; ; <-ticker.C {
+			content, err := os.ReadFile(digestFile(path))
+			last, parseErr := time.Parse(time.RFC3339, strings.TrimSpace(string(content)))
+			if err != nil || parseErr != nil {
+				saveDigestTime(path, time.Now())
+				continue
+			}
+			if time.Since(last) < digestPeriod {
+				continue
+			}
+
+			var body strings.Builder
+			if err := writeDigest(&body, path, last); err != nil {
+				log.Printf("Error building the weekly digest: %v", err)
+				continue
+			}
+			if err := sendDigest(body.String()); err != nil {
+				log.Printf("Error sending the weekly digest: %v", err)
+				continue
+			}
+
+			debug("Weekly digest sent to %s", digestTo)
+			saveDigestTime(path, time.Now())
+		}
+	}()
+}
+
+func saveDigestTime(path string, t time.Time) {
+	if err := os.MkdirAll(libraryCacheDir(path), 0755); err != nil {
+		log.Printf("Error saving the digest time: %v", err)
+		return
+	}
+
+	if err := os.WriteFile(digestFile(path), []byte(t.Format(time.RFC3339)), 0644); err != nil {
+		log.Printf("Error saving the digest time: %v", err)
+	}
+}
+
+// writeDigest summarizes the activity since the given time, and the runtime
+// left in each folder of the library.
+func writeDigest(w io.Writer, path string, since time.Time) error {
+	videoFiles, err := loadVideoFiles(path)
+	if err != nil {
+		return err
+	}
+
+	stats := statsSince(path, since)
+	fmt.Fprintf(w, "%s - activity since %s\n\n", pageTitle, since.Format(time.DateOnly))
+	fmt.Fprintf(w, "Minutes watched: %d\n", int(stats.Watched/60))
+	if stats.Focused > 0 {
+		fmt.Fprintf(w, "Focused minutes: %d\n", int(stats.Focused/60))
+	}
+	fmt.Fprintf(w, "Videos completed: %d\n", stats.Completed)
+	if stats.Served > 0 {
+		fmt.Fprintf(w, "Data served: %s\n", formatBytes(stats.Served))
+	}
+	fmt.Fprintln(w)
+
+	type course struct {
+		left      int
+		remaining float64
+	}
+	courses := make(map[string]*course)
+	var names []string
+	for _, video := range videoFiles {
+		folder := videoFolder(video, path)
+		if courses[folder] == nil {
+			courses[folder] = &course{}
+			names = append(names, folder)
+		}
+		if !video.Viewed {
+			courses[folder].left++
+			courses[folder].remaining += max(probeDuration(video.Path)-video.Progress, 0)
+		}
+	}
+
+	fmt.Fprintln(w, "Remaining:")
+	for _, name := range names {
+		c := courses[name]
+		if c.left == 0 {
+			continue
+		}
+		if name == "" {
+			name = pageTitle
+		}
+		if c.remaining > 0 {
+			fmt.Fprintf(w, "  %s: %d videos, %s\n", name, c.left, time.Duration(c.remaining)*time.Second)
+		} else {
+			fmt.Fprintf(w, "  %s: %d videos\n", name, c.left)
+		}
+	}
+
+	return nil
+}
+
+func sendDigest(body string) error {
+	from := smtpFrom
+	if from == "" {
+		from = smtpUser
+	}
+
+	var auth smtp.Auth
+	if smtpUser != "" {
+		host, _, err := net.SplitHostPort(smtpAddr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", smtpUser, smtpPassword, host)
+	}
+
+	recipients := strings.Split(digestTo, ",")
+	message := "From: " + from + "\r\n" +
+		"To: " + digestTo + "\r\n" +
+		"Subject: Weekly progress - " + pageTitle + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" + strings.ReplaceAll(body, "\n", "\r\n")
+
+	return smtp.SendMail(smtpAddr, auth, from, recipients, []byte(message))
+}
+
+func runDigest(path string) int {
+	if pageTitle == "" {
+		pageTitle = filepath.Base(path)
+	}
+
+	if err := writeDigest(os.Stdout, path, time.Now().Add(-digestPeriod)); err != nil {
+		fmt.Fprintf(os.Stderr, "Error building the digest: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import "syscall"
+
+// diskSpace returns the free and total bytes of the disk holding path.
+func diskSpace(path string) (uint64, uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, err
+	}
+
+	return uint64(stat.Bavail) * uint64(stat.Bsize), uint64(stat.Blocks) * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"archive/zip"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func handleDownload(w http.ResponseWriter, r *http.Request, videoFiles []VideoFile) {
+	if !allowDownload {
+		notFound(w, r)
+		return
+	}
+
+	i := findVideoFile(videoFiles, strings.TrimPrefix(r.URL.Path, "/download/"))
+	if i < 0 {
+		notFound(w, r)
+		return
+	}
+	if !videoFileExists(videoFiles[i]) {
+		videoFileMissing(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": videoFiles[i].Name}))
+	http.ServeFile(countTraffic(w, r, videoFiles[i]), r, videoFiles[i].Path)
+}
+
+func handleZip(w http.ResponseWriter, r *http.Request, videoFiles []VideoFile, path string) {
+	if !allowDownload {
+		notFound(w, r)
+		return
+	}
+
+	folder := strings.Trim(strings.TrimPrefix(r.URL.Path, "/zip/"), "/")
+	unwatchedOnly := r.URL.Query().Get("unwatched") == "1"
+
+	var files []VideoFile
+	for _, video := range videoFiles {
+		if unwatchedOnly && video.Viewed {
+			continue
+		}
+		if folder == "" || strings.HasPrefix(videoFolder(video, path)+"/", folder+"/") {
+			files = append(files, video)
+		}
+	}
+
+	if len(files) == 0 {
+		notFound(w, r)
+		return
+	}
+
+	archiveName := filepath.Base(path)
+	if folder != "" {
+		archiveName = filepath.Base(folder)
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": archiveName + ".zip"}))
+
+	archive := zip.NewWriter(w)
+	for _, video := range files {
+		if err := addToZip(archive, video, path); err != nil {
+			log.Printf("Error adding \"%s\" to archive: %v", video.Path, err)
+			return
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		log.Printf("Error closing archive: %v", err)
+	}
+}
+
+func addToZip(archive *zip.Writer, video VideoFile, path string) error {
+	file, err := os.Open(video.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+
+	name, err := filepath.Rel(path, video.Path)
+	if err != nil {
+		return err
+	}
+
+	// Videos are already compressed, storing them keeps the stream fast.
+	header.Name = filepath.ToSlash(name)
+	header.Method = zip.Store
+
+	writer, err := archive.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(writer, file)
+	return err
+}
+
+// videoFolder returns the folder of a video relative to the library root,
+// using forward slashes, or "" for videos at the root.
+func videoFolder(video VideoFile, path string) string {
+	rel, err := filepath.Rel(path, filepath.Dir(video.Path))
+	if err != nil || rel == "." {
+		return ""
+	}
+
+	return filepath.ToSlash(rel)
+}
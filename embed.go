@@ -0,0 +1,78 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+type EmbedData struct {
+	Video      *VideoFile
+	StartTime  float64
+	Autoplay   bool
+	Muted      bool
+	Thumbnails bool
+}
+
+func createEmbedTemplate() *template.Template {
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>{{.Video.DisplayName}}</title>
+    <style>
+        html, body {
+            margin: 0;
+            height: 100%;
+            background: #000;
+        }
+        video {
+            display: block;
+            width: 100%;
+            height: 100%;
+        }
+    </style>
+</head>
+<body>
+    <video controls {{if .Autoplay}}autoplay{{end}} {{if .Muted}}muted{{end}} {{if .Thumbnails}}poster="/thumbnail/{{.Video.ID}}"{{end}}>
+        <source src="/video/{{.Video.ID}}" type="video/mp4">
+        Your browser does not support the video tag.
+    </video>
+    <script>
+        document.querySelector('video').addEventListener('loadedmetadata', function() {
+            this.currentTime = {{.StartTime}};
+        });
+    </script>
+</body>
+</html>`
+
+	return template.Must(template.New("embed").Parse(tmpl))
+}
+
+func handleEmbed(w http.ResponseWriter, r *http.Request, videoFiles []VideoFile, tmpl *template.Template) {
+	i := findVideoFile(videoFiles, strings.TrimPrefix(r.URL.Path, "/embed/"))
+	if i < 0 {
+		notFound(w, r)
+		return
+	}
+	currentVideo := &videoFiles[i]
+
+	query := r.URL.Query()
+	data := EmbedData{
+		Video:      currentVideo,
+		Autoplay:   query.Get("autoplay") == "1",
+		Muted:      query.Get("muted") == "1",
+		Thumbnails: thumbnailsEnabled(),
+	}
+
+	if t := query.Get("t"); t != "" {
+		startTime, err := parseTimestamp(t)
+		if err != nil {
+			httpError(w, r, "Invalid timestamp", http.StatusBadRequest)
+			return
+		}
+		data.StartTime = startTime
+	}
+
+	tmpl.Execute(w, data)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var episodePatterns = []*regexp.Regexp{
+	// Show.Name.S01E02.Title
+	regexp.MustCompile(`(?i)^(?P<show>.*?)[ ._-]*\bs(?P<season>\d{1,2})[ ._-]?e(?P<episode>\d{1,3})\b(?P<title>.*)$`),
+	// Show Name 1x02 Title
+	regexp.MustCompile(`(?i)^(?P<show>.*?)[ ._-]*\b(?P<season>\d{1,2})x(?P<episode>\d{2,3})\b(?P<title>.*)$`),
+	// Show Name Episode 3 Title
+	regexp.MustCompile(`(?i)^(?P<show>.*?)[ ._-]*\b(?:episode|ep)[ ._-]*(?P<episode>\d{1,3})\b(?P<title>.*)$`),
+}
+
+// parseEpisode fills the series metadata of a video from its file name.
+// Videos whose name does not look like an episode are left untouched.
+func parseEpisode(video *VideoFile) {
+	base := strings.TrimSuffix(video.Name, filepath.Ext(video.Name))
+
+	for _, pattern := range episodePatterns {
+		match := pattern.FindStringSubmatch(base)
+		if match == nil {
+			continue
+		}
+
+		for i, group := range pattern.SubexpNames() {
+			switch group {
+			case "show":
+				video.Show = cleanTitle(match[i])
+			case "season":
+				video.Season, _ = strconv.Atoi(match[i])
+			case "episode":
+				video.Episode, _ = strconv.Atoi(match[i])
+			case "title":
+				video.EpisodeTitle = cleanTitle(match[i])
+			}
+		}
+
+		return
+	}
+}
+
+// DisplayName returns the name shown in the UI.
+func (v VideoFile) DisplayName() string {
+	if v.Title != "" {
+		return v.Title
+	}
+	if v.Episode == 0 {
+		return v.Name
+	}
+
+	var number string
+	if v.Season > 0 {
+		number = fmt.Sprintf("S%02dE%02d", v.Season, v.Episode)
+	} else {
+		number = fmt.Sprintf("Episode %d", v.Episode)
+	}
+
+	name := number
+	if v.Show != "" {
+		name = v.Show + " " + name
+	}
+	if v.EpisodeTitle != "" {
+		name += " - " + v.EpisodeTitle
+	}
+
+	return name
+}
+
+// cleanTitle turns a file name fragment ("My.Show_Name - ") into a title.
+func cleanTitle(value string) string {
+	value = strings.NewReplacer(".", " ", "_", " ").Replace(value)
+	value = strings.Trim(strings.Join(strings.Fields(value), " "), " -")
+
+	return value
+}
+
+type ShowGroup struct {
+	Name    string
+	Seasons []SeasonGroup
+}
+
+type SeasonGroup struct {
+	Number int
+	Videos []VideoFile
+	Viewed int
+}
+
+// Completion returns the percentage of watched videos of the season.
+func (s SeasonGroup) Completion() int {
+	if len(s.Videos) == 0 {
+		return 0
+	}
+
+	return s.Viewed * 100 / len(s.Videos)
+}
+
+// groupBySeries groups the videos by show and season, whatever the folders
+// they are stored in. Videos that are not episodes are grouped in a show
+// without name, listed last.
+func groupBySeries(videoFiles []VideoFile) []ShowGroup {
+	videos := make([]VideoFile, len(videoFiles))
+	copy(videos, videoFiles)
+	sortVideoFiles(videos, sortByNumber)
+
+	var shows []ShowGroup
+	index := make(map[string]int)
+	for _, video := range videos {
+		show := video.Show
+		if video.Episode == 0 {
+			show = ""
+		}
+
+		i, ok := index[show]
+		if !ok {
+			i = len(shows)
+			index[show] = i
+			shows = append(shows, ShowGroup{Name: show})
+		}
+
+		seasons := shows[i].Seasons
+		if len(seasons) == 0 || seasons[len(seasons)-1].Number != video.Season {
+			seasons = append(seasons, SeasonGroup{Number: video.Season})
+		}
+
+		season := &seasons[len(seasons)-1]
+		season.Videos = append(season.Videos, video)
+		if video.Viewed {
+			season.Viewed++
+		}
+
+		shows[i].Seasons = seasons
+	}
+
+	sort.SliceStable(shows, func(i, j int) bool {
+		if shows[i].Name == "" || shows[j].Name == "" {
+			return shows[j].Name == "" && shows[i].Name != ""
+		}
+		return strings.ToLower(shows[i].Name) < strings.ToLower(shows[j].Name)
+	})
+
+	return shows
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// ErrorResponse is the body of the errors sent to API clients.
+type ErrorResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+var errorTemplate = template.Must(template.New("error").Parse(`
+<!DOCTYPE html>
+<html>
+<head>
+    <title>{{.Status}} - {{.Title}}</title>
+    <style>
+        body {
+            font-family: Arial, sans-serif;
+            margin: 20px;
+        }
+        .error-code {
+            color: #666;
+        }
+    </style>
+</head>
+<body>
+    <h1>{{.Status}}</h1>
+    <p>{{.Message}}</p>
+    <p class="error-code">Error {{.Code}}</p>
+    <p><a href="/">Back to the library</a></p>
+</body>
+</html>`))
+
+// acceptsJSON reports whether the client asked for JSON rather than HTML,
+// which the API always answers.
+func acceptsJSON(r *http.Request) bool {
+	if strings.HasPrefix(r.URL.Path, apiPrefix) {
+		return true
+	}
+
+	accept := r.Header.Get("Accept")
+	if strings.Contains(accept, "application/json") {
+		return true
+	}
+
+	return !strings.Contains(accept, "text/html") && strings.HasPrefix(r.Header.Get("Content-Type"), "application/json")
+}
+
+// httpError replies with an error page for browsers, a JSON ErrorResponse
+// for API clients and plain text otherwise.
+func httpError(w http.ResponseWriter, r *http.Request, message string, code int) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Del("ETag")
+	h.Set("X-Content-Type-Options", "nosniff")
+
+	switch {
+	case acceptsJSON(r):
+		h.Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(ErrorResponse{Code: code, Message: message})
+	case strings.Contains(r.Header.Get("Accept"), "text/html"):
+		h.Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(code)
+		data := struct {
+			Title   string
+			Status  string
+			Message string
+			Code    int
+		}{
+			Title:   pageTitle,
+			Status:  http.StatusText(code),
+			Message: message,
+			Code:    code,
+		}
+		if err := errorTemplate.Execute(w, data); err != nil {
+			log.Printf("Error rendering error page: %v", err)
+		}
+	default:
+		http.Error(w, message, code)
+	}
+}
+
+func notFound(w http.ResponseWriter, r *http.Request) {
+	httpError(w, r, "The page or video could not be found", http.StatusNotFound)
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request, allowed string) {
+	w.Header().Set("Allow", allowed)
+	httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	eventVideoAdded      = "video.added"
+	eventVideoCompleted  = "video.completed"
+	eventCourseCompleted = "course.completed"
+	eventDiskLow         = "disk.low"
+)
+
+// Event is published on the internal event bus and delivered to the
+// configured webhook.
+type Event struct {
+	Type    string
+	Time    time.Time
+	Library string
+	Video   string
+	Folder  string
+	Detail  string `json:",omitempty"`
+}
+
+var (
+	eventHandlers   []func(Event)
+	eventHandlersMu sync.Mutex
+
+	webhookClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+func subscribe(handler func(Event)) {
+	eventHandlersMu.Lock()
+	defer eventHandlersMu.Unlock()
+
+	eventHandlers = append(eventHandlers, handler)
+}
+
+// publish delivers the event to every subscriber in the background, so a slow
+// subscriber never delays a scan.
+func publish(event Event) {
+	eventHandlersMu.Lock()
+	defer eventHandlersMu.Unlock()
+
+	debug("Event %s: %s", event.Type, event.Video)
+	for _, handler := range eventHandlers {
+		go handler(event)
+	}
+}
+
+// publishNewVideos publishes an event for each video of the new scan that was
+// not part of the previous one.
+func publishNewVideos(previous []VideoFile, current []VideoFile, path string) {
+	known := make(map[string]bool)
+	for _, video := range previous {
+		known[video.Path] = true
+	}
+
+	for _, video := range current {
+		if !known[video.Path] {
+			publish(Event{
+				Type:    eventVideoAdded,
+				Time:    time.Now(),
+				Library: pageTitle,
+				Video:   video.Name,
+				Folder:  videoFolder(video, path),
+			})
+		}
+	}
+}
+
+// publishCompletion publishes the completion of the i-th video, and of its
+// folder when it was the last unwatched video in it.
+func publishCompletion(videoFiles []VideoFile, i int, path string) {
+	folder := videoFolder(videoFiles[i], path)
+	publish(Event{
+		Type:    eventVideoCompleted,
+		Time:    time.Now(),
+		Library: pageTitle,
+		Video:   videoFiles[i].Name,
+		Folder:  folder,
+	})
+
+	for _, video := range videoFiles {
+		if !video.Viewed && videoFolder(video, path) == folder {
+			return
+		}
+	}
+
+	publish(Event{
+		Type:    eventCourseCompleted,
+		Time:    time.Now(),
+		Library: pageTitle,
+		Folder:  folder,
+	})
+}
+
+func startScanner(interval time.Duration, rescan func()) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			rescan()
+		}
+	}()
+}
+
+func sendWebhook(url string, event Event) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error marshaling event: %v", err)
+		return
+	}
+
+	resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(body))
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			err = fmt.Errorf("unexpected status %s", resp.Status)
+		}
+	}
+	if err != nil {
+		log.Printf("Error sending webhook: %v", err)
+	}
+}
+
+func handleRescan(w http.ResponseWriter, r *http.Request, path string, rescan func()) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+
+	rescan()
+	audit(r, path, auditRescan, "", "")
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
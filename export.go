@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const defaultExportBaseURL = "http://localhost:8080"
+
+func watchURL(base string, video VideoFile, seconds float64) string {
+	link := strings.TrimSuffix(base, "/") + "/watch/" + video.ID
+	if seconds > 0 {
+		link += fmt.Sprintf("?t=%d", int(seconds))
+	}
+
+	return link
+}
+
+// writeMarkdownNotes writes the notes and bookmarks of a video as a Markdown
+// file with a YAML front matter, as used by Obsidian.
+func writeMarkdownNotes(file string, video VideoFile, notes VideoNotes, path string, base string) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "---\nvideo: %q\n", video.Name)
+	if folder := videoFolder(video, path); folder != "" {
+		fmt.Fprintf(&b, "folder: %q\n", folder)
+	}
+	fmt.Fprintf(&b, "watched: %t\nsource: %s\n---\n\n", video.Viewed, watchURL(base, video, 0))
+	fmt.Fprintf(&b, "# %s\n\n", video.DisplayName())
+
+	if notes.Note != "" {
+		fmt.Fprintf(&b, "%s\n\n", notes.Note)
+	}
+
+	if len(notes.Bookmarks) > 0 {
+		b.WriteString("## Bookmarks\n\n")
+		for _, bookmark := range notes.Bookmarks {
+			fmt.Fprintf(&b, "- [%s](%s) %s\n", formatTimestamp(bookmark.Time), watchURL(base, video, bookmark.Time), bookmark.Text)
+		}
+	}
+
+	return os.WriteFile(file, []byte(b.String()), 0644)
+}
+
+func runExportNotes(path string, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Missing the output directory")
+		return 1
+	}
+
+	dir, base := args[0], defaultExportBaseURL
+	if len(args) > 1 {
+		base = args[1]
+	}
+
+	videoFiles, err := loadVideoFiles(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading video files: %v\n", err)
+		return 1
+	}
+
+	notes, err := loadNotes(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading notes: %v\n", err)
+		return 1
+	}
+
+	count := 0
+	for _, video := range videoFiles {
+		videoNotes, ok := notes[video.Name]
+		if !ok {
+			continue
+		}
+
+		// The folders of the library are kept, so that notes of different
+		// courses do not end up mixed.
+		folder := filepath.Join(dir, filepath.FromSlash(videoFolder(video, path)))
+		if err := os.MkdirAll(folder, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating %s: %v\n", folder, err)
+			return 1
+		}
+
+		file := filepath.Join(folder, strings.TrimSuffix(video.Name, filepath.Ext(video.Name))+".md")
+		if err := writeMarkdownNotes(file, video, videoNotes, path, base); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing %s: %v\n", file, err)
+			return 1
+		}
+
+		debug("Exported the notes of \"%s\"", video.Name)
+		count++
+	}
+
+	fmt.Printf("%d files written to %s\n", count, dir)
+
+	return 0
+}
+
+// ankiField escapes a value for a field of an Anki TSV file with HTML
+// enabled.
+func ankiField(value string) string {
+	value = html.EscapeString(value)
+	value = strings.ReplaceAll(value, "\t", " ")
+
+	return strings.ReplaceAll(strings.ReplaceAll(value, "\r\n", "\n"), "\n", "<br>")
+}
+
+// ankiTag turns a folder into an Anki tag, which cannot contain spaces.
+func ankiTag(folder string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(folder, " ", "_"), "/", "::")
+}
+
+// runExportAnki writes the bookmarks that have a text as an Anki TSV file:
+// the text on the front, the video and a link to the bookmarked time on the
+// back, and the folder as tag.
+func runExportAnki(path string, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Missing the output file")
+		return 1
+	}
+
+	file, base := args[0], defaultExportBaseURL
+	if len(args) > 1 {
+		base = args[1]
+	}
+
+	videoFiles, err := loadVideoFiles(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading video files: %v\n", err)
+		return 1
+	}
+
+	notes, err := loadNotes(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading notes: %v\n", err)
+		return 1
+	}
+
+	var b strings.Builder
+	b.WriteString("#separator:tab\n#html:true\n#tags column:3\n")
+
+	count := 0
+	for _, video := range videoFiles {
+		for _, bookmark := range notes[video.Name].Bookmarks {
+			if bookmark.Text == "" {
+				continue
+			}
+
+			back := fmt.Sprintf(`%s at %s<br><a href="%s">source</a>`,
+				ankiField(video.DisplayName()), formatTimestamp(bookmark.Time), html.EscapeString(watchURL(base, video, bookmark.Time)))
+			fmt.Fprintf(&b, "%s\t%s\t%s\n", ankiField(bookmark.Text), back, ankiTag(videoFolder(video, path)))
+			count++
+		}
+	}
+
+	if err := os.WriteFile(file, []byte(b.String()), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing %s: %v\n", file, err)
+		return 1
+	}
+
+	fmt.Printf("%d cards written to %s\n", count, file)
+
+	return 0
+}
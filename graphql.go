@@ -0,0 +1,626 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The GraphQL endpoint supports the subset of the language needed to query
+// the library: a single query operation with aliases, arguments and
+// variables. Fragments, directives and mutations are not supported.
+
+const (
+	// maxGraphDepth bounds the nesting of the selection sets and list values
+	// of a query, deeper than the schema, which the parser recurses on.
+	maxGraphDepth = 16
+
+	maxGraphRequestSize = 1 << 20
+)
+
+type graphField struct {
+	Alias  string
+	Name   string
+	Args   map[string]any
+	Fields []graphField
+}
+
+type graphResolver func(args map[string]any) (any, error)
+
+// graphObject maps the fields of an object type to their resolvers, so that
+// expensive fields (durations, nested lists) are only computed when queried.
+type graphObject map[string]graphResolver
+
+// graphResult keeps the fields of a response in the order of the query.
+type graphResult []struct {
+	Key   string
+	Value any
+}
+
+func (r graphResult) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, entry := range r {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		key, _ := json.Marshal(entry.Key)
+		value, err := json.Marshal(entry.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+func tokenizeGraphQuery(query string) ([]string, error) {
+	var tokens []string
+	runes := []rune(query)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r) || r == ',':
+		case r == '#':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case strings.ContainsRune("{}():!$[]=", r):
+			tokens = append(tokens, string(r))
+		case r == '.':
+			if !strings.HasPrefix(string(runes[i:]), "...") {
+				return nil, fmt.Errorf("unexpected character %q", r)
+			}
+			tokens = append(tokens, "...")
+			i += 2
+		case r == '"':
+			start := i
+			for i++; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, string(runes[start:i+1]))
+		case r == '_' || r == '-' || unicode.IsLetter(r) || unicode.IsDigit(r):
+			start := i
+			for i+1 < len(runes) && (runes[i+1] == '_' || runes[i+1] == '.' || unicode.IsLetter(runes[i+1]) || unicode.IsDigit(runes[i+1])) {
+				i++
+			}
+			tokens = append(tokens, string(runes[start:i+1]))
+		default:
+			return nil, fmt.Errorf("unexpected character %q", r)
+		}
+	}
+
+	return tokens, nil
+}
+
+type graphParser struct {
+	tokens    []string
+	pos       int
+	depth     int
+	variables map[string]any
+}
+
+func (p *graphParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+
+	return ""
+}
+
+func (p *graphParser) next() string {
+	token := p.peek()
+	p.pos++
+
+	return token
+}
+
+// enter goes one level deeper in the query, failing past maxGraphDepth.
+func (p *graphParser) enter() error {
+	p.depth++
+	if p.depth > maxGraphDepth {
+		return fmt.Errorf("the query is nested deeper than %d levels", maxGraphDepth)
+	}
+
+	return nil
+}
+
+func (p *graphParser) expect(token string) error {
+	if next := p.next(); next != token {
+		return fmt.Errorf("expected %q, found %q", token, next)
+	}
+
+	return nil
+}
+
+func parseGraphQuery(query string, variables map[string]any) ([]graphField, error) {
+	tokens, err := tokenizeGraphQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &graphParser{tokens: tokens, variables: variables}
+	switch p.peek() {
+	case "query":
+		p.next()
+		if p.peek() != "{" && p.peek() != "(" {
+			p.next()
+		}
+		if p.peek() == "(" {
+			// Variable definitions are not type checked, their values are
+			// taken as is from the request.
+			for p.peek() != ")" && p.peek() != "" {
+				p.next()
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+		}
+	case "mutation", "subscription":
+		return nil, fmt.Errorf("only queries are supported")
+	}
+
+	fields, err := p.parseSelectionSet()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() != "" {
+		return nil, fmt.Errorf("only a single operation is supported")
+	}
+
+	return fields, nil
+}
+
+func (p *graphParser) parseSelectionSet() ([]graphField, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+
+	var fields []graphField
+	for p.peek() != "}" {
+		switch p.peek() {
+		case "":
+			return nil, fmt.Errorf("unexpected end of query")
+		case "...":
+			return nil, fmt.Errorf("fragments are not supported")
+		}
+
+		field := graphField{Name: p.next()}
+		if p.peek() == ":" {
+			p.next()
+			field.Alias = field.Name
+			field.Name = p.next()
+		}
+
+		if p.peek() == "(" {
+			p.next()
+			field.Args = make(map[string]any)
+			for p.peek() != ")" {
+				name := p.next()
+				if err := p.expect(":"); err != nil {
+					return nil, err
+				}
+
+				value, err := p.parseValue()
+				if err != nil {
+					return nil, err
+				}
+				field.Args[name] = value
+			}
+			p.next()
+		}
+
+		if p.peek() == "{" {
+			subfields, err := p.parseSelectionSet()
+			if err != nil {
+				return nil, err
+			}
+			field.Fields = subfields
+		}
+
+		fields = append(fields, field)
+	}
+	p.next()
+
+	return fields, nil
+}
+
+func (p *graphParser) parseValue() (any, error) {
+	token := p.next()
+	switch {
+	case token == "" || token == ")":
+		return nil, fmt.Errorf("expected a value")
+	case token == "$":
+		return p.variables[p.next()], nil
+	case token == "[":
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		defer func() { p.depth-- }()
+
+		var values []any
+		for p.peek() != "]" {
+			value, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		p.next()
+		return values, nil
+	case strings.HasPrefix(token, `"`):
+		return strconv.Unquote(token)
+	case token == "true" || token == "false":
+		return token == "true", nil
+	case token == "null":
+		return nil, nil
+	}
+
+	if n, err := strconv.ParseFloat(token, 64); err == nil {
+		return n, nil
+	}
+
+	// Enum values are passed as strings.
+	return token, nil
+}
+
+func executeGraphQuery(object graphObject, fields []graphField) (graphResult, error) {
+	var result graphResult
+	for _, field := range fields {
+		key := field.Name
+		if field.Alias != "" {
+			key = field.Alias
+		}
+
+		resolve, ok := object[field.Name]
+		if !ok {
+			return nil, fmt.Errorf("cannot query field %q", field.Name)
+		}
+
+		value, err := resolve(field.Args)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", field.Name, err)
+		}
+
+		value, err = completeGraphValue(value, field)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, struct {
+			Key   string
+			Value any
+		}{key, value})
+	}
+
+	return result, nil
+}
+
+func completeGraphValue(value any, field graphField) (any, error) {
+	switch value := value.(type) {
+	case graphObject:
+		if value == nil {
+			return nil, nil
+		}
+		if field.Fields == nil {
+			return nil, fmt.Errorf("field %q must have a selection of subfields", field.Name)
+		}
+		return executeGraphQuery(value, field.Fields)
+	case []graphObject:
+		if field.Fields == nil {
+			return nil, fmt.Errorf("field %q must have a selection of subfields", field.Name)
+		}
+		results := make([]graphResult, 0, len(value))
+		for _, object := range value {
+			result, err := executeGraphQuery(object, field.Fields)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, result)
+		}
+		return results, nil
+	}
+
+	if field.Fields != nil {
+		return nil, fmt.Errorf("field %q has no subfields", field.Name)
+	}
+
+	return value, nil
+}
+
+func graphValue(value any) graphResolver {
+	return func(map[string]any) (any, error) { return value, nil }
+}
+
+func graphTime(value time.Time) any {
+	if value.IsZero() {
+		return nil
+	}
+
+	return value.Format(time.RFC3339)
+}
+
+func stringArg(args map[string]any, name string) (string, bool, error) {
+	value, ok := args[name]
+	if !ok || value == nil {
+		return "", false, nil
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return "", false, fmt.Errorf("argument %q must be a string", name)
+	}
+
+	return s, true, nil
+}
+
+func intArg(args map[string]any, name string) (int, bool, error) {
+	value, ok := args[name]
+	if !ok || value == nil {
+		return 0, false, nil
+	}
+
+	n, ok := value.(float64)
+	if !ok || n != float64(int(n)) {
+		return 0, false, fmt.Errorf("argument %q must be an integer", name)
+	}
+
+	return int(n), true, nil
+}
+
+func boolArg(args map[string]any, name string) (bool, bool, error) {
+	value, ok := args[name]
+	if !ok || value == nil {
+		return false, false, nil
+	}
+
+	b, ok := value.(bool)
+	if !ok {
+		return false, false, fmt.Errorf("argument %q must be a boolean", name)
+	}
+
+	return b, true, nil
+}
+
+func limitVideos(videos []VideoFile, args map[string]any) ([]VideoFile, error) {
+	limit, ok, err := intArg(args, "limit")
+	if err != nil {
+		return nil, err
+	}
+	if ok && limit >= 0 && limit < len(videos) {
+		videos = videos[:limit]
+	}
+
+	return videos, nil
+}
+
+func graphVideos(videos []VideoFile, path string) []graphObject {
+	objects := make([]graphObject, 0, len(videos))
+	for _, video := range videos {
+		objects = append(objects, graphVideo(video, path))
+	}
+
+	return objects
+}
+
+func graphVideo(video VideoFile, path string) graphObject {
+	return graphObject{
+		"__typename":   graphValue("Video"),
+		"id":           graphValue(video.ID),
+		"name":         graphValue(video.Name),
+		"displayName":  graphValue(video.DisplayName()),
+		"folder":       graphValue(videoFolder(video, path)),
+		"viewed":       graphValue(video.Viewed),
+		"progress":     graphValue(video.Progress),
+		"lastWatched":  graphValue(graphTime(video.Current)),
+		"added":        graphValue(graphTime(video.Added)),
+		"show":         graphValue(video.Show),
+		"season":       graphValue(video.Season),
+		"episode":      graphValue(video.Episode),
+		"episodeTitle": graphValue(video.EpisodeTitle),
+		"duration": func(map[string]any) (any, error) {
+			return probeDuration(video.Path), nil
+		},
+		"fields": func(map[string]any) (any, error) {
+			return videoFields(video, true), nil
+		},
+	}
+}
+
+func graphFolder(folder string, videos []VideoFile, path string) graphObject {
+	viewed := 0
+	for _, video := range videos {
+		if video.Viewed {
+			viewed++
+		}
+	}
+
+	return graphObject{
+		"__typename":  graphValue("Folder"),
+		"path":        graphValue(folder),
+		"videoCount":  graphValue(len(videos)),
+		"viewedCount": graphValue(viewed),
+		"videos": func(args map[string]any) (any, error) {
+			videos, err := limitVideos(videos, args)
+			return graphVideos(videos, path), err
+		},
+	}
+}
+
+func graphQueryRoot(videoFiles []VideoFile, path string) graphObject {
+	return graphObject{
+		"__typename": graphValue("Query"),
+		"videos": func(args map[string]any) (any, error) {
+			videos := videoFiles
+
+			if folder, ok, err := stringArg(args, "folder"); err != nil {
+				return nil, err
+			} else if ok {
+				videos = filterVideos(videos, func(video VideoFile) bool { return videoFolder(video, path) == folder })
+			}
+
+			if show, ok, err := stringArg(args, "show"); err != nil {
+				return nil, err
+			} else if ok {
+				videos = filterVideos(videos, func(video VideoFile) bool { return video.Show == show })
+			}
+
+			if viewed, ok, err := boolArg(args, "viewed"); err != nil {
+				return nil, err
+			} else if ok {
+				videos = filterVideos(videos, func(video VideoFile) bool { return video.Viewed == viewed })
+			}
+
+			if name, ok, err := stringArg(args, "playlist"); err != nil {
+				return nil, err
+			} else if ok {
+				settings, err := loadSettings(path)
+				if err != nil {
+					return nil, err
+				}
+
+				playlist := findPlaylist(settings, name)
+				if playlist == nil {
+					return nil, fmt.Errorf("unknown playlist %q", name)
+				}
+
+				rule, err := parsePlaylistRule(playlist.Rule, path)
+				if err != nil {
+					return nil, err
+				}
+				videos = filterVideos(videos, rule)
+			}
+
+			videos, err := limitVideos(videos, args)
+			return graphVideos(videos, path), err
+		},
+		"video": func(args map[string]any) (any, error) {
+			id, ok, err := stringArg(args, "id")
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				if i := findVideoFile(videoFiles, id); i >= 0 {
+					return graphVideo(videoFiles[i], path), nil
+				}
+				return graphObject(nil), nil
+			}
+
+			name, _, err := stringArg(args, "name")
+			if err != nil {
+				return nil, err
+			}
+
+			for _, video := range videoFiles {
+				if video.Name == name {
+					return graphVideo(video, path), nil
+				}
+			}
+
+			return graphObject(nil), nil
+		},
+		"folders": func(map[string]any) (any, error) {
+			folders := []graphObject{}
+			for _, folder := range videoFolders(videoFiles, path) {
+				videos := filterVideos(videoFiles, func(video VideoFile) bool { return videoFolder(video, path) == folder })
+				folders = append(folders, graphFolder(folder, videos, path))
+			}
+
+			return folders, nil
+		},
+		"history": func(args map[string]any) (any, error) {
+			videos := filterVideos(videoFiles, func(video VideoFile) bool { return !video.Current.IsZero() })
+			sort.SliceStable(videos, func(i, j int) bool {
+				return videos[i].Current.After(videos[j].Current)
+			})
+
+			videos, err := limitVideos(videos, args)
+			return graphVideos(videos, path), err
+		},
+		"stats": func(map[string]any) (any, error) {
+			viewed, started := 0, 0
+			for _, video := range videoFiles {
+				if video.Viewed {
+					viewed++
+				} else if video.Progress > 0 {
+					started++
+				}
+			}
+
+			return graphObject{
+				"__typename": graphValue("Stats"),
+				"videos":     graphValue(len(videoFiles)),
+				"viewed":     graphValue(viewed),
+				"started":    graphValue(started),
+				"unwatched":  graphValue(len(videoFiles) - viewed),
+				"folders":    graphValue(len(videoFolders(videoFiles, path))),
+			}, nil
+		},
+	}
+}
+
+func handleGraphQL(w http.ResponseWriter, r *http.Request, videoFiles []VideoFile, path string) {
+	var request struct {
+		Query     string
+		Variables map[string]any
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		request.Query = r.URL.Query().Get("query")
+		if variables := r.URL.Query().Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &request.Variables); err != nil {
+				httpError(w, r, "Invalid variables", http.StatusBadRequest)
+				return
+			}
+		}
+	case http.MethodPost:
+		r.Body = http.MaxBytesReader(w, r.Body, maxGraphRequestSize)
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			httpError(w, r, "Invalid GraphQL request", http.StatusBadRequest)
+			return
+		}
+	default:
+		methodNotAllowed(w, r, "GET, POST")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	fields, err := parseGraphQuery(request.Query, request.Variables)
+	var data graphResult
+	if err == nil {
+		data, err = executeGraphQuery(graphQueryRoot(videoFiles, path), fields)
+	}
+
+	if err != nil {
+		debug("GraphQL error: %v", err)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": []map[string]string{{"message": err.Error()}},
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{"data": data})
+}
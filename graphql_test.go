@@ -0,0 +1,128 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseGraphQuery(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		variables map[string]any
+		want      []graphField
+	}{
+		{
+			name:  "shorthand",
+			query: "{ videos { name viewed } }",
+			want: []graphField{
+				{Name: "videos", Fields: []graphField{{Name: "name"}, {Name: "viewed"}}},
+			},
+		},
+		{
+			name:  "named with comments",
+			query: "query Library {\n  # the unwatched ones\n  unwatched: videos(viewed: false) { id }\n}",
+			want: []graphField{
+				{Alias: "unwatched", Name: "videos", Args: map[string]any{"viewed": false}, Fields: []graphField{{Name: "id"}}},
+			},
+		},
+		{
+			name:      "variables",
+			query:     "query ($id: ID!, $limit: Int) { video(id: $id) { name } videos(limit: $limit) { name } }",
+			variables: map[string]any{"id": "abc", "limit": float64(3)},
+			want: []graphField{
+				{Name: "video", Args: map[string]any{"id": "abc"}, Fields: []graphField{{Name: "name"}}},
+				{Name: "videos", Args: map[string]any{"limit": float64(3)}, Fields: []graphField{{Name: "name"}}},
+			},
+		},
+		{
+			name:  "values",
+			query: `{ videos(folder: "a \"b\"", limit: 2.5, sort: NAME, ids: ["x", ["y"]], after: null) { name } }`,
+			want: []graphField{
+				{
+					Name: "videos",
+					Args: map[string]any{
+						"folder": `a "b"`,
+						"limit":  2.5,
+						"sort":   "NAME",
+						"ids":    []any{"x", []any{"y"}},
+						"after":  nil,
+					},
+					Fields: []graphField{{Name: "name"}},
+				},
+			},
+		},
+		{
+			name:  "nested at the limit",
+			query: strings.Repeat("{ a ", maxGraphDepth-1) + "{ b }" + strings.Repeat(" }", maxGraphDepth-1),
+			want:  nestedGraphFields(maxGraphDepth),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fields, err := parseGraphQuery(test.query, test.variables)
+			if err != nil {
+				t.Fatalf("parseGraphQuery(): %v", err)
+			}
+			if !reflect.DeepEqual(fields, test.want) {
+				t.Errorf("parseGraphQuery() = %+v, want %+v", fields, test.want)
+			}
+		})
+	}
+}
+
+// nestedGraphFields returns the fields of a query nesting a in a up to depth
+// selection sets, the deepest one selecting b.
+func nestedGraphFields(depth int) []graphField {
+	if depth == 1 {
+		return []graphField{{Name: "b"}}
+	}
+
+	return []graphField{{Name: "a", Fields: nestedGraphFields(depth - 1)}}
+}
+
+func TestParseGraphQueryErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		wantErr string
+	}{
+		{name: "mutation", query: "mutation { markViewed(id: 1) }", wantErr: "only queries"},
+		{name: "fragment", query: "{ videos { ...fields } }", wantErr: "fragments"},
+		{name: "two operations", query: "{ videos { name } } { folders { name } }", wantErr: "single operation"},
+		{name: "unterminated", query: "{ videos { name }", wantErr: "unexpected end"},
+		{name: "unterminated string", query: `{ videos(folder: "a) { name } }`, wantErr: "unterminated string"},
+		{name: "unexpected character", query: "{ videos { name @ } }", wantErr: "unexpected character"},
+		{name: "missing value", query: "{ videos(folder: ) { name } }", wantErr: "expected a value"},
+		{name: "missing colon", query: "{ videos(folder) { name } }", wantErr: "expected"},
+		{
+			name:    "nested selections",
+			query:   strings.Repeat("{ a ", maxGraphDepth) + "{ b }" + strings.Repeat(" }", maxGraphDepth),
+			wantErr: "nested deeper",
+		},
+		{
+			name:    "nested lists",
+			query:   "{ videos(ids: " + strings.Repeat("[", maxGraphDepth) + strings.Repeat("]", maxGraphDepth) + ") { name } }",
+			wantErr: "nested deeper",
+		},
+		{
+			name:    "deeply nested",
+			query:   strings.Repeat("{ a ", 100000),
+			wantErr: "nested deeper",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fields, err := parseGraphQuery(test.query, nil)
+			if err == nil {
+				t.Fatalf("parseGraphQuery() = %+v, want an error", fields)
+			}
+			if !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("parseGraphQuery() error = %q, want it to contain %q", err, test.wantErr)
+			}
+		})
+	}
+}
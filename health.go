@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	issueSortPrefix       = "Unparseable sort prefix"
+	issueDuplicateName    = "Duplicate name"
+	issueEmptyFile        = "Empty file"
+	issueBrokenFile       = "Broken file"
+	issueUnsupportedCodec = "Unsupported format"
+	issueMissingSubtitles = "Missing subtitles"
+)
+
+var (
+	// Formats that browsers can play without transcoding.
+	playableContainers  = map[string]bool{".mp4": true, ".webm": true, ".mov": true}
+	playableVideoCodecs = map[string]bool{"h264": true, "vp8": true, "vp9": true, "av1": true}
+	playableAudioCodecs = map[string]bool{"aac": true, "mp3": true, "opus": true, "vorbis": true, "flac": true}
+
+	subtitleExtensions = []string{".srt", ".vtt", ".ass", ".ssa"}
+)
+
+type HealthIssue struct {
+	Kind   string
+	File   string
+	Detail string
+}
+
+type HealthReport struct {
+	Videos int
+	Issues []HealthIssue
+}
+
+// Kinds returns the issues grouped by kind, in a stable order.
+func (h HealthReport) Kinds() map[string][]HealthIssue {
+	kinds := make(map[string][]HealthIssue)
+	for _, issue := range h.Issues {
+		kinds[issue.Kind] = append(kinds[issue.Kind], issue)
+	}
+
+	return kinds
+}
+
+func buildHealthReport(path string, videoFiles []VideoFile) HealthReport {
+	report := HealthReport{Videos: len(videoFiles)}
+
+	add := func(kind string, video VideoFile, detail string) {
+		file, err := filepath.Rel(path, video.Path)
+		if err != nil {
+			file = video.Path
+		}
+		report.Issues = append(report.Issues, HealthIssue{Kind: kind, File: filepath.ToSlash(file), Detail: detail})
+	}
+
+	names := make(map[string][]VideoFile)
+	for _, video := range videoFiles {
+		names[video.Name] = append(names[video.Name], video)
+	}
+
+	for _, video := range videoFiles {
+		if video.Episode == 0 {
+			prefix := strings.TrimSpace(strings.Split(video.Name, " - ")[0])
+			if _, err := strconv.Atoi(prefix); err != nil {
+				add(issueSortPrefix, video, "expected a name like \"1 - Title\" or an episode number")
+			}
+		}
+
+		if len(names[video.Name]) > 1 {
+			add(issueDuplicateName, video, fmt.Sprintf("%d files share this name, their notes, titles and played parts are shared", len(names[video.Name])))
+		}
+
+		if info, err := os.Stat(video.Path); err == nil && info.Size() == 0 {
+			add(issueEmptyFile, video, "the file is empty")
+		}
+
+		if detail := integrityError(video); detail != "" {
+			add(issueBrokenFile, video, detail)
+		}
+
+		if detail := unsupportedFormat(video); detail != "" {
+			add(issueUnsupportedCodec, video, detail)
+		}
+
+		if !hasSubtitles(video) {
+			add(issueMissingSubtitles, video, "no .srt, .vtt, .ass or .ssa file next to the video")
+		}
+	}
+
+	sort.SliceStable(report.Issues, func(i, j int) bool {
+		if report.Issues[i].Kind != report.Issues[j].Kind {
+			return report.Issues[i].Kind < report.Issues[j].Kind
+		}
+		return report.Issues[i].File < report.Issues[j].File
+	})
+
+	return report
+}
+
+// unsupportedFormat describes why a video cannot be played by browsers, or
+// returns "" when it can (or when ffprobe is not available to tell).
+func unsupportedFormat(video VideoFile) string {
+	ext := strings.ToLower(filepath.Ext(video.Name))
+	if !playableContainers[ext] {
+		return fmt.Sprintf("the %s container is not supported by most browsers", ext)
+	}
+
+	videoCodec, audioCodec, err := probeCodecs(video.Path)
+	if err != nil {
+		return ""
+	}
+
+	if videoCodec != "" && !playableVideoCodecs[videoCodec] {
+		return fmt.Sprintf("the %s video codec is not supported by most browsers", videoCodec)
+	}
+	if audioCodec != "" && !playableAudioCodecs[audioCodec] {
+		return fmt.Sprintf("the %s audio codec is not supported by most browsers", audioCodec)
+	}
+
+	return ""
+}
+
+func hasSubtitles(video VideoFile) bool {
+	base := strings.TrimSuffix(video.Path, filepath.Ext(video.Path))
+	for _, ext := range subtitleExtensions {
+		// Matches "video.srt" as well as "video.en.srt".
+		matches, _ := filepath.Glob(globEscape(base) + "*" + ext)
+		if len(matches) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func globEscape(path string) string {
+	return strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`).Replace(path)
+}
+
+func printHealthReport(w io.Writer, report HealthReport) {
+	fmt.Fprintf(w, "%d videos, %d issues\n", report.Videos, len(report.Issues))
+
+	kind := ""
+	for _, issue := range report.Issues {
+		if issue.Kind != kind {
+			kind = issue.Kind
+			fmt.Fprintf(w, "\n%s:\n", kind)
+		}
+		fmt.Fprintf(w, "  %s: %s\n", issue.File, issue.Detail)
+	}
+}
+
+func runReport(path string) int {
+	videoFiles, err := loadVideoFiles(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading video files: %v\n", err)
+		return 1
+	}
+
+	loadIntegrityResults(path)
+
+	report := buildHealthReport(path, videoFiles)
+	printHealthReport(os.Stdout, report)
+
+	if len(report.Issues) > 0 {
+		return 2
+	}
+
+	return 0
+}
+
+func createHealthTemplate() *template.Template {
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Library health - {{.Title}}</title>
+    <style>
+        body {
+            font-family: Arial, sans-serif;
+            margin: 20px;
+        }
+        table {
+            border-collapse: collapse;
+            width: 100%;
+            margin-bottom: 30px;
+        }
+        th, td {
+            text-align: left;
+            padding: 5px 10px;
+            border-bottom: 1px solid #ddd;
+        }
+    </style>
+</head>
+<body>
+    <p><a href="/">Back to the library</a></p>
+    <h1>Library health</h1>
+    <p>{{.Report.Videos}} videos, {{len .Report.Issues}} issues.</p>
+    {{range $kind, $issues := .Report.Kinds}}
+    <h2>{{$kind}} ({{len $issues}})</h2>
+    <table>
+        {{range $issues}}
+        <tr><td>{{.File}}</td><td>{{.Detail}}</td></tr>
+        {{end}}
+    </table>
+    {{end}}
+</body>
+</html>`
+
+	return template.Must(template.New("health").Parse(tmpl))
+}
+
+func handleHealth(w http.ResponseWriter, r *http.Request, path string, videoFiles []VideoFile, tmpl *template.Template) {
+	data := struct {
+		Title  string
+		Report HealthReport
+	}{
+		Title:  pageTitle,
+		Report: buildHealthReport(path, videoFiles),
+	}
+
+	tmpl.Execute(w, data)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"log"
+	"math/rand/v2"
+	"sort"
+)
+
+const (
+	sectionContinue = "continue"
+	sectionRecent   = "recent"
+	sectionRandom   = "random"
+	sectionPlaylist = "playlist"
+	sectionLibrary  = "library"
+	sectionPlan     = "plan"
+	sectionReview   = "review"
+
+	defaultSectionLimit = 10
+	maxAmbientImages    = 100
+)
+
+// HomeSection configures a row of the home page. Playlist is the name of
+// the smart playlist displayed by "playlist" sections.
+type HomeSection struct {
+	Type     string
+	Title    string `json:",omitempty"`
+	Playlist string `json:",omitempty"`
+	Limit    int    `json:",omitempty"`
+}
+
+// AmbientImage is an image displayed by the screensaver of the home page.
+type AmbientImage struct {
+	URL   string
+	Title string
+}
+
+type HomeRow struct {
+	Type   string
+	Title  string
+	Videos []VideoFile
+}
+
+func defaultHomeSections() []HomeSection {
+	return []HomeSection{
+		{Type: sectionPlan},
+		{Type: sectionReview},
+		{Type: sectionContinue},
+		{Type: sectionLibrary},
+	}
+}
+
+func buildHomeRows(settings Settings, videoFiles []VideoFile, path string) []HomeRow {
+	sections := settings.HomeSections
+	if len(sections) == 0 {
+		sections = defaultHomeSections()
+	}
+
+	var rows []HomeRow
+	for _, section := range sections {
+		limit := section.Limit
+		if limit <= 0 {
+			limit = defaultSectionLimit
+		}
+
+		row := HomeRow{Type: section.Type, Title: section.Title}
+		videos := make([]VideoFile, 0, len(videoFiles))
+
+		switch section.Type {
+		case sectionLibrary:
+			rows = append(rows, row)
+			continue
+		case sectionContinue:
+			for _, video := range videoFiles {
+				if !video.Viewed && video.Progress > 0 {
+					videos = append(videos, video)
+				}
+			}
+			sortVideoFiles(videos, sortByRecent)
+			row.Title = sectionTitle(row.Title, "Continue Watching")
+		case sectionRecent:
+			videos = append(videos, videoFiles...)
+			sort.SliceStable(videos, func(i, j int) bool {
+				return videos[i].Added.After(videos[j].Added)
+			})
+			row.Title = sectionTitle(row.Title, "Recently Added")
+		case sectionRandom:
+			for _, video := range videoFiles {
+				if !video.Viewed {
+					videos = append(videos, video)
+				}
+			}
+			rand.Shuffle(len(videos), func(i, j int) {
+				videos[i], videos[j] = videos[j], videos[i]
+			})
+			row.Title = sectionTitle(row.Title, "Random Pick")
+		case sectionPlan:
+			videos = todayPlan(settings, videoFiles, path)
+			row.Title = sectionTitle(row.Title, "Today's Plan")
+		case sectionReview:
+			videos = dueReviews(videoFiles)
+			row.Title = sectionTitle(row.Title, "Due for Review")
+		case sectionPlaylist:
+			playlist := findPlaylist(settings, section.Playlist)
+			if playlist == nil {
+				log.Printf("Unknown playlist in home section: %q", section.Playlist)
+				continue
+			}
+
+			rule, err := parsePlaylistRule(playlist.Rule, path)
+			if err != nil {
+				log.Printf("Invalid rule of playlist %q: %v", playlist.Name, err)
+				continue
+			}
+
+			videos = filterVideos(videoFiles, rule)
+			row.Title = sectionTitle(row.Title, playlist.Name)
+		default:
+			log.Printf("Unknown home section type: %q", section.Type)
+			continue
+		}
+
+		if len(videos) > limit {
+			videos = videos[:limit]
+		}
+		row.Videos = videos
+
+		rows = append(rows, row)
+	}
+
+	return rows
+}
+
+func sectionTitle(title string, fallback string) string {
+	if title != "" {
+		return title
+	}
+
+	return fallback
+}
+
+// ambientImages returns the artwork, or else the thumbnails, of the videos in
+// a random order, for the screensaver.
+func ambientImages(videoFiles []VideoFile) []AmbientImage {
+	var images []AmbientImage
+	for _, video := range videoFiles {
+		if metadata := videoMetadata(video); metadata != nil && metadata.Image != "" {
+			images = append(images, AmbientImage{URL: "/artwork/" + video.ID, Title: video.DisplayName()})
+		} else if thumbnailsEnabled() {
+			images = append(images, AmbientImage{URL: "/thumbnail/" + video.ID, Title: video.DisplayName()})
+		}
+	}
+
+	rand.Shuffle(len(images), func(i, j int) {
+		images[i], images[j] = images[j], images[i]
+	})
+
+	return images[:min(len(images), maxAmbientImages)]
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// videoID identifies a video in URLs by its path relative to the library,
+// so that the links do not depend on the characters of its file name and
+// tell apart the videos having the same name in different folders.
+func videoID(relPath string) string {
+	sum := sha256.Sum256([]byte(filepath.ToSlash(relPath)))
+
+	return hex.EncodeToString(sum[:6])
+}
+
+// validVideoName rejects the identifiers no video can have, such as path
+// traversals and names with separators.
+func validVideoName(name string) bool {
+	return name != "" && name != "." && name != ".." && utf8.ValidString(name) && !strings.ContainsAny(name, "/\\\x00")
+}
+
+// findVideoFile returns the index of the video with the given ID or, for the
+// links made before IDs were used, file name.
+func findVideoFile(videoFiles []VideoFile, id string) int {
+	if !validVideoName(id) {
+		return -1
+	}
+
+	for i, video := range videoFiles {
+		if video.ID == id {
+			return i
+		}
+	}
+	for i, video := range videoFiles {
+		if video.Name == id {
+			return i
+		}
+	}
+
+	return -1
+}
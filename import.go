@@ -0,0 +1,233 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// foreignState is the watch state of another library, with the checksums of
+// its files when its manifest is available, by path and by the names found
+// once in it.
+type foreignState struct {
+	videos   map[string]VideoFile
+	sums     map[string]string
+	nameSums map[string]string
+}
+
+// loadForeignState reads a video_data.json file, a video_events.log file or
+// a backup archive. The event log and the manifest next to a state file are
+// read as well.
+func loadForeignState(file string) (foreignState, error) {
+	state := foreignState{videos: make(map[string]VideoFile)}
+
+	if strings.EqualFold(filepath.Ext(file), ".zip") {
+		reader, err := zip.OpenReader(file)
+		if err != nil {
+			return state, err
+		}
+		defer reader.Close()
+
+		for _, name := range []string{videoDataFile, stateEventsFile, checksumFile} {
+			entry, err := reader.Open(name)
+			if err != nil {
+				continue
+			}
+			content, err := io.ReadAll(entry)
+			entry.Close()
+			if err != nil {
+				return state, err
+			}
+			if err := state.read(name, content); err != nil {
+				return state, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+
+		return state, nil
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return state, err
+	}
+
+	name := videoDataFile
+	if strings.EqualFold(filepath.Ext(file), ".log") {
+		name = stateEventsFile
+	}
+	if err := state.read(name, content); err != nil {
+		return state, err
+	}
+
+	if name == videoDataFile {
+		for _, sibling := range []string{stateEventsFile, checksumFile} {
+			content, err := os.ReadFile(filepath.Join(filepath.Dir(file), sibling))
+			if err != nil {
+				continue
+			}
+			if err := state.read(sibling, content); err != nil {
+				return state, fmt.Errorf("%s: %w", sibling, err)
+			}
+		}
+	}
+
+	return state, nil
+}
+
+func (s *foreignState) read(name string, content []byte) error {
+	switch name {
+	case videoDataFile:
+		videos, err := decodeState(content, "")
+		if err != nil {
+			return err
+		}
+		for _, video := range videos {
+			s.videos[video.File] = video
+		}
+	case stateEventsFile:
+		return readStateEvents(bytes.NewReader(content), "", s.videos)
+	case checksumFile:
+		sums, err := parseManifest(bytes.NewReader(content))
+		if err != nil {
+			return err
+		}
+
+		// The states saved before version 3 only know the file names, so
+		// the names found more than once in the manifest cannot be matched
+		// by content.
+		s.sums = sums
+		s.nameSums = make(map[string]string)
+		seen := make(map[string]bool)
+		for file, sum := range sums {
+			name := path.Base(file)
+			if seen[name] {
+				delete(s.nameSums, name)
+				continue
+			}
+			seen[name] = true
+			s.nameSums[name] = sum
+		}
+	}
+
+	return nil
+}
+
+// matchForeignVideos moves the foreign videos to the local ones with the same
+// path in the library, the same name when a single video has it on both sides
+// or, when the manifest of the foreign library is known, the same content. It
+// returns the matched states and the number of videos matched by content.
+func matchForeignVideos(path string, state foreignState, videoFiles []VideoFile) ([]VideoFile, int, error) {
+	local := make(map[string]bool)
+	names := make(map[string][]VideoFile)
+	for _, video := range videoFiles {
+		local[video.File] = true
+		names[video.Name] = append(names[video.Name], video)
+	}
+
+	foreignNames := make(map[string]int)
+	for _, video := range state.videos {
+		foreignNames[video.Name]++
+	}
+
+	var matched []VideoFile
+	var unmatched []VideoFile
+	for _, video := range state.videos {
+		switch {
+		case local[video.File]:
+			matched = append(matched, video)
+		case len(names[video.Name]) == 1 && foreignNames[video.Name] == 1:
+			video.File = names[video.Name][0].File
+			matched = append(matched, video)
+		case state.foreignSum(video) != "":
+			unmatched = append(unmatched, video)
+		}
+	}
+	if len(unmatched) == 0 {
+		return matched, 0, nil
+	}
+
+	localSums, err := readManifest(filepath.Join(path, checksumFile))
+	if err != nil {
+		debug("Hashing the library, no manifest: %v", err)
+		localSums = make(map[string]string)
+	}
+
+	byContent := make(map[string]VideoFile)
+	duplicates := make(map[string]bool)
+	for _, video := range videoFiles {
+		sum := localSums[video.File]
+		if sum == "" {
+			if sum, err = hashFile(video.Path); err != nil {
+				return nil, 0, err
+			}
+		}
+		if _, ok := byContent[sum]; ok {
+			duplicates[sum] = true
+		}
+		byContent[sum] = video
+	}
+
+	count := 0
+	for _, video := range unmatched {
+		sum := state.foreignSum(video)
+		if local, ok := byContent[sum]; ok && !duplicates[sum] {
+			debug("Matched %s to %s by content", video.File, local.File)
+			video.Name = local.Name
+			video.File = local.File
+			matched = append(matched, video)
+			count++
+		}
+	}
+
+	return matched, count, nil
+}
+
+// foreignSum returns the checksum of the file of a foreign video, or "" when
+// it is unknown.
+func (s foreignState) foreignSum(video VideoFile) string {
+	if sum := s.sums[video.File]; sum != "" {
+		return sum
+	}
+
+	return s.nameSums[video.Name]
+}
+
+func runImport(path string, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "The import command expects a state file or a backup archive\n\n")
+		return 1
+	}
+
+	state, err := loadForeignState(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", args[0], err)
+		return 1
+	}
+
+	videoFiles, err := loadVideoFiles(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading video files: %v\n", err)
+		return 1
+	}
+
+	matched, byContent, err := matchForeignVideos(path, state, videoFiles)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error matching videos: %v\n", err)
+		return 1
+	}
+
+	changed, err := applyVideoStates(path, matched)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error importing watch state: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("%d videos found, %d matched (%d by content), %d updated\n", len(state.videos), len(matched), byContent, len(changed))
+
+	return 0
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const intakeLogFile = "video_intake.log"
+
+// IntakeRule describes how a file dropped in the intake folder is moved into
+// the library. Folder and Name are expanded with the named groups of Pattern
+// (e.g. "${show}/Season ${season}"), Name excludes the file extension.
+type IntakeRule struct {
+	Pattern string
+	Folder  string
+	Name    string
+
+	regexp *regexp.Regexp
+}
+
+var defaultIntakeRules = []IntakeRule{
+	{
+		Pattern: `(?i)^(?P<show>.+?)[ ._-]+s(?P<season>\d{1,2})e(?P<episode>\d{1,3})\b(?P<title>.*)$`,
+		Folder:  "${show}/Season ${season}",
+		Name:    "${episode} - ${show} S${season}E${episode} ${title}",
+	},
+	{
+		Pattern: `^(?P<number>\d+)[ ._-]+(?P<title>.+)$`,
+		Name:    "${number} - ${title}",
+	},
+}
+
+func loadIntakeRules(file string) ([]IntakeRule, error) {
+	rules := defaultIntakeRules
+	if file != "" {
+		jsonData, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		rules = nil
+		if err := json.Unmarshal(jsonData, &rules); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range rules {
+		re, err := regexp.Compile(rules[i].Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %v", i+1, err)
+		}
+		rules[i].regexp = re
+	}
+
+	return rules, nil
+}
+
+// intakeFile is a file of the intake folder as seen by the previous pass.
+// A file that could not be moved is left alone until it changes, rather than
+// logged again on every pass.
+type intakeFile struct {
+	size    int64
+	modTime time.Time
+	failed  bool
+}
+
+func startIntake(path string, rules []IntakeRule, rescan func()) {
+	files := make(map[string]intakeFile)
+
+	go func() {
+		ticker := time.NewTicker(intakeInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if processIntake(path, rules, files) > 0 {
+				rescan()
+			}
+		}
+	}()
+}
+
+// processIntake moves the files of the intake folder whose size did not change
+// since the previous pass, and returns the number of files moved.
+func processIntake(path string, rules []IntakeRule, files map[string]intakeFile) int {
+	moved := 0
+	seen := make(map[string]bool)
+
+	err := filepath.Walk(intakeDir, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		if !videoExtensions[strings.ToLower(filepath.Ext(file))] {
+			return nil
+		}
+
+		seen[file] = true
+		previous, ok := files[file]
+		if !ok || previous.size != info.Size() || !previous.modTime.Equal(info.ModTime()) {
+			files[file] = intakeFile{size: info.Size(), modTime: info.ModTime()}
+			return nil
+		}
+		if previous.failed {
+			return nil
+		}
+
+		target, rule := intakeTarget(path, filepath.Base(file), rules)
+		if _, err := os.Stat(target); err == nil {
+			logIntakeAction(path, "skipped %q: %q already exists", file, target)
+			previous.failed = true
+			files[file] = previous
+			return nil
+		}
+
+		if err := moveFile(file, target); err != nil {
+			logIntakeAction(path, "failed to move %q to %q: %v", file, target, err)
+			previous.failed = true
+			files[file] = previous
+			return nil
+		}
+
+		if rule > 0 {
+			logIntakeAction(path, "moved %q to %q (rule %d)", file, target, rule)
+		} else {
+			logIntakeAction(path, "moved %q to %q (no rule matched)", file, target)
+		}
+
+		delete(files, file)
+		moved++
+
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error scanning intake folder: %v", err)
+	}
+
+	for file := range files {
+		if !seen[file] {
+			delete(files, file)
+		}
+	}
+
+	return moved
+}
+
+// intakeTarget returns the library destination of a file and the 1-based index
+// of the rule that matched it, or 0 when it is moved as-is to the library root.
+func intakeTarget(path string, name string, rules []IntakeRule) (string, int) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	for i, rule := range rules {
+		match := rule.regexp.FindStringSubmatch(base)
+		if match == nil {
+			continue
+		}
+
+		expand := func(template string) string {
+			return os.Expand(template, func(key string) string {
+				for j, group := range rule.regexp.SubexpNames() {
+					if group == key || fmt.Sprint(j) == key {
+						return cleanTitle(match[j])
+					}
+				}
+				return ""
+			})
+		}
+
+		folder := filepath.Clean("/" + expand(rule.Folder))
+		newName := strings.TrimSpace(expand(rule.Name))
+		if newName == "" || strings.ContainsAny(newName, `/\`) {
+			newName = base
+		}
+
+		return filepath.Join(path, folder, newName+ext), i + 1
+	}
+
+	return filepath.Join(path, name), 0
+}
+
+// moveFile moves the file, without replacing a file already at dst: a hard
+// link fails when dst exists, where a rename would replace it.
+func moveFile(src string, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	err := os.Link(src, dst)
+	if err == nil {
+		return os.Remove(src)
+	}
+	if errors.Is(err, os.ErrExist) {
+		return err
+	}
+
+	// Links fail across devices and on some filesystems, fall back to a
+	// copy.
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+
+	return os.Remove(src)
+}
+
+func logIntakeAction(path string, format string, v ...any) {
+	message := fmt.Sprintf(format, v...)
+	log.Printf("Intake: %s", message)
+
+	file, err := os.OpenFile(filepath.Join(path, intakeLogFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Printf("Error writing intake log: %v", err)
+		return
+	}
+	defer file.Close()
+
+	fmt.Fprintf(file, "%s %s\n", time.Now().Format(time.RFC3339), message)
+}
+
+func handleIntakeLog(w http.ResponseWriter, r *http.Request, path string) {
+	if intakeDir == "" {
+		notFound(w, r)
+		return
+	}
+
+	content, err := os.ReadFile(filepath.Join(path, intakeLogFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Error reading intake log: %v", err)
+		httpError(w, r, "Error reading intake log", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(content)
+}
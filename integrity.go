@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	integrityResults   = make(map[string]IntegrityResult)
+	integrityResultsMu sync.Mutex
+	integrityRunMu     sync.Mutex
+)
+
+// IntegrityResult is the outcome of the integrity check of a file, Size and
+// ModTime being used to detect files changed since they were checked.
+type IntegrityResult struct {
+	Size    int64
+	ModTime time.Time
+	Error   string
+}
+
+func integrityEnabled() bool {
+	return checkIntegrity && ffmpegPath != "" && ffprobePath != ""
+}
+
+func integrityFile(path string) string {
+	return filepath.Join(libraryCacheDir(path), "integrity.json")
+}
+
+// integrityError returns why a video looks broken, or "" if it does not (or
+// has not been checked yet).
+func integrityError(video VideoFile) string {
+	integrityResultsMu.Lock()
+	defer integrityResultsMu.Unlock()
+
+	return integrityResults[video.Path].Error
+}
+
+func loadIntegrityResults(path string) {
+	jsonData, err := os.ReadFile(integrityFile(path))
+	if err != nil {
+		return
+	}
+
+	integrityResultsMu.Lock()
+	defer integrityResultsMu.Unlock()
+
+	if err := json.Unmarshal(jsonData, &integrityResults); err != nil {
+		log.Printf("Error loading integrity results: %v", err)
+	}
+}
+
+func saveIntegrityResults(path string) {
+	integrityResultsMu.Lock()
+	jsonData, err := json.Marshal(integrityResults)
+	integrityResultsMu.Unlock()
+	if err != nil {
+		log.Printf("Error marshaling integrity results: %v", err)
+		return
+	}
+
+	if err := os.MkdirAll(libraryCacheDir(path), 0755); err != nil {
+		log.Printf("Error saving integrity results: %v", err)
+		return
+	}
+
+	prettyJSON := &bytes.Buffer{}
+	if err := json.Indent(prettyJSON, jsonData, "", "    "); err == nil {
+		if err := os.WriteFile(integrityFile(path), prettyJSON.Bytes(), 0644); err != nil {
+			log.Printf("Error saving integrity results: %v", err)
+		}
+	}
+}
+
+// startIntegrityCheck checks in the background the videos that were not
+// checked yet or changed since their last check.
+func startIntegrityCheck(path string, videoFiles []VideoFile) {
+	if !integrityEnabled() {
+		return
+	}
+
+	videos := make([]VideoFile, len(videoFiles))
+	copy(videos, videoFiles)
+
+	go func() {
+		integrityRunMu.Lock()
+		defer integrityRunMu.Unlock()
+
+		checked := 0
+		for _, video := range videos {
+			info, err := os.Stat(video.Path)
+			if err != nil {
+				continue
+			}
+
+			integrityResultsMu.Lock()
+			result, ok := integrityResults[video.Path]
+			integrityResultsMu.Unlock()
+			if ok && result.Size == info.Size() && result.ModTime.Equal(info.ModTime()) {
+				continue
+			}
+
+			result = IntegrityResult{Size: info.Size(), ModTime: info.ModTime()}
+			if err := verifyVideo(video.Path); err != nil {
+				log.Printf("Integrity check failed for \"%s\": %v", video.Path, err)
+				result.Error = err.Error()
+			}
+
+			integrityResultsMu.Lock()
+			integrityResults[video.Path] = result
+			integrityResultsMu.Unlock()
+
+			checked++
+		}
+
+		if checked > 0 {
+			debug("Integrity check of %d videos done", checked)
+			saveIntegrityResults(path)
+		}
+	}()
+}
+
+// verifyVideo makes sure a video can be opened and that its last seconds can
+// be decoded, which is where truncated downloads fail.
+func verifyVideo(videoPath string) error {
+	var stderr bytes.Buffer
+
+	probe := exec.Command(ffprobePath, "-v", "error", "-show_entries", "format=duration", "-of", "default=nw=1", videoPath)
+	probe.Stderr = &stderr
+	if err := probe.Run(); err != nil {
+		return integrityFailure("unreadable file", stderr.String(), err)
+	}
+
+	stderr.Reset()
+	decode := exec.Command(ffmpegPath, "-v", "error", "-sseof", "-10", "-i", videoPath, "-f", "null", "-")
+	decode.Stderr = &stderr
+	if err := decode.Run(); err != nil {
+		return integrityFailure("cannot decode the end of the file", stderr.String(), err)
+	}
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return integrityFailure("decoding errors near the end of the file", msg, nil)
+	}
+
+	return nil
+}
+
+func integrityFailure(reason string, output string, err error) error {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if detail := strings.TrimSpace(lines[0]); detail != "" {
+		return errors.New(reason + ": " + detail)
+	}
+	if err != nil {
+		return errors.New(reason + ": " + err.Error())
+	}
+
+	return errors.New(reason)
+}
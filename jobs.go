@@ -0,0 +1,494 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	jobKindThumbnail = "thumbnail"
+	jobKindTranscode = "transcode"
+	jobKindChecksum  = "checksum"
+
+	jobPending = "pending"
+	jobRunning = "running"
+	jobFailed  = "failed"
+
+	maxJobAttempts = 3
+	// jobRetryDelay is the delay before the first retry of a failed job,
+	// doubled for each of the next ones.
+	jobRetryDelay   = 30 * time.Second
+	jobPollInterval = 5 * time.Second
+)
+
+var jobWorkers int
+
+// jobRunners run the jobs of each kind, in the library.
+var jobRunners = map[string]func(path string, job Job) error{
+	jobKindThumbnail: runThumbnailJob,
+	jobKindTranscode: runTranscodeJob,
+	jobKindChecksum:  runChecksumJob,
+}
+
+// Job is a background task of the queue. The jobs are removed once done,
+// and kept once they failed maxJobAttempts times, until retried or removed.
+type Job struct {
+	ID       string
+	Kind     string
+	Video    string `json:",omitempty"`
+	Path     string `json:",omitempty"`
+	Profile  string `json:",omitempty"`
+	Subtitle int    `json:",omitempty"`
+	State    string
+	Attempts int
+	Error    string `json:",omitempty"`
+	Created  time.Time
+	Updated  time.Time
+	RetryAt  time.Time
+}
+
+// sameTask is whether the jobs do the same work, which is queued once.
+func (j Job) sameTask(other Job) bool {
+	return j.Kind == other.Kind && j.Path == other.Path && j.Profile == other.Profile && j.Subtitle == other.Subtitle
+}
+
+// jobQueue runs the thumbnails, transcodes and checksums in the background,
+// with at most -job-workers jobs of each kind at once. The queue is saved in
+// the cache directory, and resumed when the server restarts.
+type jobQueue struct {
+	mu      sync.Mutex
+	path    string
+	jobs    []*Job
+	waiters map[string][]chan error
+	wake    map[string]chan struct{}
+	saving  bool
+}
+
+// jobsSaveDelay groups the writes of the queue, which changes once per job
+// queued, started and done.
+const jobsSaveDelay = time.Second
+
+var jobs = &jobQueue{
+	waiters: make(map[string][]chan error),
+	wake:    make(map[string]chan struct{}),
+}
+
+func jobsFile(path string) string {
+	return filepath.Join(libraryCacheDir(path), "jobs.json")
+}
+
+// startJobs resumes the saved jobs of the library, and starts the workers.
+func startJobs(path string) {
+	jobs.mu.Lock()
+	jobs.path = path
+	if jsonData, err := os.ReadFile(jobsFile(path)); err == nil {
+		if err := json.Unmarshal(jsonData, &jobs.jobs); err != nil {
+			log.Printf("Error loading the jobs: %v", err)
+		}
+	}
+	for _, job := range jobs.jobs {
+		// The server stopped while the job was running.
+		if job.State == jobRunning {
+			job.State = jobPending
+		}
+	}
+	for kind := range jobRunners {
+		jobs.wake[kind] = make(chan struct{}, 1)
+	}
+	jobs.mu.Unlock()
+
+	for kind := range jobRunners {
+		for range max(jobWorkers, 1) {
+			go jobs.work(kind)
+		}
+	}
+}
+
+// save writes the queue after jobsSaveDelay, with the changes made in the
+// meantime, with the lock held.
+func (q *jobQueue) save() {
+	if q.saving {
+		return
+	}
+	q.saving = true
+
+	time.AfterFunc(jobsSaveDelay, func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		q.saving = false
+		q.write()
+	})
+}
+
+func (q *jobQueue) write() {
+	jsonData, err := json.Marshal(q.jobs)
+	if err != nil {
+		log.Printf("Error marshaling the jobs: %v", err)
+		return
+	}
+
+	if err := os.MkdirAll(libraryCacheDir(q.path), 0755); err != nil {
+		log.Printf("Error saving the jobs: %v", err)
+		return
+	}
+	if err := os.WriteFile(jobsFile(q.path), jsonData, 0644); err != nil {
+		log.Printf("Error saving the jobs: %v", err)
+	}
+}
+
+// add queues the job, unless the same task is already queued, and returns
+// the queued job. The urgent jobs, which a request waits for, are run first.
+func (q *jobQueue) add(job Job, urgent bool) Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.addLocked(job, urgent)
+}
+
+func (q *jobQueue) addLocked(job Job, urgent bool) Job {
+	for i, queued := range q.jobs {
+		if !queued.sameTask(job) {
+			continue
+		}
+		if urgent && queued.State == jobPending && i > 0 {
+			q.jobs = append([]*Job{queued}, slices.Delete(q.jobs, i, i+1)...)
+			q.save()
+		}
+		return *queued
+	}
+
+	id := make([]byte, 6)
+	rand.Read(id)
+	job.ID = hex.EncodeToString(id)
+	job.State = jobPending
+	job.Created = time.Now()
+	job.Updated = job.Created
+
+	if urgent {
+		q.jobs = append([]*Job{&job}, q.jobs...)
+	} else {
+		q.jobs = append(q.jobs, &job)
+	}
+	q.save()
+	q.signal(job.Kind)
+
+	return job
+}
+
+// signal wakes up a worker of the kind.
+func (q *jobQueue) signal(kind string) {
+	select {
+	case q.wake[kind] <- struct{}{}:
+	default:
+	}
+}
+
+// run queues the job first and waits for it, returning its error when it
+// failed, even if it is retried later. A job waiting for its retry returns
+// its last error without waiting.
+func (q *jobQueue) run(ctx context.Context, job Job) error {
+	q.mu.Lock()
+	queued := q.addLocked(job, true)
+	if queued.State == jobFailed || queued.State == jobPending && queued.RetryAt.After(time.Now()) {
+		q.mu.Unlock()
+		return errors.New(queued.Error)
+	}
+	done := make(chan error, 1)
+	q.waiters[queued.ID] = append(q.waiters[queued.ID], done)
+	q.mu.Unlock()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// next marks the first job of the kind that is due as running.
+func (q *jobQueue) next(kind string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	for _, job := range q.jobs {
+		if job.Kind == kind && job.State == jobPending && !job.RetryAt.After(now) {
+			job.State = jobRunning
+			job.Attempts++
+			job.Updated = now
+			q.save()
+			// Another worker may take the next job.
+			q.signal(kind)
+			return *job, true
+		}
+	}
+
+	return Job{}, false
+}
+
+// finish removes the job when it succeeded, and schedules its retry or marks
+// it as failed otherwise.
+func (q *jobQueue) finish(done Job, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, waiter := range q.waiters[done.ID] {
+		waiter <- err
+	}
+	delete(q.waiters, done.ID)
+
+	i := slices.IndexFunc(q.jobs, func(job *Job) bool { return job.ID == done.ID })
+	if i < 0 {
+		return
+	}
+	job := q.jobs[i]
+
+	switch {
+	case err == nil:
+		q.jobs = slices.Delete(q.jobs, i, i+1)
+	case job.Attempts < maxJobAttempts:
+		job.State = jobPending
+		job.Error = err.Error()
+		job.RetryAt = time.Now().Add(jobRetryDelay << (job.Attempts - 1))
+	default:
+		job.State = jobFailed
+		job.Error = err.Error()
+		job.RetryAt = time.Time{}
+	}
+	job.Updated = time.Now()
+	q.save()
+}
+
+func (q *jobQueue) work(kind string) {
+	for {
+		job, ok := q.next(kind)
+		if !ok {
+			select {
+			case <-q.wake[kind]:
+			case <-time.After(jobPollInterval):
+			}
+			continue
+		}
+
+		err := jobRunners[kind](q.path, job)
+		if err != nil {
+			log.Printf("Error running the %s job of \"%s\" (attempt %d): %v", job.Kind, job.Video, job.Attempts, err)
+		}
+		q.finish(job, err)
+	}
+}
+
+// retry runs a failed job again, from its first attempt.
+func (q *jobQueue) retry(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, job := range q.jobs {
+		if job.ID == id && job.State == jobFailed {
+			job.State = jobPending
+			job.Attempts = 0
+			job.RetryAt = time.Time{}
+			job.Updated = time.Now()
+			q.save()
+			q.signal(job.Kind)
+			return true
+		}
+	}
+
+	return false
+}
+
+// remove removes a job that is not running.
+func (q *jobQueue) remove(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	i := slices.IndexFunc(q.jobs, func(job *Job) bool { return job.ID == id })
+	if i < 0 || q.jobs[i].State == jobRunning {
+		return false
+	}
+
+	for _, waiter := range q.waiters[id] {
+		waiter <- errors.New("job removed")
+	}
+	delete(q.waiters, id)
+
+	q.jobs = slices.Delete(q.jobs, i, i+1)
+	q.save()
+
+	return true
+}
+
+// list returns the jobs, the running ones first, then the pending ones in
+// their order and the failed ones.
+func (q *jobQueue) list() []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	list := []Job{}
+	for _, job := range q.jobs {
+		list = append(list, *job)
+	}
+	order := map[string]int{jobRunning: 0, jobPending: 1, jobFailed: 2}
+	sort.SliceStable(list, func(i, j int) bool {
+		return order[list[i].State] < order[list[j].State]
+	})
+
+	return list
+}
+
+func runThumbnailJob(path string, job Job) error {
+	_, err := generateThumbnail(job.Path, libraryCacheDir(path))
+	return err
+}
+
+func runTranscodeJob(path string, job Job) error {
+	profile := findTranscodeProfile(job.Profile)
+	if profile == nil {
+		return errors.New("unknown quality " + job.Profile)
+	}
+	selected := *profile
+	selected.Subtitle = job.Subtitle
+
+	return cacheTranscode(path, VideoFile{Name: job.Video, Path: job.Path}, selected)
+}
+
+func runChecksumJob(path string, job Job) error {
+	_, err := writeChecksumManifest(path)
+	return err
+}
+
+func createJobsTemplate() *template.Template {
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Jobs - {{.Title}}</title>
+    <meta http-equiv="refresh" content="5">
+    <style>
+        body {
+            font-family: Arial, sans-serif;
+            margin: 20px;
+        }
+        table {
+            border-collapse: collapse;
+        }
+        th, td {
+            border-bottom: 1px solid #ddd;
+            padding: 6px 10px;
+            text-align: left;
+        }
+        .failed {
+            color: #c00;
+        }
+        form {
+            display: inline;
+        }
+    </style>
+</head>
+<body>
+    <p><a href="/">Back to the library</a></p>
+    <h1>Jobs</h1>
+    {{if .Jobs}}
+    <table>
+        <tr><th>Job</th><th>Video</th><th>State</th><th>Attempts</th><th>Error</th><th>Queued</th><th></th></tr>
+        {{range .Jobs}}
+        <tr>
+            <td>{{.Kind}}{{if .Profile}} ({{.Profile}}){{end}}</td>
+            <td>{{.Video}}</td>
+            <td class="{{.State}}">{{.State}}{{if and (eq .State "pending") .Attempts}}, retried at {{.RetryAt.Format "15:04:05"}}{{end}}</td>
+            <td>{{.Attempts}}</td>
+            <td>{{.Error}}</td>
+            <td>{{.Created.Format "2006-01-02 15:04:05"}}</td>
+            <td>
+                {{if eq .State "failed"}}
+                <form method="post" action="/api/jobs">
+                    <input type="hidden" name="action" value="retry">
+                    <input type="hidden" name="job" value="{{.ID}}">
+                    <button type="submit">Retry</button>
+                </form>
+                {{end}}
+                {{if ne .State "running"}}
+                <form method="post" action="/api/jobs">
+                    <input type="hidden" name="action" value="remove">
+                    <input type="hidden" name="job" value="{{.ID}}">
+                    <button type="submit">Remove</button>
+                </form>
+                {{end}}
+            </td>
+        </tr>
+        {{end}}
+    </table>
+    {{else}}
+    <p>No pending or failed jobs.</p>
+    {{end}}
+</body>
+</html>`
+
+	return template.Must(template.New("jobs").Parse(tmpl))
+}
+
+func handleJobs(w http.ResponseWriter, r *http.Request, tmpl *template.Template) {
+	data := struct {
+		Title string
+		Jobs  []Job
+	}{
+		Title: pageTitle,
+		Jobs:  jobs.list(),
+	}
+
+	tmpl.Execute(w, data)
+}
+
+// handleJobsAPI returns the jobs as JSON. A POST request retries a failed
+// job (action=retry) or removes a job (action=remove).
+func handleJobsAPI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPost:
+		var ok bool
+		switch r.FormValue("action") {
+		case "retry":
+			ok = jobs.retry(r.FormValue("job"))
+		case "remove":
+			ok = jobs.remove(r.FormValue("job"))
+		default:
+			httpError(w, r, "Invalid job action", http.StatusBadRequest)
+			return
+		}
+		if !ok {
+			notFound(w, r)
+			return
+		}
+
+		if !acceptsJSON(r) {
+			redirectToReferer(w, r)
+			return
+		}
+	default:
+		methodNotAllowed(w, r, "GET, HEAD, POST")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	// The path of the file on the server is not exposed.
+	list := jobs.list()
+	for i := range list {
+		list[i].Path = ""
+	}
+	json.NewEncoder(w).Encode(struct{ Jobs []Job }{list})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+)
+
+// Library describes a videos directory and the files storing its state,
+// named relatively to it. Its location on the server is not disclosed.
+type Library struct {
+	ID           string
+	Name         string
+	StateFile    string
+	SettingsFile string
+	Videos       int
+}
+
+// libraryID identifies a library from its absolute path, so that the cache
+// of a library is never shared with another one.
+func libraryID(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	sum := sha256.Sum256([]byte(path))
+
+	return hex.EncodeToString(sum[:8])
+}
+
+// libraryCacheDir is where the thumbnails, transcodes and other generated
+// files of the library are cached.
+func libraryCacheDir(path string) string {
+	return filepath.Join(cacheDir, "libraries", libraryID(path))
+}
+
+func newLibrary(path string, videoFiles []VideoFile) Library {
+	return Library{
+		ID:           libraryID(path),
+		Name:         pageTitle,
+		StateFile:    videoDataFile,
+		SettingsFile: settingsFile,
+		Videos:       len(videoFiles),
+	}
+}
+
+func handleLibraries(w http.ResponseWriter, r *http.Request, path string, videoFiles []VideoFile) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode([]Library{newLibrary(path, videoFiles)})
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	logBacklogSize     = 500
+	logStreamKeepAlive = 30 * time.Second
+)
+
+// logRecorder keeps the last lines of the server log and sends the new ones
+// to the /logs pages. It receives the output of the log package in debug
+// mode.
+type logRecorder struct {
+	mu          sync.Mutex
+	lines       []string
+	partial     string
+	subscribers map[chan string]bool
+}
+
+var serverLogs = &logRecorder{subscribers: make(map[chan string]bool)}
+
+func (l *logRecorder) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	text := l.partial + string(p)
+	lines := strings.Split(text, "\n")
+	l.partial = lines[len(lines)-1]
+
+	for _, line := range lines[:len(lines)-1] {
+		// A carriage return would end the server-sent event.
+		line = strings.ReplaceAll(line, "\r", "")
+		l.lines = append(l.lines, line)
+		for subscriber := range l.subscribers {
+			// A slow page misses lines rather than blocking the server.
+			select {
+			case subscriber <- line:
+			default:
+			}
+		}
+	}
+	if len(l.lines) > logBacklogSize {
+		l.lines = append([]string(nil), l.lines[len(l.lines)-logBacklogSize:]...)
+	}
+
+	return len(p), nil
+}
+
+// subscribe returns the recorded lines and a channel receiving the next ones.
+func (l *logRecorder) subscribe() ([]string, chan string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	subscriber := make(chan string, 100)
+	l.subscribers[subscriber] = true
+
+	return append([]string(nil), l.lines...), subscriber
+}
+
+func (l *logRecorder) unsubscribe(subscriber chan string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.subscribers, subscriber)
+}
+
+// startLogRecorder copies the server log to the recorder of the /logs page.
+func startLogRecorder() {
+	log.SetOutput(io.MultiWriter(os.Stderr, serverLogs))
+}
+
+func createLogsTemplate() *template.Template {
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Logs - {{.Title}}</title>
+    <style>
+        body {
+            font-family: Arial, sans-serif;
+            margin: 20px;
+        }
+        .logs {
+            font-family: monospace;
+            white-space: pre-wrap;
+            background: #111;
+            color: #eee;
+            padding: 10px;
+            height: 75vh;
+            overflow-y: auto;
+        }
+    </style>
+</head>
+<body>
+    <p><a href="/">Back to the library</a></p>
+    <h1>Logs</h1>
+    <p>
+        <label><input type="text" class="logs-filter" placeholder="Filter"></label>
+        <label><input type="checkbox" class="logs-follow" checked> Follow</label>
+        <span class="logs-status"></span>
+    </p>
+    <div class="logs" role="log" aria-live="polite"></div>
+    <script>
+        const logs = document.querySelector('.logs');
+        const filter = document.querySelector('.logs-filter');
+        const follow = document.querySelector('.logs-follow');
+        const status = document.querySelector('.logs-status');
+
+        function matches(line) {
+            return line.toLowerCase().includes(filter.value.toLowerCase());
+        }
+
+        const source = new EventSource('/logs/stream');
+        source.onopen = () => status.textContent = 'Connected';
+        source.onerror = () => status.textContent = 'Disconnected, reconnecting...';
+        source.onmessage = event => {
+            const line = document.createElement('div');
+            line.textContent = event.data;
+            line.hidden = !matches(event.data);
+            logs.appendChild(line);
+            while (logs.childElementCount > 2000) {
+                logs.firstElementChild.remove();
+            }
+            if (follow.checked) {
+                logs.scrollTop = logs.scrollHeight;
+            }
+        };
+        // The server sends the recorded lines again after a reconnection.
+        source.addEventListener('reset', () => logs.innerHTML = '');
+
+        filter.addEventListener('input', () => {
+            Array.from(logs.children).forEach(line => line.hidden = !matches(line.textContent));
+        });
+    </script>
+</body>
+</html>`
+
+	return template.Must(template.New("logs").Parse(tmpl))
+}
+
+func handleLogs(w http.ResponseWriter, r *http.Request, tmpl *template.Template) {
+	data := struct {
+		Title string
+	}{
+		Title: pageTitle,
+	}
+
+	tmpl.Execute(w, data)
+}
+
+// handleLogStream sends the recorded log lines, then the new ones as they
+// are written, as server-sent events.
+func handleLogStream(w http.ResponseWriter, r *http.Request) {
+	controller := http.NewResponseController(w)
+	// The stream lasts as long as the page is open.
+	controller.SetWriteDeadline(time.Time{})
+
+	lines, subscriber := serverLogs.subscribe()
+	defer serverLogs.unsubscribe(subscriber)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+
+	fmt.Fprint(w, "event: reset\ndata:\n\n")
+	for _, line := range lines {
+		fmt.Fprintf(w, "data: %s\n\n", line)
+	}
+	if err := controller.Flush(); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(logStreamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case line := <-subscriber:
+			fmt.Fprintf(w, "data: %s\n\n", line)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
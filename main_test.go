@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    float64
+		wantErr bool
+	}{
+		{value: "83", want: 83},
+		{value: "1m23s", want: 83},
+		{value: "1:23", want: 83},
+		{value: "1:02:03", want: 3723},
+		{value: "-5", wantErr: true},
+		{value: "NaN", wantErr: true},
+		{value: "Inf", wantErr: true},
+		{value: "1:NaN", wantErr: true},
+		{value: "abc", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseTimestamp(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTimestamp(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseTimestamp(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
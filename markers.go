@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The viewed state can be mirrored to the filesystem (-watched-markers), for
+// the other tools and scripts of the library: as an empty "<video>.watched"
+// file next to the video, or as the user.watched extended attribute of the
+// video.
+const (
+	markersFile  = "file"
+	markersXattr = "xattr"
+
+	watchedMarkerExt = ".watched"
+	watchedXattr     = "user.watched"
+)
+
+var (
+	watchedMarkers string
+	markersMu      sync.Mutex
+)
+
+func markersEnabled() bool {
+	return watchedMarkers != ""
+}
+
+func hasWatchedMarker(videoPath string) (bool, error) {
+	if watchedMarkers == markersXattr {
+		return getXattr(videoPath, watchedXattr)
+	}
+
+	_, err := os.Stat(videoPath + watchedMarkerExt)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func setWatchedMarker(videoPath string, watched bool) error {
+	if watchedMarkers == markersXattr {
+		return setXattr(videoPath, watchedXattr, watched)
+	}
+
+	if !watched {
+		if err := os.Remove(videoPath + watchedMarkerExt); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	return os.WriteFile(videoPath+watchedMarkerExt, nil, 0644)
+}
+
+// The markers of the last sync, by video name, tell which side changed when
+// the marker and the watch state of a video differ.
+func markersSyncFile(path string) string {
+	return filepath.Join(libraryCacheDir(path), "markers.json")
+}
+
+func loadMarkersSync(path string) map[string]bool {
+	synced := make(map[string]bool)
+
+	jsonData, err := os.ReadFile(markersSyncFile(path))
+	if err != nil {
+		return synced
+	}
+
+	if err := json.Unmarshal(jsonData, &synced); err != nil {
+		log.Printf("Error loading the watched markers: %v", err)
+	}
+
+	return synced
+}
+
+func saveMarkersSync(path string, synced map[string]bool) {
+	jsonData, err := json.Marshal(synced)
+	if err == nil {
+		if err = os.MkdirAll(libraryCacheDir(path), 0755); err == nil {
+			err = os.WriteFile(markersSyncFile(path), jsonData, 0644)
+		}
+	}
+	if err != nil {
+		log.Printf("Error saving the watched markers: %v", err)
+	}
+}
+
+// syncWatchedMarker writes the viewed state of a video, just saved, to its
+// marker when it changed.
+func syncWatchedMarker(video VideoFile, path string) {
+	if !markersEnabled() {
+		return
+	}
+	// Only the files of the library have markers, not the plugin videos.
+	if info, err := os.Stat(video.Path); err != nil || !info.Mode().IsRegular() {
+		return
+	}
+
+	markersMu.Lock()
+	defer markersMu.Unlock()
+
+	// The progress saves do not change the viewed state.
+	synced := loadMarkersSync(path)
+	if last, known := synced[video.File]; known && last == video.Viewed {
+		return
+	}
+
+	if err := setWatchedMarker(video.Path, video.Viewed); err != nil {
+		log.Printf("Error writing the watched marker of \"%s\": %v", video.Name, err)
+		return
+	}
+
+	synced[video.File] = video.Viewed
+	saveMarkersSync(path, synced)
+}
+
+// reconcileWatchedMarkers reconciles the markers with the watch state, when
+// scanning the library. When they differ, the side that changed since the
+// last sync wins: a marker added or removed by another tool changes the
+// viewed state, otherwise the marker is rewritten. A marker found the first
+// time marks the video as viewed. It returns whether the watch state changed.
+func reconcileWatchedMarkers(videoFiles []VideoFile, path string) bool {
+	markersMu.Lock()
+	defer markersMu.Unlock()
+
+	synced := loadMarkersSync(path)
+	changed := false
+	syncedChanged := false
+	for i := range videoFiles {
+		video := &videoFiles[i]
+		if info, err := os.Stat(video.Path); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+
+		marked, err := hasWatchedMarker(video.Path)
+		if err != nil {
+			log.Printf("Error reading the watched marker of \"%s\": %v", video.Name, err)
+			continue
+		}
+
+		last, known := synced[video.File]
+		switch {
+		case marked == video.Viewed:
+		case (known && last == video.Viewed) || (!known && marked):
+			debug("Set \"%s\" as viewed=%v from its marker", video.Name, marked)
+			video.Viewed = marked
+			video.Updated = time.Now()
+			if marked {
+				video.Current = video.Updated
+				video.Progress = 0
+			}
+			changed = true
+		default:
+			if err := setWatchedMarker(video.Path, video.Viewed); err != nil {
+				log.Printf("Error writing the watched marker of \"%s\": %v", video.Name, err)
+				continue
+			}
+		}
+
+		if !known || last != video.Viewed {
+			synced[video.File] = video.Viewed
+			syncedChanged = true
+		}
+	}
+
+	if syncedChanged {
+		saveMarkersSync(path, synced)
+	}
+
+	return changed
+}
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	tmdbAPIURL   = "https://api.themoviedb.org/3"
+	tmdbImageURL = "https://image.tmdb.org/t/p/w500"
+)
+
+var (
+	metadataStore   = metadataCache{Shows: map[string]int{}, Videos: map[string]*Metadata{}}
+	metadataStoreMu sync.Mutex
+	enrichMu        sync.Mutex
+
+	tmdbClient = &http.Client{Timeout: 10 * time.Second}
+
+	movieTitlePattern = regexp.MustCompile(`^(?P<title>.+?)[ ._(\[]+(?P<year>(?:19|20)\d{2})\b`)
+)
+
+// Metadata holds the information fetched from TMDB for a video. Image is the
+// name of the artwork file stored in the metadata cache directory. Fields
+// holds the other information, such as the release date or the rating, by
+// lowercase name, following the names of the NFO files.
+type Metadata struct {
+	Title    string
+	Overview string
+	Image    string
+	Fields   map[string]string `json:",omitempty"`
+}
+
+type metadataCache struct {
+	Shows  map[string]int
+	Videos map[string]*Metadata
+}
+
+func metadataEnabled() bool {
+	return tmdbAPIKey != ""
+}
+
+func metadataDir() string {
+	return filepath.Join(cacheDir, "tmdb")
+}
+
+func loadMetadataCache() {
+	jsonData, err := os.ReadFile(filepath.Join(metadataDir(), "metadata.json"))
+	if err != nil {
+		return
+	}
+
+	metadataStoreMu.Lock()
+	defer metadataStoreMu.Unlock()
+
+	if err := json.Unmarshal(jsonData, &metadataStore); err != nil {
+		log.Printf("Error loading metadata cache: %v", err)
+	}
+	if metadataStore.Shows == nil {
+		metadataStore.Shows = map[string]int{}
+	}
+	if metadataStore.Videos == nil {
+		metadataStore.Videos = map[string]*Metadata{}
+	}
+}
+
+func saveMetadataCache() {
+	metadataStoreMu.Lock()
+	jsonData, err := json.Marshal(metadataStore)
+	metadataStoreMu.Unlock()
+	if err != nil {
+		log.Printf("Error marshaling metadata cache: %v", err)
+		return
+	}
+
+	prettyJSON := &bytes.Buffer{}
+	if err := json.Indent(prettyJSON, jsonData, "", "    "); err == nil {
+		err = os.WriteFile(filepath.Join(metadataDir(), "metadata.json"), prettyJSON.Bytes(), 0644)
+		if err != nil {
+			log.Printf("Error saving metadata cache: %v", err)
+		}
+	}
+}
+
+// metadataKey identifies the TMDB entry of a video, or returns "" when the
+// file name does not look like an episode or a movie.
+func metadataKey(video VideoFile) string {
+	if video.Show != "" && video.Episode > 0 {
+		season := video.Season
+		if season == 0 {
+			season = 1
+		}
+		return fmt.Sprintf("tv:%s:%d:%d", strings.ToLower(video.Show), season, video.Episode)
+	}
+
+	base := strings.TrimSuffix(video.Name, filepath.Ext(video.Name))
+	if match := movieTitlePattern.FindStringSubmatch(base); match != nil {
+		return fmt.Sprintf("movie:%s:%s", strings.ToLower(cleanTitle(match[1])), match[2])
+	}
+
+	return ""
+}
+
+// videoMetadata merges the metadata stored next to a video with the one of
+// the metadata providers and the one fetched from TMDB, local files taking
+// precedence. Image is the path of the artwork file.
+func videoMetadata(video VideoFile) *Metadata {
+	metadata := mergeMetadata(localMetadata(video), providedMetadata(video))
+
+	remote := cachedMetadata(video)
+	if remote == nil {
+		return metadata
+	}
+
+	tmdb := *remote
+	if tmdb.Image != "" {
+		tmdb.Image = filepath.Join(metadataDir(), tmdb.Image)
+	}
+
+	return mergeMetadata(metadata, &tmdb)
+}
+
+// mergeMetadata completes metadata with the information of other. The
+// metadata is copied, so that cached metadata is never modified.
+func mergeMetadata(metadata *Metadata, other *Metadata) *Metadata {
+	if other == nil {
+		return metadata
+	}
+	if metadata == nil {
+		metadata = &Metadata{}
+	}
+
+	merged := *metadata
+	if merged.Title == "" {
+		merged.Title = other.Title
+	}
+	if merged.Overview == "" {
+		merged.Overview = other.Overview
+	}
+	if merged.Image == "" {
+		merged.Image = other.Image
+	}
+	merged.Fields = mergeFields(mergeFields(nil, metadata.Fields), other.Fields)
+
+	return &merged
+}
+
+// mergeFields adds the fields missing from fields.
+func mergeFields(fields map[string]string, others map[string]string) map[string]string {
+	for name, value := range others {
+		if fields == nil {
+			fields = make(map[string]string)
+		}
+		if _, ok := fields[name]; !ok {
+			fields[name] = value
+		}
+	}
+
+	return fields
+}
+
+// videoFields returns the extra metadata of a video: the fields of its NFO
+// files and of TMDB, completed by the tags of the file. Probing the tags
+// runs ffprobe, otherwise only the tags already read are included.
+func videoFields(video VideoFile, probe bool) map[string]string {
+	var fields map[string]string
+	if metadata := videoMetadata(video); metadata != nil {
+		fields = mergeFields(fields, metadata.Fields)
+	}
+
+	return mergeFields(fields, probeTags(video.Path, probe))
+}
+
+// Fields returns the extra metadata of a video for the templates, such as
+// {{.Fields.genre}}. The tags of the file are only included once probed by
+// the watch page or the metadata API.
+func (v VideoFile) Fields() map[string]string {
+	return videoFields(v, false)
+}
+
+// cachedMetadata returns the metadata of a video if it has already been
+// fetched. It never queries TMDB.
+func cachedMetadata(video VideoFile) *Metadata {
+	if !metadataEnabled() {
+		return nil
+	}
+
+	key := metadataKey(video)
+	if key == "" {
+		return nil
+	}
+
+	metadataStoreMu.Lock()
+	defer metadataStoreMu.Unlock()
+
+	return metadataStore.Videos[key]
+}
+
+// enrichMetadata fetches the missing metadata of the given videos in the
+// background.
+func enrichMetadata(videoFiles []VideoFile) {
+	if !metadataEnabled() {
+		return
+	}
+
+	videos := make([]VideoFile, len(videoFiles))
+	copy(videos, videoFiles)
+
+	go func() {
+		enrichMu.Lock()
+		defer enrichMu.Unlock()
+
+		if err := os.MkdirAll(metadataDir(), 0755); err != nil {
+			log.Printf("Error creating metadata cache: %v", err)
+			return
+		}
+
+		updated := false
+		for _, video := range videos {
+			key := metadataKey(video)
+			if key == "" {
+				continue
+			}
+
+			metadataStoreMu.Lock()
+			_, ok := metadataStore.Videos[key]
+			metadataStoreMu.Unlock()
+			if ok {
+				continue
+			}
+
+			metadata, err := fetchMetadata(video)
+			if err != nil {
+				log.Printf("Error fetching metadata of \"%s\": %v", video.Name, err)
+				continue
+			}
+
+			metadataStoreMu.Lock()
+			metadataStore.Videos[key] = metadata
+			metadataStoreMu.Unlock()
+			updated = true
+		}
+
+		if updated {
+			saveMetadataCache()
+		}
+	}()
+}
+
+func fetchMetadata(video VideoFile) (*Metadata, error) {
+	if video.Show != "" && video.Episode > 0 {
+		return fetchEpisodeMetadata(video)
+	}
+
+	base := strings.TrimSuffix(video.Name, filepath.Ext(video.Name))
+	match := movieTitlePattern.FindStringSubmatch(base)
+
+	return fetchMovieMetadata(cleanTitle(match[1]), match[2])
+}
+
+func fetchEpisodeMetadata(video VideoFile) (*Metadata, error) {
+	showKey := strings.ToLower(video.Show)
+
+	metadataStoreMu.Lock()
+	showID, ok := metadataStore.Shows[showKey]
+	metadataStoreMu.Unlock()
+
+	if !ok {
+		var search struct {
+			Results []struct {
+				ID int `json:"id"`
+			} `json:"results"`
+		}
+		if err := tmdbGet("/search/tv", url.Values{"query": {video.Show}}, &search); err != nil {
+			return nil, err
+		}
+		if len(search.Results) > 0 {
+			showID = search.Results[0].ID
+		}
+
+		metadataStoreMu.Lock()
+		metadataStore.Shows[showKey] = showID
+		metadataStoreMu.Unlock()
+	}
+
+	if showID == 0 {
+		return nil, nil
+	}
+
+	season := video.Season
+	if season == 0 {
+		season = 1
+	}
+
+	var episode struct {
+		Name        string  `json:"name"`
+		Overview    string  `json:"overview"`
+		StillPath   string  `json:"still_path"`
+		AirDate     string  `json:"air_date"`
+		VoteAverage float64 `json:"vote_average"`
+	}
+	err := tmdbGet(fmt.Sprintf("/tv/%d/season/%d/episode/%d", showID, season, video.Episode), nil, &episode)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Metadata{
+		Title:    episode.Name,
+		Overview: episode.Overview,
+		Image:    downloadArtwork(episode.StillPath),
+		Fields: tmdbFields(map[string]string{
+			"aired":  episode.AirDate,
+			"rating": formatRating(episode.VoteAverage),
+		}),
+	}, nil
+}
+
+func fetchMovieMetadata(title string, year string) (*Metadata, error) {
+	var search struct {
+		Results []struct {
+			Title            string  `json:"title"`
+			OriginalTitle    string  `json:"original_title"`
+			OriginalLanguage string  `json:"original_language"`
+			Overview         string  `json:"overview"`
+			PosterPath       string  `json:"poster_path"`
+			ReleaseDate      string  `json:"release_date"`
+			VoteAverage      float64 `json:"vote_average"`
+		} `json:"results"`
+	}
+	if err := tmdbGet("/search/movie", url.Values{"query": {title}, "year": {year}}, &search); err != nil {
+		return nil, err
+	}
+
+	if len(search.Results) == 0 {
+		return nil, nil
+	}
+
+	movie := search.Results[0]
+
+	return &Metadata{
+		Title:    movie.Title,
+		Overview: movie.Overview,
+		Image:    downloadArtwork(movie.PosterPath),
+		Fields: tmdbFields(map[string]string{
+			"originaltitle":    movie.OriginalTitle,
+			"originallanguage": movie.OriginalLanguage,
+			"premiered":        movie.ReleaseDate,
+			"rating":           formatRating(movie.VoteAverage),
+		}),
+	}, nil
+}
+
+// tmdbFields drops the fields TMDB left empty.
+func tmdbFields(fields map[string]string) map[string]string {
+	for name, value := range fields {
+		if value == "" {
+			delete(fields, name)
+		}
+	}
+
+	return fields
+}
+
+func formatRating(rating float64) string {
+	if rating == 0 {
+		return ""
+	}
+
+	return strconv.FormatFloat(rating, 'f', 1, 64)
+}
+
+func tmdbGet(endpoint string, params url.Values, v any) error {
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("api_key", tmdbAPIKey)
+
+	resp, err := tmdbClient.Get(tmdbAPIURL + endpoint + "?" + params.Encode())
+	if err != nil {
+		// The error holds the URL, and so the API key: keep only its cause.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return fmt.Errorf("TMDB %s: %w", endpoint, urlErr.Err)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("TMDB returned %s", resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// downloadArtwork stores a TMDB image in the metadata cache and returns its
+// file name, or "" if it could not be downloaded.
+func downloadArtwork(imagePath string) string {
+	if imagePath == "" {
+		return ""
+	}
+
+	name := path.Base(imagePath)
+	file := filepath.Join(metadataDir(), name)
+	if _, err := os.Stat(file); err == nil {
+		return name
+	}
+
+	resp, err := tmdbClient.Get(tmdbImageURL + imagePath)
+	if err != nil {
+		log.Printf("Error downloading artwork: %v", err)
+		return ""
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Error downloading artwork: %s", resp.Status)
+		return ""
+	}
+
+	out, err := os.Create(file)
+	if err != nil {
+		log.Printf("Error saving artwork: %v", err)
+		return ""
+	}
+
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		out.Close()
+		os.Remove(file)
+		log.Printf("Error saving artwork: %v", err)
+		return ""
+	}
+
+	if err := out.Close(); err != nil {
+		os.Remove(file)
+		return ""
+	}
+
+	return name
+}
+
+func handleArtwork(w http.ResponseWriter, r *http.Request, videoFiles []VideoFile) {
+	i := findVideoFile(videoFiles, strings.TrimPrefix(r.URL.Path, "/artwork/"))
+	if i < 0 {
+		notFound(w, r)
+		return
+	}
+
+	metadata := videoMetadata(videoFiles[i])
+	if metadata == nil || metadata.Image == "" {
+		notFound(w, r)
+		return
+	}
+
+	http.ServeFile(w, r, metadata.Image)
+}
+
+// handleVideoMetadata returns the metadata of a video, with its extra fields.
+func handleVideoMetadata(w http.ResponseWriter, r *http.Request, videoFiles []VideoFile) {
+	i := findVideoFile(videoFiles, strings.TrimPrefix(r.URL.Path, "/api/metadata/"))
+	if i < 0 {
+		notFound(w, r)
+		return
+	}
+
+	data := struct {
+		Name     string
+		Title    string
+		Overview string
+		Fields   map[string]string
+	}{
+		Name:   videoFiles[i].Name,
+		Fields: videoFields(videoFiles[i], true),
+	}
+	if metadata := videoMetadata(videoFiles[i]); metadata != nil {
+		data.Title = metadata.Title
+		data.Overview = metadata.Overview
+	}
+	if data.Fields == nil {
+		data.Fields = map[string]string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// The library is only listed again on rescans, so a file can be moved or
+// deleted while it is listed. The missing files are noticed when they are
+// opened, and marked in the lists until they come back or the next scan. Their
+// watch state is kept for when they come back.
+var (
+	missingFiles   = make(map[string]bool)
+	missingFilesMu sync.Mutex
+)
+
+// videoFileExists checks that the file of the video is still there, and
+// records the result for the lists.
+func videoFileExists(video VideoFile) bool {
+	_, err := os.Stat(video.Path)
+	missing := errors.Is(err, fs.ErrNotExist)
+
+	missingFilesMu.Lock()
+	defer missingFilesMu.Unlock()
+
+	if missing {
+		missingFiles[video.Path] = true
+	} else {
+		delete(missingFiles, video.Path)
+	}
+
+	return !missing
+}
+
+// videoMissing reports whether the file of the video was missing when it was
+// last opened.
+func videoMissing(video VideoFile) bool {
+	missingFilesMu.Lock()
+	defer missingFilesMu.Unlock()
+
+	return missingFiles[video.Path]
+}
+
+// forgetMissingFiles clears the missing files after a scan, which lists the
+// files that are there.
+func forgetMissingFiles() {
+	missingFilesMu.Lock()
+	defer missingFilesMu.Unlock()
+
+	clear(missingFiles)
+}
+
+// videoFileMissing replies that the file of the video is gone, with 410 Gone
+// rather than 404 Not Found, to tell it from an unknown video.
+func videoFileMissing(w http.ResponseWriter, r *http.Request) {
+	httpError(w, r, "The file of this video is missing: it was moved or deleted since the library was scanned", http.StatusGone)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var seasonFolderPattern = regexp.MustCompile(`(?i)^(season|series|saison)[ ._-]*\d+$|^specials$`)
+
+// The NFO files, and the number of videos of the folders, are read again
+// only once they changed, as the metadata is read for every tile.
+var (
+	nfoCache   = make(map[string]cachedNFO)
+	nfoCacheMu sync.Mutex
+
+	folderVideosCache   = make(map[string]cachedFolderVideos)
+	folderVideosCacheMu sync.Mutex
+)
+
+type cachedNFO struct {
+	size    int64
+	modTime time.Time
+	nfo     nfoFile
+	ok      bool
+}
+
+type cachedFolderVideos struct {
+	modTime time.Time
+	count   int
+}
+
+type nfoFile struct {
+	Title  string     `xml:"title"`
+	Plot   string     `xml:"plot"`
+	Others []nfoField `xml:",any"`
+}
+
+type nfoField struct {
+	XMLName xml.Name
+	Value   string `xml:",chardata"`
+}
+
+// fields returns the other elements of the file (year, genre, studio...) by
+// lowercase name. Repeated elements are joined.
+func (nfo nfoFile) fields() map[string]string {
+	fields := make(map[string]string)
+	for _, field := range nfo.Others {
+		// Elements with children, such as the actors, are skipped.
+		value := strings.TrimSpace(field.Value)
+		if value == "" {
+			continue
+		}
+
+		name := strings.ToLower(field.XMLName.Local)
+		if fields[name] != "" {
+			value = fields[name] + ", " + value
+		}
+		fields[name] = value
+	}
+
+	return fields
+}
+
+// localMetadata reads the metadata stored next to a video following the Kodi
+// conventions: "<name>.nfo", "movie.nfo" (for the only video of its folder)
+// and "tvshow.nfo" for titles and descriptions (and the other fields),
+// "<name>-thumb.jpg", "<name>-poster.jpg" and "fanart.jpg" for artwork.
+func localMetadata(video VideoFile) *Metadata {
+	dir := filepath.Dir(video.Path)
+	base := strings.TrimSuffix(video.Path, filepath.Ext(video.Path))
+
+	// Shows are usually split in "Season N" folders, their files are
+	// stored one level up.
+	dirs := []string{dir}
+	if seasonFolderPattern.MatchString(filepath.Base(dir)) {
+		dirs = append(dirs, filepath.Dir(dir))
+	}
+
+	// movie.nfo describes the movie of its folder, not each of its videos.
+	nfoFiles := []string{base + ".nfo"}
+	if folderVideos(dir) == 1 {
+		nfoFiles = append(nfoFiles, filepath.Join(dir, "movie.nfo"))
+	}
+
+	metadata := &Metadata{}
+	for _, file := range nfoFiles {
+		if nfo, ok := readNFO(file); ok {
+			metadata.Title = nfo.Title
+			metadata.Overview = nfo.Plot
+			metadata.Fields = nfo.fields()
+			break
+		}
+	}
+
+	// The fields of the show apply to its episodes.
+	for _, d := range dirs {
+		if nfo, ok := readNFO(filepath.Join(d, "tvshow.nfo")); ok {
+			if metadata.Overview == "" {
+				metadata.Overview = nfo.Plot
+			}
+			metadata.Fields = mergeFields(metadata.Fields, nfo.fields())
+			break
+		}
+	}
+
+	artworks := []string{base + "-thumb.jpg", base + "-poster.jpg"}
+	for _, d := range dirs {
+		artworks = append(artworks, filepath.Join(d, "fanart.jpg"))
+	}
+	for _, file := range artworks {
+		if _, err := os.Stat(file); err == nil {
+			metadata.Image = file
+			break
+		}
+	}
+
+	if metadata.Title == "" && metadata.Overview == "" && metadata.Image == "" && len(metadata.Fields) == 0 {
+		return nil
+	}
+
+	return metadata
+}
+
+func readNFO(file string) (nfoFile, bool) {
+	var nfo nfoFile
+
+	info, err := os.Stat(file)
+	if err != nil {
+		return nfo, false
+	}
+
+	nfoCacheMu.Lock()
+	cached, ok := nfoCache[file]
+	nfoCacheMu.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.nfo, cached.ok
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nfo, false
+	}
+
+	// Some NFO files only contain a scraper URL, they are ignored.
+	ok = true
+	if err := xml.Unmarshal(content, &nfo); err != nil {
+		debug("Ignore \"%s\": %v", file, err)
+		nfo, ok = nfoFile{}, false
+	}
+
+	nfo.Title = strings.TrimSpace(nfo.Title)
+	nfo.Plot = strings.TrimSpace(nfo.Plot)
+
+	nfoCacheMu.Lock()
+	nfoCache[file] = cachedNFO{info.Size(), info.ModTime(), nfo, ok}
+	nfoCacheMu.Unlock()
+
+	return nfo, ok
+}
+
+// folderVideos returns the number of videos directly in the folder.
+func folderVideos(dir string) int {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return 0
+	}
+
+	folderVideosCacheMu.Lock()
+	cached, ok := folderVideosCache[dir]
+	folderVideosCacheMu.Unlock()
+	if ok && cached.modTime.Equal(info.ModTime()) {
+		return cached.count
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+
+	count := 0
+	for _, entry := range entries {
+		if !entry.IsDir() && videoExtensions[strings.ToLower(filepath.Ext(entry.Name()))] {
+			count++
+		}
+	}
+
+	folderVideosCacheMu.Lock()
+	folderVideosCache[dir] = cachedFolderVideos{info.ModTime(), count}
+	folderVideosCacheMu.Unlock()
+
+	return count
+}
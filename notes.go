@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const notesFile = "video_notes.json"
+
+// Bookmark is a timestamped note, Time being in seconds.
+type Bookmark struct {
+	Time float64
+	Text string
+}
+
+type VideoNotes struct {
+	Note      string     `json:",omitempty"`
+	Bookmarks []Bookmark `json:",omitempty"`
+}
+
+var notesMu sync.Mutex
+
+func loadNotes(path string) (map[string]VideoNotes, error) {
+	notes := make(map[string]VideoNotes)
+
+	jsonData, err := os.ReadFile(filepath.Join(path, notesFile))
+	if err != nil {
+		return notes, nil
+	}
+
+	if err := json.Unmarshal(jsonData, &notes); err != nil {
+		return nil, err
+	}
+
+	return notes, nil
+}
+
+func saveNotes(path string, notes map[string]VideoNotes) error {
+	jsonData, err := json.Marshal(notes)
+	if err != nil {
+		return err
+	}
+
+	prettyJSON := &bytes.Buffer{}
+	if err := json.Indent(prettyJSON, jsonData, "", "    "); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(path, notesFile), prettyJSON.Bytes(), 0644)
+}
+
+// formatTimestamp formats seconds as "1:02:03", or "2:03" under an hour.
+func formatTimestamp(seconds float64) string {
+	s := int(seconds)
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s%3600/60, s%60)
+	}
+
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+// handleNotes updates the note of a video, and adds or deletes (with
+// delete=1) the bookmark at the given time.
+func handleNotes(w http.ResponseWriter, r *http.Request, videoFiles []VideoFile, path string) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		httpError(w, r, "Invalid form", http.StatusBadRequest)
+		return
+	}
+
+	i := findVideoFile(videoFiles, strings.TrimPrefix(r.URL.Path, "/notes/"))
+	if i < 0 {
+		notFound(w, r)
+		return
+	}
+	name := videoFiles[i].Name
+
+	notesMu.Lock()
+	defer notesMu.Unlock()
+
+	notes, err := loadNotes(path)
+	if err != nil {
+		log.Printf("Error loading notes: %v", err)
+		httpError(w, r, "Error loading notes", http.StatusInternalServerError)
+		return
+	}
+
+	videoNotes := notes[name]
+	if r.PostForm.Has("note") {
+		videoNotes.Note = strings.TrimSpace(r.FormValue("note"))
+	}
+
+	if value := r.FormValue("time"); value != "" {
+		t, err := parseTimestamp(value)
+		if err != nil {
+			httpError(w, r, "Invalid timestamp", http.StatusBadRequest)
+			return
+		}
+
+		bookmarks := videoNotes.Bookmarks[:0:0]
+		for _, bookmark := range videoNotes.Bookmarks {
+			if formatTimestamp(bookmark.Time) != formatTimestamp(t) {
+				bookmarks = append(bookmarks, bookmark)
+			}
+		}
+		if r.FormValue("delete") == "" {
+			bookmarks = append(bookmarks, Bookmark{Time: t, Text: strings.TrimSpace(r.FormValue("text"))})
+		}
+		sort.SliceStable(bookmarks, func(i, j int) bool {
+			return bookmarks[i].Time < bookmarks[j].Time
+		})
+		videoNotes.Bookmarks = bookmarks
+	}
+
+	if videoNotes.Note == "" && len(videoNotes.Bookmarks) == 0 {
+		delete(notes, name)
+	} else {
+		notes[name] = videoNotes
+	}
+
+	if err := saveNotes(path, notes); err != nil {
+		log.Printf("Error saving notes: %v", err)
+		httpError(w, r, "Error saving notes", http.StatusInternalServerError)
+		return
+	}
+
+	detail := "note updated"
+	if value := r.FormValue("time"); value != "" {
+		detail = "bookmark at " + value
+		if r.FormValue("delete") != "" {
+			detail = "bookmark at " + value + " deleted"
+		}
+	}
+	audit(r, path, auditNotes, name, detail)
+
+	target := "/watch/" + videoFiles[i].ID
+	if value := r.FormValue("time"); value != "" {
+		target += "?t=" + url.QueryEscape(value)
+	}
+
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const diskCheckInterval = 10 * time.Minute
+
+var (
+	ntfyURL      string
+	gotifyURL    string
+	gotifyToken  string
+	notifyEvents string
+	diskMinFree  float64
+)
+
+func notificationsEnabled() bool {
+	return ntfyURL != "" || (gotifyURL != "" && gotifyToken != "")
+}
+
+// startNotifications subscribes the configured push services to the events
+// listed in -notify-events.
+func startNotifications(path string) {
+	enabled := make(map[string]bool)
+	for _, eventType := range strings.Split(notifyEvents, ",") {
+		enabled[strings.TrimSpace(eventType)] = true
+	}
+
+	subscribe(func(event Event) {
+		if !enabled[event.Type] {
+			return
+		}
+
+		title, message := notificationText(event)
+		if ntfyURL != "" {
+			sendNtfy(title, message)
+		}
+		if gotifyURL != "" && gotifyToken != "" {
+			sendGotify(title, message)
+		}
+	})
+
+	if enabled[eventDiskLow] {
+		startDiskMonitor(path)
+	}
+}
+
+func notificationText(event Event) (string, string) {
+	folder := event.Folder
+	if folder == "" {
+		folder = event.Library
+	}
+
+	switch event.Type {
+	case eventVideoAdded:
+		return "New video in " + folder, event.Video
+	case eventVideoCompleted:
+		return "Video finished", event.Video + " (" + folder + ")"
+	case eventCourseCompleted:
+		return "Course completed", "All the videos of " + folder + " have been watched"
+	case eventDiskLow:
+		return "Disk nearly full", event.Detail
+	}
+
+	return event.Type, event.Video
+}
+
+func sendNtfy(title string, message string) {
+	req, err := http.NewRequest(http.MethodPost, ntfyURL, strings.NewReader(message))
+	if err != nil {
+		log.Printf("Error sending ntfy notification: %v", err)
+		return
+	}
+	req.Header.Set("Title", title)
+
+	sendNotification("ntfy", req)
+}
+
+func sendGotify(title string, message string) {
+	body, err := json.Marshal(map[string]any{"title": title, "message": message, "priority": 5})
+	if err != nil {
+		log.Printf("Error marshaling Gotify notification: %v", err)
+		return
+	}
+
+	endpoint := strings.TrimSuffix(gotifyURL, "/") + "/message"
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Error sending Gotify notification: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", gotifyToken)
+
+	sendNotification("Gotify", req)
+}
+
+func sendNotification(service string, req *http.Request) {
+	resp, err := webhookClient.Do(req)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			err = fmt.Errorf("unexpected status %s", resp.Status)
+		}
+	}
+	if err != nil {
+		log.Printf("Error sending %s notification: %v", service, err)
+	}
+}
+
+// startDiskMonitor publishes an event when the free space of the library
+// disk falls below -disk-min-free, and again only once it went back above.
+func startDiskMonitor(path string) {
+	go func() {
+		low := false
+		ticker := time.NewTicker(diskCheckInterval)
+		defer ticker.Stop()
+
+		for ; ; <-ticker.C {
+			free, total, err := diskSpace(path)
+			if err != nil {
+				debug("Disk monitor disabled: %v", err)
+				return
+			}
+
+			percent := float64(free) / float64(total) * 100
+			if percent >= diskMinFree {
+				low = false
+				continue
+			}
+			if low {
+				continue
+			}
+
+			low = true
+			publish(Event{
+				Type:    eventDiskLow,
+				Time:    time.Now(),
+				Library: pageTitle,
+				Detail:  fmt.Sprintf("%.1f%% free (%d MB) on the disk of %s", percent, free>>20, pageTitle),
+			})
+		}
+	}()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	oembedWidth  = 640
+	oembedHeight = 360
+)
+
+type OpenGraph struct {
+	URL       string
+	VideoURL  string
+	ImageURL  string
+	OEmbedURL string
+	Duration  int
+}
+
+type OEmbed struct {
+	Version      string `json:"version"`
+	Type         string `json:"type"`
+	Title        string `json:"title"`
+	ProviderName string `json:"provider_name"`
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+	HTML         string `json:"html"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+}
+
+func newOpenGraph(r *http.Request, video *VideoFile) *OpenGraph {
+	base := baseURL(r)
+	name := video.ID
+
+	og := &OpenGraph{
+		URL:      base + "/watch/" + name,
+		VideoURL: base + "/video/" + name,
+		Duration: int(probeDuration(video.Path)),
+	}
+	og.OEmbedURL = base + "/oembed?format=json&url=" + url.QueryEscape(og.URL)
+
+	if thumbnailsEnabled() {
+		og.ImageURL = base + "/thumbnail/" + name
+	}
+
+	return og
+}
+
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	// The scheme of the client is only taken from the trusted proxies.
+	if addr, ok := requestAddr(r, nil); ok && networksContain(proxyNetworks, addr) {
+		if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+	}
+
+	return scheme + "://" + r.Host
+}
+
+func handleOEmbed(w http.ResponseWriter, r *http.Request, videoFiles []VideoFile) {
+	if format := r.URL.Query().Get("format"); format != "" && format != "json" {
+		httpError(w, r, "Unsupported format", http.StatusNotImplemented)
+		return
+	}
+
+	target, err := url.Parse(r.URL.Query().Get("url"))
+	if err != nil || !strings.HasPrefix(target.Path, "/watch/") {
+		notFound(w, r)
+		return
+	}
+
+	i := findVideoFile(videoFiles, strings.TrimPrefix(target.Path, "/watch/"))
+	if i < 0 {
+		notFound(w, r)
+		return
+	}
+	video := videoFiles[i]
+
+	width, height := oembedWidth, oembedHeight
+	if maxWidth, err := strconv.Atoi(r.URL.Query().Get("maxwidth")); err == nil && maxWidth > 0 && maxWidth < width {
+		width, height = maxWidth, maxWidth*oembedHeight/oembedWidth
+	}
+	if maxHeight, err := strconv.Atoi(r.URL.Query().Get("maxheight")); err == nil && maxHeight > 0 && maxHeight < height {
+		width, height = maxHeight*oembedWidth/oembedHeight, maxHeight
+	}
+
+	base := baseURL(r)
+	embedURL := base + "/embed/" + video.ID
+
+	data := OEmbed{
+		Version:      "1.0",
+		Type:         "video",
+		Title:        video.DisplayName(),
+		ProviderName: pageTitle,
+		HTML:         fmt.Sprintf(`<iframe src="%s" width="%d" height="%d" frameborder="0" allowfullscreen></iframe>`, html.EscapeString(embedURL), width, height),
+		Width:        width,
+		Height:       height,
+	}
+	if thumbnailsEnabled() {
+		data.ThumbnailURL = base + "/thumbnail/" + video.ID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"log"
+	"net/http"
+)
+
+// applyFolderOrders reorders the videos of the folders having a custom
+// order, within the positions they occupy. The videos missing from the
+// order, such as the new ones, keep their place after the ordered ones.
+func applyFolderOrders(videoFiles []VideoFile, orders map[string][]string) {
+	for folder, order := range orders {
+		rank := make(map[string]int)
+		for i, name := range order {
+			rank[name] = i + 1
+		}
+
+		var slots []int
+		var videos []VideoFile
+		for i, video := range videoFiles {
+			if video.Folder == folder {
+				slots = append(slots, i)
+				videos = append(videos, video)
+			}
+		}
+
+		ordered := make([]VideoFile, 0, len(videos))
+		for _, name := range order {
+			for _, video := range videos {
+				if video.Name == name {
+					ordered = append(ordered, video)
+					break
+				}
+			}
+		}
+		for _, video := range videos {
+			if rank[video.Name] == 0 {
+				ordered = append(ordered, video)
+			}
+		}
+
+		for i, slot := range slots {
+			videoFiles[slot] = ordered[i]
+		}
+	}
+}
+
+// handleOrder saves the custom order of the videos of a folder, sent by the
+// drag-and-drop of the video list. Without videos, the folder goes back to
+// the file name order.
+func handleOrder(w http.ResponseWriter, r *http.Request, videoFiles []VideoFile, path string, rescan func()) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		httpError(w, r, "Invalid form", http.StatusBadRequest)
+		return
+	}
+
+	folder := r.FormValue("folder")
+	var names []string
+	seen := make(map[string]bool)
+	for _, id := range r.Form["video"] {
+		i := findVideoFile(videoFiles, id)
+		if i < 0 || videoFiles[i].Folder != folder {
+			httpError(w, r, "Invalid video "+id, http.StatusBadRequest)
+			return
+		}
+		if !seen[videoFiles[i].Name] {
+			seen[videoFiles[i].Name] = true
+			names = append(names, videoFiles[i].Name)
+		}
+	}
+
+	settings, err := loadSettings(path)
+	if err != nil {
+		log.Printf("Error loading settings: %v", err)
+	}
+
+	if len(names) == 0 {
+		delete(settings.Orders, folder)
+	} else {
+		if settings.Orders == nil {
+			settings.Orders = make(map[string][]string)
+		}
+		settings.Orders[folder] = names
+	}
+
+	if err := saveSettings(settings, path); err != nil {
+		log.Printf("Error saving settings: %v", err)
+		httpError(w, r, "Error saving settings", http.StatusInternalServerError)
+		return
+	}
+	rescan()
+
+	if acceptsJSON(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	redirectToReferer(w, r)
+}
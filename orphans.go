@@ -0,0 +1,455 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// maxRenameCandidates is the number of videos suggested to re-link an
+// orphaned entry to.
+const maxRenameCandidates = 5
+
+var (
+	errNotOrphaned = errors.New("not an orphaned entry")
+	errStateExists = errors.New("the video already has a watch state")
+)
+
+// OrphanedEntry is the saved watch state of a video whose file is not in the
+// library anymore. Candidates are the videos it may have been renamed to.
+type OrphanedEntry struct {
+	Video      VideoFile
+	Kept       bool
+	Candidates []OrphanCandidate `json:",omitempty"`
+}
+
+type OrphanCandidate struct {
+	ID   string
+	File string
+}
+
+// withOrphanedStates returns the states of the library videos to save, with
+// the saved states of the videos whose files are gone. Those are only removed
+// from the orphaned entries page, so that the progress is back when the file
+// is.
+func withOrphanedStates(videoFiles []VideoFile, path string) ([]VideoFile, error) {
+	viewedVideos, err := loadViewedVideos(path)
+	if err != nil {
+		return nil, err
+	}
+
+	states := slices.Clone(videoFiles)
+	for _, video := range videoFiles {
+		delete(viewedVideos, video.File)
+	}
+
+	return append(states, sortedVideoStates(viewedVideos)...), nil
+}
+
+// hasWatchState reports whether the video was started, the state file
+// holding an empty entry for every video in the "file" storage mode.
+func hasWatchState(video VideoFile) bool {
+	return video.Viewed || video.Progress > 0 || !video.Current.IsZero()
+}
+
+// findOrphanedEntries returns the saved states without a file in the
+// library, the ones not kept first.
+func findOrphanedEntries(path string, videoFiles []VideoFile) ([]OrphanedEntry, error) {
+	viewedVideos, err := loadViewedVideos(path)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := loadSettings(path)
+	if err != nil {
+		log.Printf("Error loading settings: %v", err)
+	}
+
+	var unstarted []VideoFile
+	for _, video := range videoFiles {
+		if !hasWatchState(viewedVideos[video.File]) {
+			unstarted = append(unstarted, video)
+		}
+		delete(viewedVideos, video.File)
+	}
+
+	entries := []OrphanedEntry{}
+	for _, video := range sortedVideoStates(viewedVideos) {
+		entries = append(entries, OrphanedEntry{
+			Video:      video,
+			Kept:       slices.Contains(settings.KeptEntries, video.File),
+			Candidates: renameCandidates(video.Name, unstarted),
+		})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return !entries[i].Kept && entries[j].Kept
+	})
+
+	return entries, nil
+}
+
+// nameWords splits a file name into lowercase words, without its extension.
+func nameWords(name string) []string {
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+
+	return strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// renameCandidates returns the videos without a watch state sharing words
+// with the name of the entry, the renamed file being one of them, the most
+// similar first.
+func renameCandidates(name string, unstarted []VideoFile) []OrphanCandidate {
+	words := nameWords(name)
+
+	type scored struct {
+		video VideoFile
+		score int
+	}
+	var matches []scored
+	for _, video := range unstarted {
+		score := 0
+		for _, word := range nameWords(video.Name) {
+			if slices.Contains(words, word) {
+				score++
+			}
+		}
+		if score > 0 {
+			matches = append(matches, scored{video, score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].score > matches[j].score
+	})
+
+	var candidates []OrphanCandidate
+	for _, match := range matches[:min(len(matches), maxRenameCandidates)] {
+		candidates = append(candidates, OrphanCandidate{ID: match.video.ID, File: match.video.File})
+	}
+
+	return candidates
+}
+
+// updateStateEntries changes the saved watch state of the videos, with or
+// without a file.
+func updateStateEntries(path string, change func(viewedVideos map[string]VideoFile) error) error {
+	progressMu.Lock()
+	defer progressMu.Unlock()
+	stateEventsMu.Lock()
+	defer stateEventsMu.Unlock()
+
+	viewedVideos, err := loadViewedVideos(path)
+	if err != nil {
+		return err
+	}
+
+	if err := change(viewedVideos); err != nil {
+		return err
+	}
+
+	return saveViewedVideos(sortedVideoStates(viewedVideos), path)
+}
+
+// purgeOrphanedEntries removes the saved states of the files that are not in
+// the library, and returns how many were removed.
+func purgeOrphanedEntries(path string, videoFiles []VideoFile, files []string) (int, error) {
+	purged := 0
+	err := updateStateEntries(path, func(viewedVideos map[string]VideoFile) error {
+		for _, file := range files {
+			if _, ok := viewedVideos[file]; !ok || slices.ContainsFunc(videoFiles, func(video VideoFile) bool { return video.File == file }) {
+				continue
+			}
+			delete(viewedVideos, file)
+			purged++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if err := keepOrphanedEntries(path, files, false); err != nil {
+		log.Printf("Error saving settings: %v", err)
+	}
+
+	return purged, nil
+}
+
+// relinkOrphanedEntry moves the saved state of an orphaned entry to the video
+// its file was renamed to.
+func relinkOrphanedEntry(path string, videoFiles []VideoFile, file string, video VideoFile) error {
+	if slices.ContainsFunc(videoFiles, func(video VideoFile) bool { return video.File == file }) {
+		return errNotOrphaned
+	}
+
+	err := updateStateEntries(path, func(viewedVideos map[string]VideoFile) error {
+		state, ok := viewedVideos[file]
+		if !ok {
+			return errNotOrphaned
+		}
+		if hasWatchState(viewedVideos[video.File]) {
+			return errStateExists
+		}
+
+		delete(viewedVideos, file)
+		state.Name = video.Name
+		state.Path = video.Path
+		state.File = video.File
+		viewedVideos[video.File] = state
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return keepOrphanedEntries(path, []string{file}, false)
+}
+
+// keepOrphanedEntries marks the entries of the files as kept on purpose, or
+// not, so that the kept ones are listed apart.
+func keepOrphanedEntries(path string, files []string, keep bool) error {
+	settings, err := loadSettings(path)
+	if err != nil {
+		log.Printf("Error loading settings: %v", err)
+	}
+
+	changed := false
+	for _, file := range files {
+		i := slices.Index(settings.KeptEntries, file)
+		switch {
+		case keep && i < 0:
+			settings.KeptEntries = append(settings.KeptEntries, file)
+			changed = true
+		case !keep && i >= 0:
+			settings.KeptEntries = slices.Delete(settings.KeptEntries, i, i+1)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	return saveSettings(settings, path)
+}
+
+func createOrphansTemplate() *template.Template {
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Orphaned entries - {{.Title}}</title>
+    <style>
+        body {
+            font-family: Arial, sans-serif;
+            margin: 20px;
+        }
+        table {
+            border-collapse: collapse;
+        }
+        th, td {
+            border-bottom: 1px solid #ddd;
+            padding: 6px 10px;
+            text-align: left;
+            vertical-align: top;
+        }
+        form {
+            display: inline;
+        }
+        .kept {
+            color: #666;
+        }
+    </style>
+</head>
+<body>
+    <p><a href="/">Back to the library</a></p>
+    <h1>Orphaned entries</h1>
+    <p>The watch state of these videos is saved, but their files are not in the library anymore. Purge the entries of the deleted files, keep the ones of files that will come back, or re-link the renamed files.</p>
+    {{if .Entries}}
+    {{if .Purgeable}}
+    <form method="post" action="/orphans" onsubmit="return confirm('Purge the orphaned entries not kept ({{.Purgeable}})?')">
+        <input type="hidden" name="action" value="purge-all">
+        <button type="submit">Purge the entries not kept ({{.Purgeable}})</button>
+    </form>
+    {{end}}
+    <table>
+        <tr><th>Video</th><th>Progress</th><th>Last watched</th><th>Re-link to</th><th></th></tr>
+        {{range .Entries}}
+        <tr{{if .Kept}} class="kept"{{end}}>
+            <td>{{.Video.File}}{{if .Kept}} (kept){{end}}</td>
+            <td>{{if .Video.Viewed}}Watched{{else}}{{formatTimestamp .Video.Progress}}{{end}}</td>
+            <td>{{if not .Video.Current.IsZero}}{{.Video.Current.Format "2006-01-02 15:04"}}{{end}}</td>
+            <td>
+                {{if .Candidates}}
+                <form method="post" action="/orphans">
+                    <input type="hidden" name="action" value="relink">
+                    <input type="hidden" name="entry" value="{{.Video.File}}">
+                    <select name="video">
+                        {{range .Candidates}}<option value="{{.ID}}">{{.File}}</option>{{end}}
+                    </select>
+                    <button type="submit">Re-link</button>
+                </form>
+                {{else}}
+                No similar video without progress
+                {{end}}
+            </td>
+            <td>
+                <form method="post" action="/orphans">
+                    <input type="hidden" name="action" value="{{if .Kept}}unkeep{{else}}keep{{end}}">
+                    <input type="hidden" name="entry" value="{{.Video.File}}">
+                    <button type="submit">{{if .Kept}}Don't keep{{else}}Keep{{end}}</button>
+                </form>
+                <form method="post" action="/orphans">
+                    <input type="hidden" name="action" value="purge">
+                    <input type="hidden" name="entry" value="{{.Video.File}}">
+                    <button type="submit">Purge</button>
+                </form>
+            </td>
+        </tr>
+        {{end}}
+    </table>
+    {{else}}
+    <p>Every saved watch state has its file in the library.</p>
+    {{end}}
+</body>
+</html>`
+
+	funcs := template.FuncMap{
+		"formatTimestamp": formatTimestamp,
+	}
+
+	return template.Must(template.New("orphans").Funcs(funcs).Parse(tmpl))
+}
+
+// handleOrphans lists the orphaned entries of the watch state as a page, or
+// as JSON for API clients. A POST request purges (action=purge, or purge-all
+// for all the entries not kept), keeps (keep, unkeep) or re-links (relink,
+// with the video to re-link to) the entry.
+func handleOrphans(w http.ResponseWriter, r *http.Request, path string, videoFiles []VideoFile, tmpl *template.Template, rescan func()) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPost:
+		handleOrphansAction(w, r, path, videoFiles, rescan)
+		return
+	default:
+		methodNotAllowed(w, r, "GET, HEAD, POST")
+		return
+	}
+
+	entries, err := findOrphanedEntries(path, videoFiles)
+	if err != nil {
+		log.Printf("Error loading video progress: %v", err)
+		httpError(w, r, "Error loading video progress", http.StatusInternalServerError)
+		return
+	}
+
+	if acceptsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(entries)
+		return
+	}
+
+	purgeable := 0
+	for _, entry := range entries {
+		if !entry.Kept {
+			purgeable++
+		}
+	}
+
+	data := struct {
+		Title     string
+		Entries   []OrphanedEntry
+		Purgeable int
+	}{
+		Title:     pageTitle,
+		Entries:   entries,
+		Purgeable: purgeable,
+	}
+	tmpl.Execute(w, data)
+}
+
+func handleOrphansAction(w http.ResponseWriter, r *http.Request, path string, videoFiles []VideoFile, rescan func()) {
+	file := r.FormValue("entry")
+
+	switch action := r.FormValue("action"); action {
+	case "purge", "purge-all":
+		files := []string{file}
+		if action == "purge-all" {
+			entries, err := findOrphanedEntries(path, videoFiles)
+			if err != nil {
+				log.Printf("Error loading video progress: %v", err)
+				httpError(w, r, "Error loading video progress", http.StatusInternalServerError)
+				return
+			}
+			files = nil
+			for _, entry := range entries {
+				if !entry.Kept {
+					files = append(files, entry.Video.File)
+				}
+			}
+		}
+
+		purged, err := purgeOrphanedEntries(path, videoFiles, files)
+		if err != nil {
+			log.Printf("Error saving video progress: %v", err)
+			httpError(w, r, "Error saving video progress: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if action == "purge" && purged == 0 {
+			notFound(w, r)
+			return
+		}
+		if action == "purge" {
+			audit(r, path, auditPurge, file, "")
+		} else if purged > 0 {
+			audit(r, path, auditPurge, "", fmt.Sprintf("%d orphaned entries", purged))
+		}
+	case "keep", "unkeep":
+		if err := keepOrphanedEntries(path, []string{file}, action == "keep"); err != nil {
+			log.Printf("Error saving settings: %v", err)
+			httpError(w, r, "Error saving settings", http.StatusInternalServerError)
+			return
+		}
+	case "relink":
+		i := findVideoFile(videoFiles, r.FormValue("video"))
+		if i < 0 {
+			notFound(w, r)
+			return
+		}
+
+		err := relinkOrphanedEntry(path, videoFiles, file, videoFiles[i])
+		if errors.Is(err, errNotOrphaned) {
+			notFound(w, r)
+			return
+		}
+		if errors.Is(err, errStateExists) {
+			httpError(w, r, "The video already has a watch state", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Printf("Error saving video progress: %v", err)
+			httpError(w, r, "Error saving video progress: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		audit(r, path, auditRelink, videoFiles[i].Name, "from "+file)
+		rescan()
+	default:
+		httpError(w, r, "Invalid action", http.StatusBadRequest)
+		return
+	}
+
+	if acceptsJSON(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	http.Redirect(w, r, "/orphans", http.StatusSeeOther)
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WatchPlan spreads the unwatched videos of a folder ("" for the whole
+// library) over the days left until End (YYYY-MM-DD), or assigns PerDay
+// videos or Minutes of videos to each day.
+type WatchPlan struct {
+	Folder  string `json:",omitempty"`
+	End     string `json:",omitempty"`
+	PerDay  int    `json:",omitempty"`
+	Minutes int    `json:",omitempty"`
+}
+
+type PlanDay struct {
+	Date   time.Time
+	Videos []VideoFile
+}
+
+// buildPlanDays assigns the videos left to the days between today and the end
+// of the plan. The plan is rebuilt from the watch state every time, so the
+// videos not watched on their day are spread over the following days. The
+// videos completed today count toward today's assignment.
+func buildPlanDays(plan WatchPlan, videoFiles []VideoFile, path string, now time.Time) ([]PlanDay, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	var done, left []VideoFile
+	for _, video := range videoFiles {
+		if plan.Folder != "" && !strings.HasPrefix(videoFolder(video, path)+"/", plan.Folder+"/") {
+			continue
+		}
+
+		switch {
+		case !video.Viewed:
+			left = append(left, video)
+		case !video.Current.Before(today):
+			done = append(done, video)
+		}
+	}
+	videos := append(done, left...)
+
+	var chunks [][]VideoFile
+	switch {
+	case plan.Minutes > 0:
+		limit := float64(plan.Minutes * 60)
+		var chunk []VideoFile
+		runtime := 0.0
+		for _, video := range videos {
+			duration := probeDuration(video.Path)
+			if duration == 0 {
+				// Without ffprobe, a video fills a day.
+				duration = limit
+			}
+			if len(chunk) > 0 && runtime+duration > limit {
+				chunks = append(chunks, chunk)
+				chunk, runtime = nil, 0
+			}
+			chunk = append(chunk, video)
+			runtime += duration
+		}
+		if len(chunk) > 0 {
+			chunks = append(chunks, chunk)
+		}
+	case plan.PerDay > 0:
+		for from := 0; from < len(videos); from += plan.PerDay {
+			chunks = append(chunks, videos[from:min(from+plan.PerDay, len(videos))])
+		}
+	case plan.End != "":
+		end, err := time.ParseInLocation(time.DateOnly, plan.End, now.Location())
+		if err != nil {
+			return nil, fmt.Errorf("invalid plan end date: %v", err)
+		}
+
+		// The days are counted in UTC, a local day lasting 23 or 25 hours
+		// when the clocks change.
+		first := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)
+		last := time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, time.UTC)
+		days := max(int(last.Sub(first).Hours()/24)+1, 1)
+		for i := 0; i < days; i++ {
+			chunks = append(chunks, videos[i*len(videos)/days:(i+1)*len(videos)/days])
+		}
+	default:
+		return nil, fmt.Errorf("the plan needs an end date, a number of videos or minutes per day")
+	}
+
+	var planDays []PlanDay
+	for i, chunk := range chunks {
+		if len(chunk) > 0 {
+			planDays = append(planDays, PlanDay{Date: today.AddDate(0, 0, i), Videos: chunk})
+		}
+	}
+
+	return planDays, nil
+}
+
+// todayPlan returns the videos assigned to today, the ones already watched
+// included.
+func todayPlan(settings Settings, videoFiles []VideoFile, path string) []VideoFile {
+	if settings.Plan == nil {
+		return nil
+	}
+
+	now := time.Now()
+	planDays, err := buildPlanDays(*settings.Plan, videoFiles, path, now)
+	if err != nil {
+		log.Printf("Error building the watch plan: %v", err)
+		return nil
+	}
+
+	if len(planDays) == 0 || planDays[0].Date.Format(time.DateOnly) != now.Format(time.DateOnly) {
+		return nil
+	}
+
+	return planDays[0].Videos
+}
+
+func handlePlan(w http.ResponseWriter, r *http.Request, path string) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+
+	settings, err := loadSettings(path)
+	if err != nil {
+		log.Printf("Error loading settings: %v", err)
+	}
+
+	if r.FormValue("delete") != "" {
+		settings.Plan = nil
+	} else {
+		plan := WatchPlan{
+			Folder: strings.Trim(r.FormValue("folder"), "/"),
+			End:    r.FormValue("end"),
+		}
+		plan.PerDay, _ = strconv.Atoi(r.FormValue("per_day"))
+		plan.Minutes, _ = strconv.Atoi(r.FormValue("minutes"))
+
+		if _, err := buildPlanDays(plan, nil, path, time.Now()); err != nil {
+			httpError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		settings.Plan = &plan
+	}
+
+	if err := saveSettings(settings, path); err != nil {
+		log.Printf("Error saving settings: %v", err)
+		httpError(w, r, "Error saving settings", http.StatusInternalServerError)
+		return
+	}
+	audit(r, path, auditPlan, "", r.PostForm.Encode())
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func handlePlanCalendar(w http.ResponseWriter, r *http.Request, videoFiles []VideoFile, path string) {
+	settings, err := loadSettings(path)
+	if err != nil {
+		log.Printf("Error loading settings: %v", err)
+	}
+	if settings.Plan == nil {
+		notFound(w, r)
+		return
+	}
+
+	planDays, err := buildPlanDays(*settings.Plan, videoFiles, path, time.Now())
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Write([]byte(planCalendar(planDays, *settings.Plan, path)))
+}
+
+// planCalendar renders the plan as an iCalendar feed with an all-day event
+// per day.
+func planCalendar(planDays []PlanDay, plan WatchPlan, path string) string {
+	var b strings.Builder
+	line := func(content string) {
+		// Lines longer than 75 octets are folded.
+		for len(content) > 75 {
+			cut := 75
+			for cut > 0 && content[cut]&0xC0 == 0x80 {
+				cut--
+			}
+			b.WriteString(content[:cut] + "\r\n")
+			content = " " + content[cut:]
+		}
+		b.WriteString(content + "\r\n")
+	}
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//videos-viewer//watch plan//EN")
+	line("X-WR-CALNAME:" + icsEscape(pageTitle+" watch plan"))
+
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	for _, day := range planDays {
+		names := make([]string, len(day.Videos))
+		for i, video := range day.Videos {
+			names[i] = video.DisplayName()
+		}
+
+		uid := sha256.Sum256([]byte(libraryID(path) + plan.Folder + day.Date.Format(time.DateOnly)))
+		summary := "Watch " + names[0]
+		if len(names) > 1 {
+			summary += fmt.Sprintf(" and %d more", len(names)-1)
+		}
+
+		line("BEGIN:VEVENT")
+		line("UID:" + hex.EncodeToString(uid[:8]) + "@videos-viewer")
+		line("DTSTAMP:" + stamp)
+		line("DTSTART;VALUE=DATE:" + day.Date.Format("20060102"))
+		line("DTEND;VALUE=DATE:" + day.Date.AddDate(0, 0, 1).Format("20060102"))
+		line("SUMMARY:" + icsEscape(summary))
+		line("DESCRIPTION:" + icsEscape(strings.Join(names, "\n")))
+		line("END:VEVENT")
+	}
+
+	line("END:VCALENDAR")
+
+	return b.String()
+}
+
+func icsEscape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(value)
+}
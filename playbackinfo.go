@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// handlePlaybackInfo returns the format of a video for the playback info
+// panel of the player, with the reason browsers cannot play it, if any.
+func handlePlaybackInfo(w http.ResponseWriter, r *http.Request, videoFiles []VideoFile) {
+	i := findVideoFile(videoFiles, strings.TrimPrefix(r.URL.Path, "/playback-info/"))
+	if i < 0 {
+		notFound(w, r)
+		return
+	}
+
+	data := struct {
+		Name      string
+		MediaInfo *MediaInfo `json:",omitempty"`
+		Issue     string     `json:",omitempty"`
+		Error     string     `json:",omitempty"`
+	}{
+		Name:  videoFiles[i].Name,
+		Issue: unsupportedFormat(videoFiles[i]),
+	}
+
+	if info, err := probeMediaInfo(videoFiles[i].Path); err != nil {
+		debug("Error probing \"%s\": %v", videoFiles[i].Name, err)
+		data.Error = err.Error()
+	} else {
+		data.MediaInfo = &info
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
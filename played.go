@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	playedFile = "video_played.json"
+
+	// Played ranges closer than playedRangeGap seconds are merged, so that
+	// the pauses and the quality changes do not split them.
+	playedRangeGap = 1.0
+	// Ranges shorter than minPlayedRange seconds, such as the one the
+	// browser creates when seeking, are not playback.
+	minPlayedRange  = 0.5
+	maxPlayedRanges = 1000
+)
+
+// PlayedRange is a part of a video that has been played, in seconds, encoded
+// as [start, end].
+type PlayedRange [2]float64
+
+var playedMu sync.Mutex
+
+func loadPlayed(path string) map[string][]PlayedRange {
+	played := make(map[string][]PlayedRange)
+
+	jsonData, err := os.ReadFile(filepath.Join(path, playedFile))
+	if err != nil {
+		return played
+	}
+
+	if err := json.Unmarshal(jsonData, &played); err != nil {
+		log.Printf("Error loading played ranges: %v", err)
+	}
+
+	return played
+}
+
+func savePlayed(path string, played map[string][]PlayedRange) error {
+	jsonData, err := json.Marshal(played)
+	if err != nil {
+		return err
+	}
+
+	prettyJSON := &bytes.Buffer{}
+	if err := json.Indent(prettyJSON, jsonData, "", "    "); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(path, playedFile), prettyJSON.Bytes(), 0644)
+}
+
+// mergePlayedRanges returns the union of the ranges, sorted.
+func mergePlayedRanges(ranges []PlayedRange) []PlayedRange {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+
+	var merged []PlayedRange
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && r[0] <= merged[n-1][1]+playedRangeGap {
+			merged[n-1][1] = max(merged[n-1][1], r[1])
+			continue
+		}
+		merged = append(merged, r)
+	}
+
+	return merged
+}
+
+// playedRanges returns the played ranges of a video.
+func playedRanges(path string, name string) []PlayedRange {
+	playedMu.Lock()
+	defer playedMu.Unlock()
+
+	return loadPlayed(path)[name]
+}
+
+// handlePlayed returns the ranges of a video that have been played, or adds
+// the ranges sent by the player (a JSON list of [start, end] pairs). POST is
+// accepted for navigator.sendBeacon.
+func handlePlayed(w http.ResponseWriter, r *http.Request, videoFiles []VideoFile, path string) {
+	i := findVideoFile(videoFiles, strings.TrimPrefix(r.URL.Path, "/api/played/"))
+	if i < 0 {
+		notFound(w, r)
+		return
+	}
+	name := videoFiles[i].Name
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		writePlayed(w, playedRanges(path, name))
+	case http.MethodPatch, http.MethodPost:
+		var ranges []PlayedRange
+		if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&ranges); err != nil {
+			httpError(w, r, "Invalid played ranges", http.StatusBadRequest)
+			return
+		}
+
+		var valid []PlayedRange
+		for _, played := range ranges {
+			if played[0] >= 0 && played[1]-played[0] >= minPlayedRange {
+				valid = append(valid, played)
+			}
+		}
+
+		playedMu.Lock()
+		defer playedMu.Unlock()
+
+		played := loadPlayed(path)
+		merged := mergePlayedRanges(append(played[name], valid...))
+		if len(merged) > maxPlayedRanges {
+			merged = merged[:maxPlayedRanges]
+		}
+		played[name] = merged
+
+		if err := savePlayed(path, played); err != nil {
+			log.Printf("Error saving played ranges: %v", err)
+			httpError(w, r, "Error saving played ranges", http.StatusInternalServerError)
+			return
+		}
+
+		writePlayed(w, merged)
+	default:
+		methodNotAllowed(w, r, "GET, HEAD, PATCH, POST")
+	}
+}
+
+func writePlayed(w http.ResponseWriter, ranges []PlayedRange) {
+	if ranges == nil {
+		ranges = []PlayedRange{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(ranges)
+}
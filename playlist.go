@@ -0,0 +1,392 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var playlistConditionPattern = regexp.MustCompile(`^([a-z]+)(<=|>=|!=|=|<|>|~)(.+)$`)
+
+type SmartPlaylist struct {
+	Name string
+	Rule string
+}
+
+type playlistRule func(video VideoFile) bool
+
+// parsePlaylistRule compiles a smart playlist rule such as
+// `unwatched AND (show~lecture OR duration<30m)`. Conditions are combined
+// with AND, OR and NOT, adjacent conditions being implicitly joined by AND.
+//
+// Supported conditions are watched, unwatched, started, name, folder and show
+// (=, != and ~ for "contains"), season and episode (numeric comparisons),
+// duration (e.g. duration<30m) and added, the age of the file
+// (e.g. added<7d for files added during the last 7 days).
+func parsePlaylistRule(rule string, path string) (playlistRule, error) {
+	p := &playlistParser{tokens: tokenizePlaylistRule(rule), path: path}
+	if len(p.tokens) == 0 {
+		return nil, fmt.Errorf("empty rule")
+	}
+
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+
+	return expr, nil
+}
+
+func tokenizePlaylistRule(rule string) []string {
+	var tokens []string
+	var current strings.Builder
+	quoted := false
+
+	flush := func() {
+		if current.Len() > 0 {
+			tokens = append(tokens, current.String())
+			current.Reset()
+		}
+	}
+
+	for _, r := range rule {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case quoted:
+			current.WriteRune(r)
+		case r == '(' || r == ')':
+			flush()
+			tokens = append(tokens, string(r))
+		case unicode.IsSpace(r):
+			flush()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush()
+
+	return tokens
+}
+
+type playlistParser struct {
+	tokens []string
+	pos    int
+	path   string
+}
+
+func (p *playlistParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *playlistParser) parseOr() (playlistRule, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for strings.EqualFold(p.peek(), "OR") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		l := left
+		left = func(video VideoFile) bool { return l(video) || right(video) }
+	}
+
+	return left, nil
+}
+
+func (p *playlistParser) parseAnd() (playlistRule, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		next := p.peek()
+		if next == "" || next == ")" || strings.EqualFold(next, "OR") {
+			return left, nil
+		}
+		if strings.EqualFold(next, "AND") {
+			p.pos++
+		}
+
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+
+		l := left
+		left = func(video VideoFile) bool { return l(video) && right(video) }
+	}
+}
+
+func (p *playlistParser) parseNot() (playlistRule, error) {
+	token := p.peek()
+	switch {
+	case token == "":
+		return nil, fmt.Errorf("unexpected end of rule")
+	case strings.EqualFold(token, "NOT"):
+		p.pos++
+		expr, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(video VideoFile) bool { return !expr(video) }, nil
+	case token == "(":
+		p.pos++
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		p.pos++
+		return expr, nil
+	default:
+		p.pos++
+		return p.parseCondition(token)
+	}
+}
+
+func (p *playlistParser) parseCondition(token string) (playlistRule, error) {
+	switch strings.ToLower(token) {
+	case "watched", "viewed":
+		return func(video VideoFile) bool { return video.Viewed }, nil
+	case "unwatched", "unviewed":
+		return func(video VideoFile) bool { return !video.Viewed }, nil
+	case "started":
+		return func(video VideoFile) bool { return !video.Viewed && video.Progress > 0 }, nil
+	}
+
+	match := playlistConditionPattern.FindStringSubmatch(token)
+	if match == nil {
+		return nil, fmt.Errorf("invalid condition %q", token)
+	}
+
+	field, op, value := match[1], match[2], match[3]
+	switch field {
+	case "name":
+		return compareText(op, value, func(video VideoFile) string { return video.Name })
+	case "folder":
+		return compareText(op, value, func(video VideoFile) string { return videoFolder(video, p.path) })
+	case "show":
+		return compareText(op, value, func(video VideoFile) string { return video.Show })
+	case "season":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid season %q", value)
+		}
+		return compareNumber(op, float64(n), func(video VideoFile) float64 { return float64(video.Season) })
+	case "episode":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid episode %q", value)
+		}
+		return compareNumber(op, float64(n), func(video VideoFile) float64 { return float64(video.Episode) })
+	case "duration":
+		d, err := parseRuleDuration(value)
+		if err != nil {
+			return nil, err
+		}
+		return compareNumber(op, d.Seconds(), func(video VideoFile) float64 { return probeDuration(video.Path) })
+	case "added":
+		d, err := parseRuleDuration(value)
+		if err != nil {
+			return nil, err
+		}
+		return compareNumber(op, d.Seconds(), func(video VideoFile) float64 { return time.Since(video.Added).Seconds() })
+	}
+
+	return nil, fmt.Errorf("unknown field %q", field)
+}
+
+// parseRuleDuration parses a Go duration, also accepting days ("7d").
+func parseRuleDuration(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+
+	return d, nil
+}
+
+func compareText(op string, value string, get func(VideoFile) string) (playlistRule, error) {
+	value = strings.ToLower(value)
+
+	switch op {
+	case "=":
+		return func(video VideoFile) bool { return strings.ToLower(get(video)) == value }, nil
+	case "!=":
+		return func(video VideoFile) bool { return strings.ToLower(get(video)) != value }, nil
+	case "~":
+		return func(video VideoFile) bool { return strings.Contains(strings.ToLower(get(video)), value) }, nil
+	}
+
+	return nil, fmt.Errorf("operator %q is not supported on text", op)
+}
+
+func compareNumber(op string, value float64, get func(VideoFile) float64) (playlistRule, error) {
+	switch op {
+	case "=":
+		return func(video VideoFile) bool { return get(video) == value }, nil
+	case "!=":
+		return func(video VideoFile) bool { return get(video) != value }, nil
+	case "<":
+		return func(video VideoFile) bool { return get(video) < value }, nil
+	case "<=":
+		return func(video VideoFile) bool { return get(video) <= value }, nil
+	case ">":
+		return func(video VideoFile) bool { return get(video) > value }, nil
+	case ">=":
+		return func(video VideoFile) bool { return get(video) >= value }, nil
+	}
+
+	return nil, fmt.Errorf("operator %q is not supported on numbers", op)
+}
+
+func findPlaylist(settings Settings, name string) *SmartPlaylist {
+	for i := range settings.Playlists {
+		if settings.Playlists[i].Name == name {
+			return &settings.Playlists[i]
+		}
+	}
+
+	return nil
+}
+
+func filterVideos(videoFiles []VideoFile, rule playlistRule) []VideoFile {
+	var videos []VideoFile
+	for _, video := range videoFiles {
+		if rule(video) {
+			videos = append(videos, video)
+		}
+	}
+
+	return videos
+}
+
+func handlePlaylists(w http.ResponseWriter, r *http.Request, path string) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+
+	settings, err := loadSettings(path)
+	if err != nil {
+		log.Printf("Error loading settings: %v", err)
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		httpError(w, r, "Missing playlist name", http.StatusBadRequest)
+		return
+	}
+
+	var playlists []SmartPlaylist
+	for _, playlist := range settings.Playlists {
+		if playlist.Name != name {
+			playlists = append(playlists, playlist)
+		}
+	}
+
+	if r.FormValue("delete") == "" {
+		rule := strings.TrimSpace(r.FormValue("rule"))
+		if _, err := parsePlaylistRule(rule, path); err != nil {
+			httpError(w, r, "Invalid rule: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		playlists = append(playlists, SmartPlaylist{Name: name, Rule: rule})
+	}
+
+	settings.Playlists = playlists
+	if err := saveSettings(settings, path); err != nil {
+		log.Printf("Error saving settings: %v", err)
+		httpError(w, r, "Error saving settings", http.StatusInternalServerError)
+		return
+	}
+	audit(r, path, auditPlaylists, "", r.PostForm.Encode())
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func handlePlaylist(w http.ResponseWriter, r *http.Request, videoFiles []VideoFile, folderName string, tmpl *template.Template, path string) {
+	name := strings.TrimPrefix(r.URL.Path, "/playlist/")
+
+	settings, err := loadSettings(path)
+	if err != nil {
+		log.Printf("Error loading settings: %v", err)
+	}
+
+	playlist := findPlaylist(settings, name)
+	if playlist == nil {
+		notFound(w, r)
+		return
+	}
+
+	rule, err := parsePlaylistRule(playlist.Rule, path)
+	if err != nil {
+		httpError(w, r, "Invalid rule: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	videos := filterVideos(videoFiles, rule)
+
+	if r.URL.Query().Get("format") == "m3u" {
+		writeM3U(w, r, playlist.Name, videos)
+		return
+	}
+
+	data := newTemplateData(videoFiles, folderName, settings)
+	data.Playlist = playlist
+	data.LibraryVideos = videos
+	data.Queue = queuedVideos(path, videoFiles)
+
+	tmpl.Execute(w, data)
+}
+
+func writeM3U(w http.ResponseWriter, r *http.Request, name string, videos []VideoFile) {
+	base := baseURL(r)
+
+	w.Header().Set("Content-Type", "audio/x-mpegurl; charset=utf-8")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + ".m3u"}))
+
+	fmt.Fprintln(w, "#EXTM3U")
+	for _, video := range videos {
+		duration := -1
+		if d := probeDuration(video.Path); d > 0 {
+			duration = int(math.Round(d))
+		}
+
+		fmt.Fprintf(w, "#EXTINF:%d,%s\n", duration, video.DisplayName())
+		fmt.Fprintf(w, "%s/video/%s\n", base, video.ID)
+	}
+}
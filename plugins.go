@@ -0,0 +1,129 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// The viewer can be extended without changing its code by adding a Go file
+// to the package that registers the plugins from an init function:
+//
+//	func init() {
+//		registerMetadataProvider("lms", lmsProvider{url: os.Getenv("LMS_URL")})
+//	}
+//
+// The registrations must happen before main runs, they are not synchronized.
+
+// Scanner finds videos the library walk does not, such as files without a
+// video extension or stored outside the library folder.
+type Scanner interface {
+	// Scan returns the paths of the videos of the library at root. The files
+	// already found by the walk are ignored.
+	Scan(root string) ([]string, error)
+}
+
+// MetadataProvider supplies titles, descriptions, artwork and extra fields
+// of videos. It is called for every video displayed, so slow lookups must
+// be cached. The metadata stored next to the videos takes precedence over
+// the providers, which take precedence over TMDB.
+type MetadataProvider interface {
+	// Metadata returns the metadata of a video, or nil when it has none.
+	// Image is the path of an artwork file and Fields are named in
+	// lowercase.
+	Metadata(video VideoFile) (*Metadata, error)
+}
+
+// Notifier receives the events of the library (see Event), in the
+// background.
+type Notifier interface {
+	Notify(event Event) error
+}
+
+type namedScanner struct {
+	name    string
+	scanner Scanner
+}
+
+type namedMetadataProvider struct {
+	name     string
+	provider MetadataProvider
+}
+
+var (
+	scanners          []namedScanner
+	metadataProviders []namedMetadataProvider
+)
+
+func registerScanner(name string, scanner Scanner) {
+	scanners = append(scanners, namedScanner{name, scanner})
+}
+
+func registerMetadataProvider(name string, provider MetadataProvider) {
+	metadataProviders = append(metadataProviders, namedMetadataProvider{name, provider})
+}
+
+func registerNotifier(name string, notifier Notifier) {
+	subscribe(func(event Event) {
+		if err := notifier.Notify(event); err != nil {
+			log.Printf("Error sending %s notification: %v", name, err)
+		}
+	})
+}
+
+// scanPlugins returns the videos found by the scanners that are not part of
+// videoFiles. A failing scanner is skipped.
+func scanPlugins(root string, videoFiles []VideoFile, viewedVideos map[string]VideoFile) []VideoFile {
+	known := make(map[string]bool)
+	for _, video := range videoFiles {
+		known[video.Path] = true
+	}
+
+	var found []VideoFile
+	for _, scanner := range scanners {
+		paths, err := scanner.scanner.Scan(root)
+		if err != nil {
+			log.Printf("Error running the %s scanner: %v", scanner.name, err)
+			continue
+		}
+
+		for _, path := range paths {
+			path = filepath.Clean(path)
+			if known[path] {
+				continue
+			}
+
+			info, err := os.Stat(path)
+			if err != nil || info.IsDir() {
+				debug("Ignore \"%s\" found by the %s scanner: not a file", path, scanner.name)
+				continue
+			}
+
+			video, err := newVideoFile(root, path, info, viewedVideos)
+			if err != nil {
+				debug("Ignore \"%s\" found by the %s scanner: %v", path, scanner.name, err)
+				continue
+			}
+			known[path] = true
+			found = append(found, video)
+		}
+	}
+
+	return found
+}
+
+// providedMetadata merges the metadata of the providers, in the order they
+// were registered.
+func providedMetadata(video VideoFile) *Metadata {
+	var metadata *Metadata
+	for _, provider := range metadataProviders {
+		provided, err := provider.provider.Metadata(video)
+		if err != nil {
+			debug("Error getting metadata of \"%s\" from %s: %v", video.Name, provider.name, err)
+			continue
+		}
+		metadata = mergeMetadata(metadata, provided)
+	}
+
+	return metadata
+}
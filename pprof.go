@@ -0,0 +1,27 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/http/pprof"
+)
+
+var pprofAddr string
+
+// startPprof serves the profiling endpoints on their own address, so that
+// they are never exposed with the viewer.
+func startPprof() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	go func() {
+		log.Printf("Serving pprof at http://%s/debug/pprof/", pprofAddr)
+		if err := http.ListenAndServe(pprofAddr, mux); err != nil {
+			log.Printf("Error serving pprof: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// prefetchSize is the amount read at both ends of a video, where players look
+// for the container metadata (the MP4 moov atom can be at the end).
+const prefetchSize = 2 << 20
+
+// handlePrefetch warms up the next video before it is played: its first and
+// last bytes are read into the OS page cache, and its duration is probed.
+func handlePrefetch(w http.ResponseWriter, r *http.Request, videoFiles []VideoFile) {
+	i := findVideoFile(videoFiles, strings.TrimPrefix(r.URL.Path, "/prefetch/"))
+	if i < 0 {
+		notFound(w, r)
+		return
+	}
+
+	file, err := os.Open(videoFiles[i].Path)
+	if err != nil {
+		notFound(w, r)
+		return
+	}
+	defer file.Close()
+
+	io.CopyN(io.Discard, file, prefetchSize)
+	if info, err := file.Stat(); err == nil && info.Size() > 2*prefetchSize {
+		if _, err := file.Seek(-prefetchSize, io.SeekEnd); err == nil {
+			io.Copy(io.Discard, file)
+		}
+	}
+
+	probeDuration(videoFiles[i].Path)
+
+	w.WriteHeader(http.StatusNoContent)
+}
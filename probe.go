@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	durationCache   = make(map[string]float64)
+	durationCacheMu sync.Mutex
+
+	tagsCache   = make(map[string]map[string]string)
+	tagsCacheMu sync.Mutex
+
+	codecsCache   = make(map[string]videoCodecs)
+	codecsCacheMu sync.Mutex
+)
+
+// videoCodecs are the codecs of a file, probed at the given size and
+// modification time.
+type videoCodecs struct {
+	size    int64
+	modTime time.Time
+	video   string
+	audio   string
+}
+
+// probeDuration returns the duration of a video in seconds, or 0 when it
+// cannot be determined.
+func probeDuration(videoPath string) float64 {
+	if ffprobePath == "" {
+		return 0
+	}
+
+	durationCacheMu.Lock()
+	duration, ok := durationCache[videoPath]
+	durationCacheMu.Unlock()
+	if ok {
+		return duration
+	}
+
+	output, err := exec.Command(ffprobePath, "-v", "error", "-print_format", "json", "-show_entries", "format=duration", videoPath).Output()
+	if err != nil {
+		debug("Error probing duration of \"%s\": %v", videoPath, err)
+		return 0
+	}
+
+	var result struct {
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		debug("Error probing duration of \"%s\": %v", videoPath, err)
+		return 0
+	}
+
+	duration, _ = strconv.ParseFloat(result.Format.Duration, 64)
+
+	durationCacheMu.Lock()
+	durationCache[videoPath] = duration
+	durationCacheMu.Unlock()
+
+	return duration
+}
+
+// probeTags returns the tags of the container of a video (title, artist,
+// comment...) by lowercase name. Unless probe is set, only the tags already
+// read are returned, so that listing the library does not run ffprobe.
+func probeTags(videoPath string, probe bool) map[string]string {
+	tagsCacheMu.Lock()
+	tags, ok := tagsCache[videoPath]
+	tagsCacheMu.Unlock()
+	if ok || !probe || ffprobePath == "" {
+		return tags
+	}
+
+	output, err := exec.Command(ffprobePath, "-v", "error", "-print_format", "json", "-show_entries", "format_tags", videoPath).Output()
+	if err != nil {
+		debug("Error probing tags of \"%s\": %v", videoPath, err)
+		return nil
+	}
+
+	var result struct {
+		Format struct {
+			Tags map[string]string `json:"tags"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		debug("Error probing tags of \"%s\": %v", videoPath, err)
+		return nil
+	}
+
+	tags = make(map[string]string)
+	for name, value := range result.Format.Tags {
+		if value = strings.TrimSpace(value); value != "" {
+			tags[strings.ToLower(name)] = value
+		}
+	}
+
+	tagsCacheMu.Lock()
+	tagsCache[videoPath] = tags
+	tagsCacheMu.Unlock()
+
+	return tags
+}
+
+// probeCodecs returns the codec names of the first video and audio streams.
+// They are probed again only once the file changed.
+func probeCodecs(videoPath string) (string, string, error) {
+	if ffprobePath == "" {
+		return "", "", errors.New("ffprobe is not available")
+	}
+
+	info, err := os.Stat(videoPath)
+	if err != nil {
+		return "", "", err
+	}
+
+	codecsCacheMu.Lock()
+	codecs, ok := codecsCache[videoPath]
+	codecsCacheMu.Unlock()
+	if ok && codecs.size == info.Size() && codecs.modTime.Equal(info.ModTime()) {
+		return codecs.video, codecs.audio, nil
+	}
+
+	output, err := exec.Command(ffprobePath, "-v", "error", "-print_format", "json", "-show_entries", "stream=codec_type,codec_name", videoPath).Output()
+	if err != nil {
+		return "", "", err
+	}
+
+	var result struct {
+		Streams []struct {
+			CodecType string `json:"codec_type"`
+			CodecName string `json:"codec_name"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return "", "", err
+	}
+
+	var videoCodec, audioCodec string
+	for _, stream := range result.Streams {
+		switch {
+		case stream.CodecType == "video" && videoCodec == "":
+			videoCodec = stream.CodecName
+		case stream.CodecType == "audio" && audioCodec == "":
+			audioCodec = stream.CodecName
+		}
+	}
+
+	codecsCacheMu.Lock()
+	codecsCache[videoPath] = videoCodecs{info.Size(), info.ModTime(), videoCodec, audioCodec}
+	codecsCacheMu.Unlock()
+
+	return videoCodec, audioCodec, nil
+}
+
+// imageSubtitleCodecs are the bitmap subtitle formats, which browsers cannot
+// display and have to be burned into the video.
+var imageSubtitleCodecs = map[string]bool{
+	"hdmv_pgs_subtitle": true,
+	"dvd_subtitle":      true,
+	"dvb_subtitle":      true,
+	"xsub":              true,
+}
+
+// ImageSubtitle is a bitmap subtitle stream of a video. Index is its position
+// among the subtitle streams of the file, as in ffmpeg's "0:s:<index>".
+type ImageSubtitle struct {
+	Index int
+	Label string
+}
+
+var (
+	imageSubtitleCache   = make(map[string][]ImageSubtitle)
+	imageSubtitleCacheMu sync.Mutex
+)
+
+// probeImageSubtitles returns the bitmap subtitle streams of a video.
+func probeImageSubtitles(videoPath string) []ImageSubtitle {
+	if ffprobePath == "" {
+		return nil
+	}
+
+	imageSubtitleCacheMu.Lock()
+	subtitles, ok := imageSubtitleCache[videoPath]
+	imageSubtitleCacheMu.Unlock()
+	if ok {
+		return subtitles
+	}
+
+	output, err := exec.Command(ffprobePath, "-v", "error", "-print_format", "json", "-select_streams", "s", "-show_entries", "stream=codec_name:stream_tags=language,title", videoPath).Output()
+	if err != nil {
+		debug("Error probing subtitles of \"%s\": %v", videoPath, err)
+		return nil
+	}
+
+	var result struct {
+		Streams []struct {
+			CodecName string `json:"codec_name"`
+			Tags      struct {
+				Language string `json:"language"`
+				Title    string `json:"title"`
+			} `json:"tags"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		debug("Error probing subtitles of \"%s\": %v", videoPath, err)
+		return nil
+	}
+
+	for i, stream := range result.Streams {
+		if !imageSubtitleCodecs[stream.CodecName] {
+			continue
+		}
+
+		label := stream.Tags.Title
+		if label == "" {
+			label = stream.Tags.Language
+		}
+		if label == "" {
+			label = "Track " + strconv.Itoa(i+1)
+		}
+		subtitles = append(subtitles, ImageSubtitle{Index: i, Label: label})
+	}
+
+	imageSubtitleCacheMu.Lock()
+	imageSubtitleCache[videoPath] = subtitles
+	imageSubtitleCacheMu.Unlock()
+
+	return subtitles
+}
+
+// MediaInfo describes the container and the streams of a video file.
+type MediaInfo struct {
+	Container  string
+	Duration   float64
+	Bitrate    int
+	VideoCodec string `json:",omitempty"`
+	Width      int    `json:",omitempty"`
+	Height     int    `json:",omitempty"`
+	FrameRate  string `json:",omitempty"`
+	AudioCodec string `json:",omitempty"`
+	Channels   int    `json:",omitempty"`
+}
+
+// probeMediaInfo returns the format of a video and of its first video and
+// audio streams.
+func probeMediaInfo(videoPath string) (MediaInfo, error) {
+	var info MediaInfo
+	if ffprobePath == "" {
+		return info, errors.New("ffprobe is not available")
+	}
+
+	output, err := exec.Command(ffprobePath, "-v", "error", "-print_format", "json",
+		"-show_entries", "format=format_name,duration,bit_rate:stream=codec_type,codec_name,width,height,avg_frame_rate,channels",
+		videoPath).Output()
+	if err != nil {
+		return info, err
+	}
+
+	var result struct {
+		Format struct {
+			FormatName string `json:"format_name"`
+			Duration   string `json:"duration"`
+			BitRate    string `json:"bit_rate"`
+		} `json:"format"`
+		Streams []struct {
+			CodecType    string `json:"codec_type"`
+			CodecName    string `json:"codec_name"`
+			Width        int    `json:"width"`
+			Height       int    `json:"height"`
+			AvgFrameRate string `json:"avg_frame_rate"`
+			Channels     int    `json:"channels"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return info, err
+	}
+
+	info.Container = result.Format.FormatName
+	info.Duration, _ = strconv.ParseFloat(result.Format.Duration, 64)
+	info.Bitrate, _ = strconv.Atoi(result.Format.BitRate)
+	for _, stream := range result.Streams {
+		switch {
+		case stream.CodecType == "video" && info.VideoCodec == "":
+			info.VideoCodec = stream.CodecName
+			info.Width = stream.Width
+			info.Height = stream.Height
+			info.FrameRate = stream.AvgFrameRate
+		case stream.CodecType == "audio" && info.AudioCodec == "":
+			info.AudioCodec = stream.CodecName
+			info.Channels = stream.Channels
+		}
+	}
+
+	return info, nil
+}
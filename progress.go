@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Progress is the watch state of a video exposed by the progress endpoint.
+type Progress struct {
+	Name          string
+	Viewed        bool
+	Current       time.Time
+	Progress      float64
+	SubtitleDelay int
+}
+
+// progressMu serializes the read-modify-write cycles on the state file so
+// that the revision check and the update happen atomically.
+var progressMu sync.Mutex
+
+func newProgress(video VideoFile) Progress {
+	return Progress{
+		Name:          video.Name,
+		Viewed:        video.Viewed,
+		Current:       video.Current,
+		Progress:      video.Progress,
+		SubtitleDelay: video.SubtitleDelay,
+	}
+}
+
+// Revision identifies a watch state, so it changes whenever any client
+// updates the video.
+func (p Progress) Revision() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%t|%v|%s|%d", p.Viewed, p.Progress, p.Current.UTC().Format(time.RFC3339Nano), p.SubtitleDelay)))
+
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+func writeProgress(w http.ResponseWriter, status int, progress Progress) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", progress.Revision())
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(progress)
+}
+
+func handleGetProgress(w http.ResponseWriter, r *http.Request, path string, name string) {
+	video, err := videoProgress(path, name)
+	if errors.Is(err, errVideoNotFound) {
+		notFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading video progress: %v", err)
+		httpError(w, r, "Error loading video progress", http.StatusInternalServerError)
+		return
+	}
+
+	writeProgress(w, http.StatusOK, newProgress(video))
+}
+
+// handlePatchProgress applies a partial update. When the request carries an
+// If-Match header, the update is rejected with 412 and the current state if
+// the video changed since the client read it.
+func handlePatchProgress(w http.ResponseWriter, r *http.Request, path string, name string) {
+	var update ProgressUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		httpError(w, r, "Invalid progress update", http.StatusBadRequest)
+		return
+	}
+	if message := update.invalid(); message != "" {
+		httpError(w, r, message, http.StatusBadRequest)
+		return
+	}
+
+	before, video, err := updateProgress(path, name, update, r.Header.Get("If-Match"))
+	switch {
+	case errors.Is(err, errVideoNotFound):
+		notFound(w, r)
+		return
+	case errors.Is(err, errRevisionMismatch):
+		writeProgress(w, http.StatusPreconditionFailed, before)
+		return
+	case err != nil:
+		log.Printf("Error saving video progress: %v", err)
+		httpError(w, r, "Error saving video progress: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if update.Progress != nil {
+		sessions.played(r, video.ID, *update.Progress)
+	}
+	auditProgressUpdate(r, path, before, video, update)
+
+	writeProgress(w, http.StatusOK, newProgress(video))
+}
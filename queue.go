@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+const queueFile = "video_queue.json"
+
+// Queue is the "Up next" list: the names of the videos played after the
+// current one, in order, before the next videos of the list.
+type Queue struct {
+	Videos []string
+}
+
+var queueMu sync.Mutex
+
+func loadQueue(path string) (Queue, error) {
+	var queue Queue
+
+	jsonData, err := os.ReadFile(filepath.Join(path, queueFile))
+	if err != nil {
+		return queue, nil
+	}
+
+	if err := json.Unmarshal(jsonData, &queue); err != nil {
+		return queue, err
+	}
+
+	return queue, nil
+}
+
+func saveQueue(path string, queue Queue) error {
+	jsonData, err := json.Marshal(queue)
+	if err != nil {
+		return err
+	}
+
+	prettyJSON := &bytes.Buffer{}
+	if err := json.Indent(prettyJSON, jsonData, "", "    "); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(path, queueFile), prettyJSON.Bytes(), 0644)
+}
+
+// queuedVideos returns the videos of the queue, skipping the ones no longer
+// in the library.
+func queuedVideos(path string, videoFiles []VideoFile) []VideoFile {
+	queue, err := loadQueue(path)
+	if err != nil {
+		log.Printf("Error loading queue: %v", err)
+	}
+
+	var videos []VideoFile
+	for _, name := range queue.Videos {
+		if i := findVideoFile(videoFiles, name); i >= 0 {
+			videos = append(videos, videoFiles[i])
+		}
+	}
+
+	return videos
+}
+
+// updateQueue applies an action of the queue page to a video: add (at the
+// end), next (at the start), remove, up (one position) or clear.
+func updateQueue(path string, action string, name string) (Queue, error) {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+
+	queue, err := loadQueue(path)
+	if err != nil {
+		return queue, err
+	}
+
+	i := slices.Index(queue.Videos, name)
+	switch action {
+	case "add":
+		if i < 0 {
+			queue.Videos = append(queue.Videos, name)
+		}
+	case "next":
+		if i >= 0 {
+			queue.Videos = slices.Delete(queue.Videos, i, i+1)
+		}
+		queue.Videos = slices.Insert(queue.Videos, 0, name)
+	case "remove":
+		if i >= 0 {
+			queue.Videos = slices.Delete(queue.Videos, i, i+1)
+		}
+	case "up":
+		if i > 0 {
+			queue.Videos[i-1], queue.Videos[i] = queue.Videos[i], queue.Videos[i-1]
+		}
+	case "clear":
+		queue.Videos = nil
+	}
+
+	return queue, saveQueue(path, queue)
+}
+
+// dequeue removes a video from the queue once watched.
+func dequeue(path string, name string) {
+	queue, err := loadQueue(path)
+	if err != nil || !slices.Contains(queue.Videos, name) {
+		return
+	}
+
+	if _, err := updateQueue(path, "remove", name); err != nil {
+		log.Printf("Error saving queue: %v", err)
+	}
+}
+
+// handleQueue returns the queue, or updates it with the action and the video
+// of the form.
+func handleQueue(w http.ResponseWriter, r *http.Request, videoFiles []VideoFile, path string) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPost:
+		action := r.FormValue("action")
+		switch action {
+		case "add", "next", "remove", "up", "clear":
+		default:
+			httpError(w, r, "Invalid queue action", http.StatusBadRequest)
+			return
+		}
+
+		name := ""
+		if action != "clear" {
+			i := findVideoFile(videoFiles, r.FormValue("video"))
+			if i < 0 {
+				notFound(w, r)
+				return
+			}
+			name = videoFiles[i].Name
+		}
+
+		if _, err := updateQueue(path, action, name); err != nil {
+			log.Printf("Error saving queue: %v", err)
+			httpError(w, r, "Error saving queue", http.StatusInternalServerError)
+			return
+		}
+
+		if !acceptsJSON(r) {
+			redirectToReferer(w, r)
+			return
+		}
+	default:
+		methodNotAllowed(w, r, "GET, HEAD, POST")
+		return
+	}
+
+	type queuedVideo struct {
+		ID   string
+		Name string
+	}
+	videos := []queuedVideo{}
+	for _, video := range queuedVideos(path, videoFiles) {
+		videos = append(videos, queuedVideo{video.ID, video.Name})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct{ Videos []queuedVideo }{videos})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// randomRule builds the filter of the random pick: unwatched videos, in the
+// folder (or its subfolders), having the tag among the values of their
+// extra fields (e.g. a genre), and shorter than the duration, when given.
+func randomRule(query url.Values, path string) (playlistRule, error) {
+	rules := []playlistRule{func(video VideoFile) bool { return !video.Viewed }}
+
+	if folder := strings.Trim(query.Get("folder"), "/"); folder != "" {
+		rules = append(rules, func(video VideoFile) bool {
+			in := videoFolder(video, path)
+			return in == folder || strings.HasPrefix(in, folder+"/")
+		})
+	}
+
+	if tag := strings.TrimSpace(query.Get("tag")); tag != "" {
+		rules = append(rules, func(video VideoFile) bool {
+			for _, value := range video.Fields() {
+				for _, part := range strings.Split(value, ",") {
+					if strings.EqualFold(strings.TrimSpace(part), tag) {
+						return true
+					}
+				}
+			}
+			return false
+		})
+	}
+
+	if value := query.Get("duration"); value != "" {
+		duration, err := parseRuleDuration(value)
+		if err != nil {
+			return nil, err
+		}
+		// The videos of unknown duration cannot be known to fit.
+		rules = append(rules, func(video VideoFile) bool {
+			seconds := probeDuration(video.Path)
+			return seconds > 0 && seconds <= duration.Seconds()
+		})
+	}
+
+	return func(video VideoFile) bool {
+		for _, rule := range rules {
+			if !rule(video) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// handleRandom picks a random unwatched video. Browsers are redirected to
+// it, API clients get it as JSON.
+func handleRandom(w http.ResponseWriter, r *http.Request, path string) {
+	rule, err := randomRule(r.URL.Query(), path)
+	if err != nil {
+		httpError(w, r, "Invalid duration", http.StatusBadRequest)
+		return
+	}
+
+	videoFiles, err := loadVideoFiles(path)
+	if err != nil {
+		log.Printf("Error loading video files: %v", err)
+		httpError(w, r, "Error loading video files", http.StatusInternalServerError)
+		return
+	}
+
+	candidates := filterVideos(videoFiles, rule)
+	if len(candidates) == 0 {
+		httpError(w, r, "No unwatched video matches", http.StatusNotFound)
+		return
+	}
+
+	video := candidates[rand.IntN(len(candidates))]
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		http.Redirect(w, r, "/watch/"+video.ID, http.StatusSeeOther)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(struct {
+		ID         string
+		Name       string
+		Folder     string
+		URL        string
+		Candidates int
+	}{
+		ID:         video.ID,
+		Name:       video.Name,
+		Folder:     videoFolder(video, path),
+		URL:        "/watch/" + video.ID,
+		Candidates: len(candidates),
+	})
+}
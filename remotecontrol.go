@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sync"
+)
+
+// remoteCommands are the commands a remote can send to the players.
+var remoteCommands = map[string]bool{
+	"play":   true,
+	"pause":  true,
+	"seek":   true,
+	"skip":   true,
+	"next":   true,
+	"volume": true,
+}
+
+// RemoteMessage is a message of the remote control channel: a command sent
+// by a remote to the players (with its Value, a position or a volume), or a
+// "status" sent by a player to the remotes, which also carries the playback
+// state.
+type RemoteMessage struct {
+	Command string
+	Value   float64
+}
+
+// remoteHub relays the commands of the remotes (the /remote page, typically
+// on a phone) to the players (the watch pages), and the playback status of
+// the players back to the remotes.
+type remoteHub struct {
+	mu      sync.Mutex
+	players map[*wsConn]bool
+	remotes map[*wsConn]bool
+	status  []byte
+}
+
+var remoteControl = &remoteHub{
+	players: make(map[*wsConn]bool),
+	remotes: make(map[*wsConn]bool),
+}
+
+func (h *remoteHub) join(conn *wsConn, player bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if player {
+		h.players[conn] = true
+		return
+	}
+
+	h.remotes[conn] = true
+	if h.status != nil {
+		conn.WriteMessage(h.status)
+	}
+}
+
+func (h *remoteHub) leave(conn *wsConn, player bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !player {
+		delete(h.remotes, conn)
+		return
+	}
+
+	delete(h.players, conn)
+	if len(h.players) == 0 {
+		h.status = nil
+		h.broadcast(h.remotes, []byte(`{"Command":"disconnected"}`))
+	}
+}
+
+// relay forwards a message of a player to the remotes, or of a remote to the
+// players.
+func (h *remoteHub) relay(message []byte, player bool) {
+	var msg RemoteMessage
+	if err := json.Unmarshal(message, &msg); err != nil {
+		debug("Invalid remote control message: %v", err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if player {
+		if msg.Command != "status" {
+			return
+		}
+		h.status = message
+		h.broadcast(h.remotes, message)
+		return
+	}
+
+	if remoteCommands[msg.Command] {
+		h.broadcast(h.players, message)
+	}
+}
+
+func (h *remoteHub) broadcast(conns map[*wsConn]bool, message []byte) {
+	for conn := range conns {
+		if err := conn.WriteMessage(message); err != nil {
+			debug("Error sending remote control message: %v", err)
+		}
+	}
+}
+
+// handleRemoteSocket is the WebSocket channel of the remote control, joined
+// by the watch pages with role=player and by the remote page otherwise.
+func handleRemoteSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		debug("Error opening remote control channel: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	player := r.URL.Query().Get("role") == "player"
+	remoteControl.join(conn, player)
+	defer remoteControl.leave(conn, player)
+
+	for {
+		message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		remoteControl.relay(message, player)
+	}
+}
+
+func createRemoteTemplate() *template.Template {
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Remote - {{.Title}}</title>
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <style>
+        body {
+            font-family: Arial, sans-serif;
+            margin: 20px;
+            text-align: center;
+        }
+        .controls button {
+            font-size: 1.5em;
+            min-width: 3em;
+            margin: 6px;
+            padding: 10px;
+        }
+        input[type="range"] {
+            width: 100%;
+        }
+    </style>
+</head>
+<body>
+    <p><a href="/">Back to the library</a></p>
+    <h1 class="remote-title">No video playing</h1>
+    <p class="remote-hint">Open a video on the other device to control it from here.</p>
+    <label>Position <span class="remote-time">0:00 / 0:00</span>
+        <input type="range" class="remote-position" min="0" max="0" step="1" value="0" disabled>
+    </label>
+    <div class="controls">
+        <button data-command="skip" data-value="-10" aria-label="Back 10 seconds">⏪</button>
+        <button class="remote-toggle" aria-label="Play">▶</button>
+        <button data-command="skip" data-value="10" aria-label="Forward 10 seconds">⏩</button>
+        <button data-command="next" aria-label="Next video">⏭</button>
+    </div>
+    <label>Volume
+        <input type="range" class="remote-volume" min="0" max="1" step="0.05" value="1">
+    </label>
+    <script>
+        const title = document.querySelector('.remote-title');
+        const hint = document.querySelector('.remote-hint');
+        const position = document.querySelector('.remote-position');
+        const timeLabel = document.querySelector('.remote-time');
+        const toggle = document.querySelector('.remote-toggle');
+        const volume = document.querySelector('.remote-volume');
+        let socket;
+        let paused = true;
+        let seeking = false;
+
+        function formatTime(seconds) {
+            seconds = Math.floor(seconds || 0);
+            const minutes = Math.floor(seconds / 60);
+            return minutes + ':' + String(seconds % 60).padStart(2, '0');
+        }
+
+        function send(command, value) {
+            if (socket && socket.readyState === WebSocket.OPEN) {
+                socket.send(JSON.stringify({Command: command, Value: value || 0}));
+            }
+        }
+
+        function render(status) {
+            const playing = status.Command === 'status';
+            title.textContent = playing ? status.Title : 'No video playing';
+            hint.hidden = playing;
+            position.disabled = !playing;
+            paused = !playing || status.Paused;
+            toggle.textContent = paused ? '▶' : '⏸';
+            toggle.setAttribute('aria-label', paused ? 'Play' : 'Pause');
+            if (!playing) {
+                return;
+            }
+
+            position.max = Math.floor(status.Duration || 0);
+            if (!seeking) {
+                position.value = Math.floor(status.Time);
+            }
+            timeLabel.textContent = formatTime(status.Time) + ' / ' + formatTime(status.Duration);
+            volume.value = status.Volume;
+        }
+
+        function connect() {
+            const protocol = location.protocol === 'https:' ? 'wss://' : 'ws://';
+            socket = new WebSocket(protocol + location.host + '/remote/ws');
+            socket.onmessage = event => render(JSON.parse(event.data));
+            socket.onclose = () => {
+                render({});
+                setTimeout(connect, 5000);
+            };
+        }
+
+        document.querySelectorAll('[data-command]').forEach(button => {
+            button.addEventListener('click', () => send(button.dataset.command, parseFloat(button.dataset.value)));
+        });
+        toggle.addEventListener('click', () => send(paused ? 'play' : 'pause'));
+        position.addEventListener('input', () => seeking = true);
+        position.addEventListener('change', () => {
+            seeking = false;
+            send('seek', parseFloat(position.value));
+        });
+        volume.addEventListener('input', () => send('volume', parseFloat(volume.value)));
+
+        connect();
+    </script>
+</body>
+</html>`
+
+	return template.Must(template.New("remote").Parse(tmpl))
+}
+
+func handleRemote(w http.ResponseWriter, r *http.Request, tmpl *template.Template) {
+	data := struct {
+		Title string
+	}{
+		Title: pageTitle,
+	}
+
+	tmpl.Execute(w, data)
+}
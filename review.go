@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// reviewDue reports whether a video flagged for review is due.
+func reviewDue(video VideoFile, now time.Time) bool {
+	return video.ReviewAt != nil && !video.ReviewAt.After(now)
+}
+
+func dueReviews(videoFiles []VideoFile) []VideoFile {
+	now := time.Now()
+	videos := filterVideos(videoFiles, func(video VideoFile) bool { return reviewDue(video, now) })
+	sort.SliceStable(videos, func(i, j int) bool {
+		return videos[i].ReviewAt.Before(*videos[j].ReviewAt)
+	})
+
+	return videos
+}
+
+// handleReview flags a video to be reviewed after the interval of the
+// request (e.g. "3d"), or clears the flag once it has been reviewed.
+func handleReview(w http.ResponseWriter, r *http.Request, videoFiles []VideoFile, path string) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+
+	var reviewAt *time.Time
+	if r.FormValue("done") == "" {
+		d, err := parseRuleDuration(r.FormValue("interval"))
+		if err != nil || d <= 0 {
+			httpError(w, r, "Invalid review interval", http.StatusBadRequest)
+			return
+		}
+
+		t := time.Now().Add(d)
+		reviewAt = &t
+	}
+
+	video, err := updateVideoState(videoFiles, path, strings.TrimPrefix(r.URL.Path, "/review/"), func(video *VideoFile) {
+		video.ReviewAt = reviewAt
+	})
+	if errors.Is(err, errVideoNotFound) {
+		notFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("Error saving video progress: %v", err)
+		httpError(w, r, "Error saving video progress: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	detail := "reviewed"
+	if reviewAt != nil {
+		detail = "due " + reviewAt.Format(time.DateOnly)
+	}
+	audit(r, path, auditReview, video.Name, detail)
+
+	http.Redirect(w, r, "/watch/"+video.ID, http.StatusSeeOther)
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	scanIdle     = "scan.idle"
+	scanStarted  = "scan.started"
+	scanProgress = "scan.progress"
+	scanFinished = "scan.finished"
+	scanFailed   = "scan.error"
+
+	scanProgressInterval = 500 * time.Millisecond
+)
+
+// ScanStatus is the state of the current, or last, scan of the library. The
+// percentage is estimated from the number of entries of the previous scan,
+// it is -1 during the first one.
+type ScanStatus struct {
+	Type     string
+	Scanning bool
+	Started  time.Time
+	Finished time.Time
+	Entries  int
+	Videos   int
+	Percent  int
+	Error    string `json:",omitempty"`
+}
+
+// scanTracker follows the scans of the library, for /api/scan and the pages
+// following them.
+type scanTracker struct {
+	mu          sync.Mutex
+	status      ScanStatus
+	lastEntries int
+	reported    time.Time
+	subscribers map[chan ScanStatus]bool
+}
+
+var (
+	scans = &scanTracker{subscribers: make(map[chan ScanStatus]bool)}
+
+	// scanMu runs one scan at a time, the rescans being triggered from
+	// several places.
+	scanMu sync.Mutex
+
+	library = &videoList{}
+)
+
+// videoList is the list of the library videos, replaced by the rescans and
+// updated with the watch state changes while the handlers read it. The list
+// is never changed in place but copied, so the handlers work on a snapshot
+// of it.
+type videoList struct {
+	mu     sync.RWMutex
+	videos []VideoFile
+}
+
+func (l *videoList) snapshot() []VideoFile {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.videos
+}
+
+// replace sets the list of a scan, returning the previous one.
+func (l *videoList) replace(videos []VideoFile) []VideoFile {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	previous := l.videos
+	l.videos = videos
+
+	return previous
+}
+
+// update replaces the entry of the video, if it is still listed.
+func (l *videoList) update(video VideoFile) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	i := slices.IndexFunc(l.videos, func(v VideoFile) bool { return v.ID == video.ID })
+	if i < 0 {
+		return
+	}
+
+	videos := slices.Clone(l.videos)
+	videos[i] = video
+	l.videos = videos
+}
+
+// scanLibrary loads the video files of the library, reporting the progress
+// of the scan.
+func scanLibrary(root string) ([]VideoFile, error) {
+	scanMu.Lock()
+	defer scanMu.Unlock()
+
+	scans.start()
+	videoFiles, err := scanVideoFiles(root, scans.progress)
+	scans.finish(len(videoFiles), err)
+
+	return videoFiles, err
+}
+
+func (s *scanTracker) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	percent := -1
+	if s.lastEntries > 0 {
+		percent = 0
+	}
+	s.status = ScanStatus{Type: scanStarted, Scanning: true, Started: time.Now(), Percent: percent}
+	s.reported = s.status.Started
+	s.send()
+}
+
+// progress records the entries walked and the videos found so far. It is
+// reported every scanProgressInterval.
+func (s *scanTracker) progress(entries int, videos int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status.Entries = entries
+	s.status.Videos = videos
+	if s.lastEntries > 0 {
+		// The library may have grown since the previous scan.
+		s.status.Percent = min(entries*100/s.lastEntries, 99)
+	}
+
+	if time.Since(s.reported) < scanProgressInterval {
+		return
+	}
+	s.reported = time.Now()
+	s.status.Type = scanProgress
+	s.send()
+}
+
+func (s *scanTracker) finish(videos int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status.Scanning = false
+	s.status.Finished = time.Now()
+	if err != nil {
+		s.status.Type = scanFailed
+		s.status.Error = err.Error()
+	} else {
+		s.status.Type = scanFinished
+		s.status.Videos = videos
+		s.status.Percent = 100
+		s.lastEntries = s.status.Entries
+	}
+	s.send()
+}
+
+// send delivers the status to the subscribers. A slow subscriber misses
+// progress events rather than blocking the scan.
+func (s *scanTracker) send() {
+	for subscriber := range s.subscribers {
+		select {
+		case subscriber <- s.status:
+		default:
+		}
+	}
+}
+
+// current returns the status of the scan.
+func (s *scanTracker) current() ScanStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.status
+}
+
+func (s *scanTracker) subscribe() (ScanStatus, chan ScanStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subscriber := make(chan ScanStatus, 10)
+	s.subscribers[subscriber] = true
+
+	return s.status, subscriber
+}
+
+func (s *scanTracker) unsubscribe(subscriber chan ScanStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.subscribers, subscriber)
+}
+
+// handleScan returns the status of the current, or last, scan.
+func handleScan(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(scans.current())
+}
+
+// handleScanEvents sends the status of the scan, then its changes, as
+// server-sent events named after their type (scan.idle, scan.started,
+// scan.progress, scan.finished or scan.error).
+func handleScanEvents(w http.ResponseWriter, r *http.Request) {
+	controller := http.NewResponseController(w)
+	// The stream lasts as long as the client follows it.
+	controller.SetWriteDeadline(time.Time{})
+
+	status, subscriber := scans.subscribe()
+	defer scans.unsubscribe(subscriber)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+
+	writeScanEvent(w, status)
+	if err := controller.Flush(); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(logStreamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case status := <-subscriber:
+			writeScanEvent(w, status)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func writeScanEvent(w http.ResponseWriter, status ScanStatus) {
+	// No scan has run yet.
+	if status.Type == "" {
+		status.Type = scanIdle
+	}
+	jsonData, _ := json.Marshal(status)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", status.Type, jsonData)
+}
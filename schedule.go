@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	jobRescan          = "rescan"
+	jobThumbnails      = "thumbnails"
+	jobPruneTranscodes = "prune-transcodes"
+	jobBackup          = "backup"
+	jobSnapshot        = "snapshot"
+	jobChecksum        = "checksum"
+)
+
+var cronMacros = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+}
+
+// ScheduledJob is a maintenance job of the -schedule file, run when the
+// time matches Cron, a crontab expression ("0 3 * * *" every day at 3am) or
+// a macro (@hourly, @daily, @weekly, @monthly). Target is the directory the
+// backup archives are written to, the backup being pushed to -backup-remote
+// without it.
+type ScheduledJob struct {
+	Job    string
+	Cron   string
+	Target string `json:",omitempty"`
+
+	schedule cronSchedule
+	running  sync.Mutex
+}
+
+// cronSchedule holds the allowed values of the fields of a crontab
+// expression: minute, hour, day of the month, month and day of the week.
+type cronSchedule struct {
+	fields [5]map[int]bool
+	// anyDay is whether the day of the month or the day of the week is *,
+	// the days matching either of them otherwise.
+	anyDay bool
+}
+
+var cronRanges = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+func parseCron(expression string) (cronSchedule, error) {
+	if macro, ok := cronMacros[expression]; ok {
+		expression = macro
+	}
+
+	parts := strings.Fields(expression)
+	if len(parts) != 5 {
+		return cronSchedule{}, fmt.Errorf("%q must have 5 fields (minute, hour, day of the month, month and day of the week)", expression)
+	}
+
+	var schedule cronSchedule
+	for i, part := range parts {
+		values, err := parseCronField(part, cronRanges[i][0], cronRanges[i][1])
+		if err != nil {
+			return cronSchedule{}, fmt.Errorf("%q: %v", expression, err)
+		}
+		schedule.fields[i] = values
+	}
+	// Sunday is 0 or 7.
+	if schedule.fields[4][7] {
+		schedule.fields[4][0] = true
+	}
+	schedule.anyDay = parts[2] == "*" || parts[4] == "*"
+
+	return schedule, nil
+}
+
+// parseCronField parses a comma-separated list of values, ranges (1-5) and
+// steps (*/15, 0-30/10).
+func parseCronField(field string, low int, high int) (map[int]bool, error) {
+	values := make(map[int]bool)
+	for _, item := range strings.Split(field, ",") {
+		item, stepValue, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepValue); err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step %q", stepValue)
+			}
+		}
+
+		start, end := low, high
+		if item != "*" {
+			first, last, isRange := strings.Cut(item, "-")
+			var err error
+			if start, err = strconv.Atoi(first); err != nil {
+				return nil, fmt.Errorf("invalid value %q", item)
+			}
+			end = start
+			if isRange {
+				if end, err = strconv.Atoi(last); err != nil {
+					return nil, fmt.Errorf("invalid value %q", item)
+				}
+			} else if hasStep {
+				end = high
+			}
+		}
+		if start < low || end > high || start > end {
+			return nil, fmt.Errorf("%q is out of the range %d-%d", item, low, high)
+		}
+
+		for value := start; value <= end; value += step {
+			values[value] = true
+		}
+	}
+
+	return values, nil
+}
+
+func (s cronSchedule) matches(t time.Time) bool {
+	if !s.fields[0][t.Minute()] || !s.fields[1][t.Hour()] || !s.fields[3][int(t.Month())] {
+		return false
+	}
+
+	dayOfMonth := s.fields[2][t.Day()]
+	dayOfWeek := s.fields[4][int(t.Weekday())]
+	if s.anyDay {
+		return dayOfMonth && dayOfWeek
+	}
+
+	return dayOfMonth || dayOfWeek
+}
+
+// loadSchedule reads the jobs of the -schedule file.
+func loadSchedule(file string) ([]*ScheduledJob, error) {
+	jsonData, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []*ScheduledJob
+	if err := json.Unmarshal(jsonData, &jobs); err != nil {
+		return nil, err
+	}
+
+	for i, job := range jobs {
+		switch job.Job {
+		case jobRescan, jobPruneTranscodes, jobSnapshot, jobChecksum:
+		case jobThumbnails:
+			if !thumbnailsEnabled() {
+				return nil, fmt.Errorf("job %d: thumbnails require ffmpeg", i+1)
+			}
+		case jobBackup:
+			if job.Target == "" && !remoteBackupEnabled() {
+				return nil, fmt.Errorf("job %d: backup requires a Target directory or -backup-remote", i+1)
+			}
+		default:
+			return nil, fmt.Errorf("job %d: unknown job %q", i+1, job.Job)
+		}
+
+		if job.schedule, err = parseCron(job.Cron); err != nil {
+			return nil, fmt.Errorf("job %d: %v", i+1, err)
+		}
+	}
+
+	return jobs, nil
+}
+
+// startSchedule runs the jobs at the start of the minutes matching their
+// schedule. A job still running at its next time is skipped.
+func startSchedule(path string, jobs []*ScheduledJob, rescan func()) {
+	go func() {
+		for {
+			now := time.Now()
+			next := now.Truncate(time.Minute).Add(time.Minute)
+			time.Sleep(next.Sub(now))
+
+			for _, job := range jobs {
+				if !job.schedule.matches(next) {
+					continue
+				}
+
+				go func() {
+					if !job.running.TryLock() {
+						debug("Skipping the %s job, still running", job.Job)
+						return
+					}
+					defer job.running.Unlock()
+
+					started := time.Now()
+					if err := runScheduledJob(path, job, rescan); err != nil {
+						log.Printf("Error running the %s job: %v", job.Job, err)
+						return
+					}
+					debug("Ran the %s job in %v", job.Job, time.Since(started).Round(time.Millisecond))
+				}()
+			}
+		}
+	}()
+}
+
+func runScheduledJob(path string, job *ScheduledJob, rescan func()) error {
+	switch job.Job {
+	case jobRescan:
+		rescan()
+	case jobThumbnails:
+		return queueMissingThumbnails(path)
+	case jobPruneTranscodes:
+		evictTranscodes(path)
+	case jobBackup:
+		if job.Target == "" {
+			return pushRemoteBackup(path)
+		}
+		return writeBackupArchive(path, job.Target)
+	case jobSnapshot:
+		if _, err := writeSnapshot(path); err != nil {
+			return err
+		}
+		return pruneSnapshots(path)
+	case jobChecksum:
+		jobs.add(Job{Kind: jobKindChecksum}, false)
+	}
+
+	return nil
+}
+
+// queueMissingThumbnails queues the thumbnails of the videos not opened yet,
+// so that the library pages do not wait for them.
+func queueMissingThumbnails(path string) error {
+	videoFiles, err := loadVideoFiles(path)
+	if err != nil {
+		return err
+	}
+
+	queued := 0
+	for _, video := range videoFiles {
+		thumbnail, err := thumbnailFile(video.Path, libraryCacheDir(path))
+		if err != nil {
+			log.Printf("Error generating thumbnail: %v", err)
+			continue
+		}
+		if _, err := os.Stat(thumbnail); err == nil {
+			continue
+		}
+
+		jobs.add(Job{Kind: jobKindThumbnail, Video: video.Name, Path: video.Path}, false)
+		queued++
+	}
+	debug("Queued %d thumbnails", queued)
+
+	return nil
+}
+
+// writeBackupArchive writes a backup archive of the state files into the
+// directory.
+func writeBackupArchive(path string, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	archive := filepath.Join(dir, "videos-viewer-backup-"+time.Now().Format("20060102-150405")+".zip")
+	file, err := os.Create(archive)
+	if err != nil {
+		return err
+	}
+
+	if _, err := writeBackup(file, path); err != nil {
+		file.Close()
+		os.Remove(archive)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	debug("Backed up the state files to %s", archive)
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		field   string
+		low     int
+		high    int
+		want    []int
+		wantErr bool
+	}{
+		{field: "5", low: 0, high: 59, want: []int{5}},
+		{field: "1,3,5", low: 0, high: 59, want: []int{1, 3, 5}},
+		{field: "1-4", low: 0, high: 59, want: []int{1, 2, 3, 4}},
+		{field: "*", low: 1, high: 3, want: []int{1, 2, 3}},
+		{field: "*/15", low: 0, high: 59, want: []int{0, 15, 30, 45}},
+		{field: "0-30/10", low: 0, high: 59, want: []int{0, 10, 20, 30}},
+		{field: "50/5", low: 0, high: 59, want: []int{50, 55}},
+		{field: "1-2,10", low: 0, high: 59, want: []int{1, 2, 10}},
+		{field: "60", low: 0, high: 59, wantErr: true},
+		{field: "0", low: 1, high: 31, wantErr: true},
+		{field: "5-1", low: 0, high: 59, wantErr: true},
+		{field: "*/0", low: 0, high: 59, wantErr: true},
+		{field: "*/x", low: 0, high: 59, wantErr: true},
+		{field: "a", low: 0, high: 59, wantErr: true},
+		{field: "1-b", low: 0, high: 59, wantErr: true},
+		{field: "", low: 0, high: 59, wantErr: true},
+	}
+
+	for _, test := range tests {
+		values, err := parseCronField(test.field, test.low, test.high)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("parseCronField(%q) = %v, want an error", test.field, values)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseCronField(%q): %v", test.field, err)
+			continue
+		}
+
+		want := make(map[int]bool)
+		for _, value := range test.want {
+			want[value] = true
+		}
+		if !reflect.DeepEqual(values, want) {
+			t.Errorf("parseCronField(%q) = %v, want %v", test.field, values, want)
+		}
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expression string
+		wantErr    bool
+	}{
+		{expression: "* * * * *"},
+		{expression: "30 2 * * 1-5"},
+		{expression: "@daily"},
+		{expression: "@hourly"},
+		{expression: "* * *", wantErr: true},
+		{expression: "* * * * * *", wantErr: true},
+		{expression: "60 * * * *", wantErr: true},
+		{expression: "* 24 * * *", wantErr: true},
+		{expression: "* * * 13 *", wantErr: true},
+		{expression: "* * * * 8", wantErr: true},
+	}
+
+	for _, test := range tests {
+		_, err := parseCron(test.expression)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseCron(%q) error = %v, want error %v", test.expression, err, test.wantErr)
+		}
+	}
+}
+
+func TestCronScheduleMatches(t *testing.T) {
+	// 2024-01-07 is a Sunday.
+	sunday := time.Date(2024, time.January, 7, 2, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		expression string
+		time       time.Time
+		want       bool
+	}{
+		{expression: "30 2 * * *", time: sunday, want: true},
+		{expression: "31 2 * * *", time: sunday, want: false},
+		{expression: "30 2 * * 0", time: sunday, want: true},
+		{expression: "30 2 * * 7", time: sunday, want: true},
+		{expression: "30 2 * * 1-5", time: sunday, want: false},
+		// The day of the month and the day of the week match either.
+		{expression: "30 2 1 * 0", time: sunday, want: true},
+		{expression: "30 2 7 * 1", time: sunday, want: true},
+		{expression: "30 2 1 * 1", time: sunday, want: false},
+		{expression: "30 2 * 2 *", time: sunday, want: false},
+	}
+
+	for _, test := range tests {
+		schedule, err := parseCron(test.expression)
+		if err != nil {
+			t.Fatalf("parseCron(%q): %v", test.expression, err)
+		}
+		if got := schedule.matches(test.time); got != test.want {
+			t.Errorf("%q matches %v = %v, want %v", test.expression, test.time, got, test.want)
+		}
+	}
+}
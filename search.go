@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	searchName        = "Name"
+	searchDescription = "Description"
+	searchNote        = "Note"
+	searchBookmark    = "Bookmark"
+	searchSubtitle    = "Transcript"
+
+	snippetRadius = 60
+)
+
+type SearchResult struct {
+	Video   VideoFile
+	Kind    string
+	Snippet string
+	Time    float64
+}
+
+type SearchGroup struct {
+	Folder  string
+	Results []SearchResult
+}
+
+// subtitleCue is a line of a subtitle file, Start being in seconds.
+type subtitleCue struct {
+	Start float64
+	Text  string
+}
+
+// searchLibrary looks for the query in the names, descriptions, notes,
+// bookmarks and subtitles of the videos, and groups the results by folder.
+func searchLibrary(query string, videoFiles []VideoFile, path string) []SearchGroup {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return nil
+	}
+
+	notes, err := loadNotes(path)
+	if err != nil {
+		log.Printf("Error loading notes: %v", err)
+	}
+
+	groups := make(map[string]*SearchGroup)
+	var folders []string
+	add := func(video VideoFile, kind string, text string, t float64) {
+		snippet, ok := searchSnippet(text, query)
+		if !ok {
+			return
+		}
+
+		folder := videoFolder(video, path)
+		if groups[folder] == nil {
+			groups[folder] = &SearchGroup{Folder: folder}
+			folders = append(folders, folder)
+		}
+		groups[folder].Results = append(groups[folder].Results, SearchResult{Video: video, Kind: kind, Snippet: snippet, Time: t})
+	}
+
+	for _, video := range videoFiles {
+		add(video, searchName, video.DisplayName(), 0)
+
+		if metadata := videoMetadata(video); metadata != nil {
+			add(video, searchDescription, metadata.Title+" "+metadata.Overview, 0)
+		}
+
+		add(video, searchNote, notes[video.Name].Note, 0)
+		for _, bookmark := range notes[video.Name].Bookmarks {
+			add(video, searchBookmark, bookmark.Text, bookmark.Time)
+		}
+
+		for _, cue := range subtitleCues(video) {
+			add(video, searchSubtitle, cue.Text, cue.Start)
+		}
+	}
+
+	sort.Strings(folders)
+	results := make([]SearchGroup, len(folders))
+	for i, folder := range folders {
+		results[i] = *groups[folder]
+	}
+
+	return results
+}
+
+// searchSnippet returns the text around the first match of the query.
+func searchSnippet(text string, query string) (string, bool) {
+	i := strings.Index(strings.ToLower(text), query)
+	if i < 0 {
+		return "", false
+	}
+
+	from, to := max(i-snippetRadius, 0), min(i+len(query)+snippetRadius, len(text))
+	for from > 0 && !utf8.RuneStart(text[from]) {
+		from--
+	}
+	for to < len(text) && !utf8.RuneStart(text[to]) {
+		to++
+	}
+
+	snippet := strings.Join(strings.Fields(text[from:to]), " ")
+	if from > 0 {
+		snippet = "…" + snippet
+	}
+	if to < len(text) {
+		snippet += "…"
+	}
+
+	return snippet, true
+}
+
+// subtitleCues reads the SRT and WebVTT files next to a video.
+func subtitleCues(video VideoFile) []subtitleCue {
+	var cues []subtitleCue
+	for _, file := range subtitleFiles(video) {
+		cues = append(cues, readSubtitleCues(file)...)
+	}
+
+	return cues
+}
+
+func readSubtitleCues(file string) []subtitleCue {
+	f, err := os.Open(file)
+	if err != nil {
+		debug("Error reading subtitles \"%s\": %v", file, err)
+		return nil
+	}
+	defer f.Close()
+
+	var cues []subtitleCue
+	var current *subtitleCue
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			current = nil
+		case strings.Contains(line, "-->"):
+			start, err := parseSubtitleTime(strings.TrimSpace(strings.Split(line, "-->")[0]))
+			if err != nil {
+				continue
+			}
+			cues = append(cues, subtitleCue{Start: start})
+			current = &cues[len(cues)-1]
+		case current != nil:
+			current.Text = strings.TrimSpace(current.Text + " " + line)
+		}
+	}
+
+	return cues
+}
+
+// parseSubtitleTime parses "00:01:02,500" (SRT) and "01:02.500" (WebVTT)
+// timestamps.
+func parseSubtitleTime(value string) (float64, error) {
+	var seconds float64
+	for _, part := range strings.Split(strings.Replace(value, ",", ".", 1), ":") {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, err
+		}
+		seconds = seconds*60 + n
+	}
+
+	return seconds, nil
+}
+
+func createSearchTemplate() *template.Template {
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>{{if .Query}}{{.Query}} - {{end}}Search - {{.Title}}</title>
+    <style>
+        body {
+            font-family: Arial, sans-serif;
+            margin: 20px;
+        }
+        .search-results {
+            list-style: none;
+            padding: 0;
+        }
+        .search-results li {
+            margin: 10px 0;
+        }
+        .search-kind {
+            color: #666;
+            font-size: 12px;
+        }
+    </style>
+</head>
+<body>
+    <p><a href="/">Back to the library</a></p>
+    <form method="get" action="/search">
+        <input type="search" name="q" value="{{.Query}}" placeholder="Search names, notes, transcripts" size="40" autofocus>
+        <button type="submit">Search</button>
+    </form>
+    {{if .Query}}
+    {{range .Groups}}
+    <h2>{{if .Folder}}{{.Folder}}{{else}}{{$.Title}}{{end}} ({{len .Results}})</h2>
+    <ul class="search-results">
+        {{range .Results}}
+        <li>
+            <a href="/watch/{{.Video.ID}}{{if .Time}}?t={{printf "%.0f" .Time}}{{end}}">{{.Video.DisplayName}}{{if .Time}} at {{formatTimestamp .Time}}{{end}}</a>
+            <span class="search-kind">{{.Kind}}</span><br>
+            {{.Snippet}}
+        </li>
+        {{end}}
+    </ul>
+    {{else}}
+    <p>No results.</p>
+    {{end}}
+    {{end}}
+</body>
+</html>`
+
+	funcs := template.FuncMap{
+		"formatTimestamp": formatTimestamp,
+	}
+
+	return template.Must(template.New("search").Funcs(funcs).Parse(tmpl))
+}
+
+func handleSearch(w http.ResponseWriter, r *http.Request, videoFiles []VideoFile, path string, tmpl *template.Template) {
+	query := r.URL.Query().Get("q")
+	data := struct {
+		Title  string
+		Query  string
+		Groups []SearchGroup
+	}{
+		Title:  pageTitle,
+		Query:  query,
+		Groups: searchLibrary(query, videoFiles, path),
+	}
+
+	tmpl.Execute(w, data)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sequenceFiles are the manifests giving the order and the titles of the
+// videos of a folder, as written by course downloaders. The first one found
+// in a folder is used.
+var sequenceFiles = []string{"playlist.m3u8", "playlist.m3u", "index.json", "playlist.txt"}
+
+// sequenceEntry is a video of a sequence manifest, File being relative to
+// the folder of the manifest.
+type sequenceEntry struct {
+	File  string
+	Title string
+}
+
+// readSequence reads the sequence manifest of a folder, if any.
+func readSequence(dir string) ([]sequenceEntry, bool) {
+	for _, name := range sequenceFiles {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+
+		var entries []sequenceEntry
+		switch filepath.Ext(name) {
+		case ".json":
+			entries, err = parseSequenceJSON(content)
+		case ".txt":
+			entries = parseSequenceText(content)
+		default:
+			entries = parseM3U(content)
+		}
+		if err != nil {
+			debug("Ignore \"%s\": %v", filepath.Join(dir, name), err)
+			continue
+		}
+
+		return entries, true
+	}
+
+	return nil, false
+}
+
+// parseM3U reads the entries of a playlist, with the titles of the #EXTINF
+// lines. The remote entries are skipped.
+func parseM3U(content []byte) []sequenceEntry {
+	var entries []sequenceEntry
+	title := ""
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		switch {
+		case strings.HasPrefix(line, "#EXTINF:"):
+			if _, value, ok := strings.Cut(line, ","); ok {
+				title = strings.TrimSpace(value)
+			}
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.Contains(line, "://"):
+			title = ""
+		default:
+			entries = append(entries, sequenceEntry{File: line, Title: title})
+			title = ""
+		}
+	}
+
+	return entries
+}
+
+// parseSequenceText reads a file name per line, optionally followed by a
+// tab and the title.
+func parseSequenceText(content []byte) []sequenceEntry {
+	var entries []sequenceEntry
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		file, title, _ := strings.Cut(line, "\t")
+		entries = append(entries, sequenceEntry{File: strings.TrimSpace(file), Title: strings.TrimSpace(title)})
+	}
+
+	return entries
+}
+
+// parseSequenceJSON reads a list of file names, or of objects with the file
+// (file, filename, path or src) and the title (title or name). The list can
+// be wrapped in an object, under videos, lessons, items or files.
+func parseSequenceJSON(content []byte) ([]sequenceEntry, error) {
+	var value any
+	if err := json.Unmarshal(content, &value); err != nil {
+		return nil, err
+	}
+
+	if object, ok := value.(map[string]any); ok {
+		for _, key := range []string{"videos", "lessons", "items", "files"} {
+			if list, ok := object[key]; ok {
+				value = list
+				break
+			}
+		}
+	}
+
+	list, _ := value.([]any)
+	var entries []sequenceEntry
+	for _, item := range list {
+		switch item := item.(type) {
+		case string:
+			entries = append(entries, sequenceEntry{File: item})
+		case map[string]any:
+			var entry sequenceEntry
+			for _, key := range []string{"file", "filename", "path", "src"} {
+				if file, ok := item[key].(string); ok && file != "" {
+					entry.File = file
+					break
+				}
+			}
+			for _, key := range []string{"title", "name"} {
+				if title, ok := item[key].(string); ok && title != "" {
+					entry.Title = strings.TrimSpace(title)
+					break
+				}
+			}
+			if entry.File != "" {
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	return entries, nil
+}
+
+// applySequences gives the videos the titles of the sequence manifests of
+// their folders, and returns the order of the videos of these folders, by
+// folder.
+func applySequences(videoFiles []VideoFile) map[string][]string {
+	byPath := make(map[string]int)
+	dirs := make(map[string]string)
+	for i, video := range videoFiles {
+		byPath[filepath.Clean(video.Path)] = i
+		dirs[filepath.Dir(video.Path)] = video.Folder
+	}
+
+	orders := make(map[string][]string)
+	for dir, folder := range dirs {
+		entries, ok := readSequence(dir)
+		if !ok {
+			continue
+		}
+
+		for _, entry := range entries {
+			i, ok := byPath[filepath.Join(dir, filepath.FromSlash(entry.File))]
+			if !ok {
+				continue
+			}
+			if entry.Title != "" {
+				videoFiles[i].Title = entry.Title
+			}
+			if videoFiles[i].Folder == folder {
+				orders[folder] = append(orders[folder], videoFiles[i].Name)
+			}
+		}
+	}
+
+	return orders
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sendChunkSize is the size of the parts of the files sent with sendfile,
+// the send timeout being renewed between them.
+const sendChunkSize = 4 << 20
+
+var (
+	listenAddr        string
+	tlsCertFile       string
+	tlsKeyFile        string
+	readHeaderTimeout time.Duration
+	idleTimeout       time.Duration
+	writeTimeout      time.Duration
+	readTimeout       time.Duration
+	sendTimeout       time.Duration
+	maxHeaderBytes    int
+	socketBufferSize  int
+)
+
+// newServer returns the HTTP server of the viewer. Videos are served with
+// http.ServeFile, which uses sendfile over plain HTTP connections; the write
+// timeout is disabled by default since a stream can last for hours, the
+// clients that stop sending or receiving data being cut off by the read and
+// send timeouts instead.
+func newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           limitBodyReads(handler),
+		ReadHeaderTimeout: readHeaderTimeout,
+		IdleTimeout:       idleTimeout,
+		WriteTimeout:      writeTimeout,
+		MaxHeaderBytes:    maxHeaderBytes,
+		ConnContext: func(ctx context.Context, conn net.Conn) context.Context {
+			if socketBufferSize > 0 {
+				setSocketBuffer(conn, socketBufferSize)
+			}
+			return ctx
+		},
+	}
+}
+
+func setSocketBuffer(conn net.Conn, size int) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	if stalled, ok := conn.(*stallConn); ok {
+		conn = stalled.Conn
+	}
+
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		if err := tcpConn.SetWriteBuffer(size); err != nil {
+			debug("Error setting the socket buffer size: %v", err)
+		}
+	}
+}
+
+// listenAddresses returns the addresses of -listen, comma-separated, or the
+// port on all the interfaces. A port alone listens on all the interfaces.
+func listenAddresses(listen string, port string) ([]string, error) {
+	if listen == "" {
+		return []string{":" + port}, nil
+	}
+
+	var addrs []string
+	for _, addr := range strings.Split(listen, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		if !strings.Contains(addr, ":") {
+			addr = ":" + addr
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, addr)
+	}
+
+	return addrs, nil
+}
+
+// listenAll opens the listeners of the addresses, so that an address in use
+// is reported before serving any of them.
+func listenAll(addrs []string) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, addr := range addrs {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, listener := range listeners {
+				listener.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+
+	return listeners, nil
+}
+
+// serveAll serves the handler on every listener, and returns the first
+// error.
+func serveAll(listeners []net.Listener, handler http.Handler) error {
+	errs := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		server := newServer(listener.Addr().String(), handler)
+		if sendTimeout > 0 {
+			listener = stallListener{listener}
+		}
+		go func() {
+			errs <- serve(server, listener)
+		}()
+	}
+
+	return <-errs
+}
+
+// serve serves over HTTPS, with HTTP/2, when a certificate is set.
+func serve(server *http.Server, listener net.Listener) error {
+	if tlsCertFile != "" && tlsKeyFile != "" {
+		return server.ServeTLS(listener, tlsCertFile, tlsKeyFile)
+	}
+
+	return server.Serve(listener)
+}
+
+// serverURL returns the URL of the server on the listener, localhost for
+// the listeners on all the interfaces.
+func serverURL(scheme string, listener net.Listener) string {
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "localhost"
+	}
+
+	return scheme + "://" + net.JoinHostPort(host, port)
+}
+
+// limitBodyReads gives the clients readTimeout to send each part of the body
+// of their requests, rather than the whole body as http.Server.ReadTimeout
+// would, which also ends the streams lasting longer than it.
+func limitBodyReads(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if readTimeout > 0 && r.Body != nil && r.Body != http.NoBody {
+			r.Body = &deadlineBody{ReadCloser: r.Body, controller: http.NewResponseController(w)}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+type deadlineBody struct {
+	io.ReadCloser
+	controller *http.ResponseController
+}
+
+func (b *deadlineBody) Read(p []byte) (int, error) {
+	b.controller.SetReadDeadline(time.Now().Add(readTimeout))
+	n, err := b.ReadCloser.Read(p)
+	// The connection is read in the background once the body is read, to
+	// detect the clients going away. After a timeout, the deadline is kept
+	// for the server not to wait for the rest of the body.
+	if err == io.EOF {
+		b.controller.SetReadDeadline(time.Time{})
+	}
+
+	return n, err
+}
+
+// stallListener accepts connections closed when the client stops receiving
+// the response, a write not progressing within sendTimeout.
+type stallListener struct {
+	net.Listener
+}
+
+func (l stallListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &stallConn{Conn: conn}, nil
+}
+
+type stallConn struct {
+	net.Conn
+
+	mu sync.Mutex
+	// deadline is the write deadline set by the server or the handler.
+	deadline time.Time
+}
+
+func (c *stallConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *stallConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// renew sets the write deadline to sendTimeout from now, or the deadline
+// set by the server when it is earlier.
+func (c *stallConn) renew() {
+	c.mu.Lock()
+	deadline := time.Now().Add(sendTimeout)
+	if !c.deadline.IsZero() && c.deadline.Before(deadline) {
+		deadline = c.deadline
+	}
+	c.mu.Unlock()
+
+	c.Conn.SetWriteDeadline(deadline)
+}
+
+func (c *stallConn) Write(p []byte) (int, error) {
+	c.renew()
+	return c.Conn.Write(p)
+}
+
+// ReadFrom keeps sending the files with sendfile, in chunks renewing the
+// deadline.
+func (c *stallConn) ReadFrom(r io.Reader) (int64, error) {
+	from, ok := c.Conn.(io.ReaderFrom)
+	if !ok {
+		return io.Copy(struct{ io.Writer }{c}, r)
+	}
+
+	return copyChunks(from, r, func() error {
+		c.renew()
+		return nil
+	})
+}
+
+// copyChunks copies the reader in chunks of sendChunkSize, calling before
+// ahead of each of them. The files, and the files limited by an
+// io.LimitedReader, are still sent with sendfile, which only sees through
+// one io.LimitedReader.
+func copyChunks(dst io.ReaderFrom, r io.Reader, before func() error) (int64, error) {
+	limited, isLimited := r.(*io.LimitedReader)
+	if isLimited {
+		r = limited.R
+	}
+
+	var written int64
+	for !isLimited || limited.N > 0 {
+		chunk := int64(sendChunkSize)
+		if isLimited {
+			chunk = min(chunk, limited.N)
+		}
+
+		if err := before(); err != nil {
+			return written, err
+		}
+		n, err := dst.ReadFrom(&io.LimitedReader{R: r, N: chunk})
+		written += n
+		if isLimited {
+			limited.N -= n
+		}
+		if err != nil || n < chunk {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// The service functions read and change the watch state of the library for
+// the pages, the API routes and the /api/v1/ API alike, reporting the errors
+// below rather than HTTP statuses. The audit log, which needs the request, is
+// left to the handlers.
+
+var (
+	errVideoNotFound    = errors.New("video not found")
+	errRevisionMismatch = errors.New("the video changed since it was read")
+)
+
+// ProgressUpdate is a partial update of the watch state of a video, the nil
+// fields being left unchanged.
+type ProgressUpdate struct {
+	Viewed        *bool
+	Progress      *float64
+	SubtitleDelay *int
+}
+
+// invalid returns why the update cannot be applied, or "" when it can.
+func (u ProgressUpdate) invalid() string {
+	if u.Progress != nil && *u.Progress < 0 {
+		return "Invalid progress value"
+	}
+	if u.SubtitleDelay != nil && (*u.SubtitleDelay < -maxSubtitleDelay || *u.SubtitleDelay > maxSubtitleDelay) {
+		return "Invalid subtitle delay"
+	}
+
+	return ""
+}
+
+// StatsReport is the watch activity of the last Days days (0 for all of
+// them): the totals, the most served videos, the clients and the days.
+type StatsReport struct {
+	Days    int
+	Total   DailyStats
+	Videos  []TrafficEntry
+	Clients []TrafficEntry
+	History []StatsDay
+}
+
+// LibraryStats sums up the watch state of the library videos, with the
+// activity of the report.
+type LibraryStats struct {
+	Videos     int
+	Viewed     int
+	InProgress int
+	Activity   StatsReport
+}
+
+// savedVideoStates returns the videos with their saved watch state, which
+// the progress updates change without a rescan.
+func savedVideoStates(path string, videoFiles []VideoFile) ([]VideoFile, error) {
+	viewedVideos, err := loadViewedVideos(path)
+	if err != nil {
+		return nil, err
+	}
+
+	videos := make([]VideoFile, len(videoFiles))
+	for i, video := range videoFiles {
+		if state, ok := viewedVideos[video.File]; ok {
+			video.Viewed = state.Viewed
+			video.Current = state.Current
+			video.Progress = state.Progress
+			video.ReviewAt = state.ReviewAt
+			video.Updated = state.Updated
+			video.SubtitleDelay = state.SubtitleDelay
+		}
+		videos[i] = video
+	}
+
+	return videos, nil
+}
+
+// videoProgress returns the video with its saved watch state.
+func videoProgress(path string, id string) (VideoFile, error) {
+	videoFiles, err := loadVideoFiles(path)
+	if err != nil {
+		return VideoFile{}, err
+	}
+
+	i := findVideoFile(videoFiles, id)
+	if i < 0 {
+		return VideoFile{}, errVideoNotFound
+	}
+
+	return videoFiles[i], nil
+}
+
+// updateProgress applies the update to the saved watch state of the video,
+// unless revision is set and the video changed since. It returns the state
+// before the update and the updated video, or the current state with
+// errRevisionMismatch.
+func updateProgress(path string, id string, update ProgressUpdate, revision string) (Progress, VideoFile, error) {
+	progressMu.Lock()
+	defer progressMu.Unlock()
+
+	videoFiles, err := loadVideoFiles(path)
+	if err != nil {
+		return Progress{}, VideoFile{}, err
+	}
+
+	i := findVideoFile(videoFiles, id)
+	if i < 0 {
+		return Progress{}, VideoFile{}, errVideoNotFound
+	}
+
+	current := newProgress(videoFiles[i])
+	if revision != "" && revision != "*" && revision != current.Revision() {
+		return current, videoFiles[i], errRevisionMismatch
+	}
+
+	if update.Viewed != nil {
+		videoFiles[i].Viewed = *update.Viewed
+	}
+	if update.Progress != nil {
+		recordWatchTime(path, videoFiles[i].Progress, *update.Progress)
+		videoFiles[i].Progress = *update.Progress
+	}
+	if update.SubtitleDelay != nil {
+		videoFiles[i].SubtitleDelay = *update.SubtitleDelay
+	}
+	// Adjusting the subtitles is not watching the video.
+	if update.Viewed != nil || update.Progress != nil {
+		videoFiles[i].Current = time.Now()
+	}
+	if err := saveVideoState(videoFiles, i, path); err != nil {
+		return current, videoFiles[i], err
+	}
+
+	library.update(videoFiles[i])
+
+	if !current.Viewed && videoFiles[i].Viewed {
+		publishCompletion(videoFiles, i, path)
+	}
+
+	return current, videoFiles[i], nil
+}
+
+// updateVideoState applies change to the saved watch state of the video of
+// the library and saves it. The change is made on a copy of the list with
+// the states saved since the scan, which the state file is rewritten from,
+// so that the progress saved since is kept.
+func updateVideoState(videoFiles []VideoFile, path string, id string, change func(video *VideoFile)) (VideoFile, error) {
+	if findVideoFile(videoFiles, id) < 0 {
+		return VideoFile{}, errVideoNotFound
+	}
+
+	progressMu.Lock()
+	defer progressMu.Unlock()
+
+	videos, err := savedVideoStates(path, videoFiles)
+	if err != nil {
+		return VideoFile{}, err
+	}
+
+	i := findVideoFile(videos, id)
+	wasViewed := videos[i].Viewed
+	change(&videos[i])
+	if err := saveVideoState(videos, i, path); err != nil {
+		return VideoFile{}, err
+	}
+	library.update(videos[i])
+
+	if !wasViewed && videos[i].Viewed {
+		publishCompletion(videos, i, path)
+	}
+
+	return videos[i], nil
+}
+
+// setViewed marks the video of the library as watched, to start over the next
+// time, and takes it out of Up next, or marks it as not watched.
+func setViewed(videoFiles []VideoFile, path string, id string, viewed bool) (VideoFile, error) {
+	video, err := updateVideoState(videoFiles, path, id, func(video *VideoFile) {
+		video.Viewed = viewed
+		if viewed {
+			video.Current = time.Now()
+			video.Progress = 0
+		}
+	})
+	if err == nil && viewed {
+		dequeue(path, video.Name)
+	}
+
+	return video, err
+}
+
+// auditProgressUpdate records the changes of the update worth auditing.
+func auditProgressUpdate(r *http.Request, path string, before Progress, video VideoFile, update ProgressUpdate) {
+	if !before.Viewed && video.Viewed {
+		audit(r, path, auditViewed, video.Name, "")
+	}
+	if before.Viewed && !video.Viewed {
+		audit(r, path, auditUnviewed, video.Name, "")
+	}
+	if update.Progress != nil && *update.Progress == 0 && before.Progress > 0 {
+		audit(r, path, auditProgressReset, video.Name, "from "+formatTimestamp(before.Progress))
+	}
+}
+
+// statsDays reads the days parameter of a statistics request, 30 by default.
+func statsDays(r *http.Request) (int, bool) {
+	value := r.URL.Query().Get("days")
+	if value == "" {
+		return 30, true
+	}
+
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 0 {
+		return 0, false
+	}
+
+	return days, true
+}
+
+func statsReport(path string, videoFiles []VideoFile, days int) StatsReport {
+	var since time.Time
+	if days > 0 {
+		since = time.Now().AddDate(0, 0, 1-days)
+	}
+	total := statsSince(path, since)
+
+	statsMu.Lock()
+	var history []StatsDay
+	for day, stats := range loadStats(path) {
+		if day >= statsDay(since) {
+			history = append(history, StatsDay{Day: day, DailyStats: stats})
+		}
+	}
+	statsMu.Unlock()
+	sort.Slice(history, func(i, j int) bool {
+		return history[i].Day > history[j].Day
+	})
+
+	videos := sortedTraffic(total.ServedByVideo, 20)
+	for i := range videos {
+		if j := findVideoFile(videoFiles, videos[i].Name); j >= 0 {
+			videos[i].ID = videoFiles[j].ID
+		}
+	}
+
+	return StatsReport{
+		Days:    days,
+		Total:   total,
+		Videos:  videos,
+		Clients: sortedTraffic(total.ServedByClient, 0),
+		History: history,
+	}
+}
+
+func libraryStats(path string, videoFiles []VideoFile, days int) (LibraryStats, error) {
+	videos, err := savedVideoStates(path, videoFiles)
+	if err != nil {
+		return LibraryStats{}, err
+	}
+
+	stats := LibraryStats{Videos: len(videos), Activity: statsReport(path, videoFiles, days)}
+	for _, video := range videos {
+		switch {
+		case video.Viewed:
+			stats.Viewed++
+		case video.Progress > 0:
+			stats.InProgress++
+		}
+	}
+
+	return stats, nil
+}
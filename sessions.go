@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// sessionIdle is how long a session is listed after its last request,
+	// the browsers buffering ahead then pausing the download.
+	sessionIdle = 2 * time.Minute
+	// sessionStopBlock is how long a stopped session cannot resume, the
+	// players otherwise requesting the video again right away.
+	sessionStopBlock = time.Minute
+)
+
+var errSessionStopped = errors.New("session stopped")
+
+// StreamSession is the playback of a video by a client, made of the requests
+// streaming it. Bandwidth is in bytes per second, since the last listing.
+type StreamSession struct {
+	ID         string
+	User       string `json:",omitempty"`
+	IP         string
+	UserAgent  string `json:",omitempty"`
+	Video      string
+	Name       string
+	Quality    string `json:",omitempty"`
+	Position   float64
+	Started    time.Time
+	LastActive time.Time
+	Streaming  bool
+	Bytes      int64
+	Bandwidth  int64
+}
+
+type streamSession struct {
+	StreamSession
+	bytes        atomic.Int64
+	streams      map[*sessionStream]bool
+	sampled      time.Time
+	sampledBytes int64
+}
+
+// sessionTracker follows the playback sessions, for the /sessions page.
+type sessionTracker struct {
+	mu       sync.Mutex
+	sessions map[string]*streamSession
+	stopped  map[string]time.Time
+}
+
+var sessions = &sessionTracker{
+	sessions: make(map[string]*streamSession),
+	stopped:  make(map[string]time.Time),
+}
+
+// sessionID identifies the playback of the video by the client of the
+// request.
+func sessionID(r *http.Request, video string) string {
+	sum := sha256.Sum256([]byte(clientIP(r) + "\n" + requestUser(r) + "\n" + video))
+
+	return hex.EncodeToString(sum[:6])
+}
+
+// begin records a request streaming the video. It returns the writer
+// counting the bytes sent and the request to serve, cancelled when the
+// session is stopped, or false when the session was stopped.
+func (t *sessionTracker) begin(w http.ResponseWriter, r *http.Request, video VideoFile) (*sessionStream, *http.Request, bool) {
+	id := sessionID(r, video.ID)
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.prune(now)
+	if _, ok := t.stopped[id]; ok {
+		return nil, r, false
+	}
+
+	session := t.sessions[id]
+	if session == nil {
+		session = &streamSession{
+			StreamSession: StreamSession{
+				ID:        id,
+				User:      requestUser(r),
+				IP:        clientIP(r),
+				UserAgent: r.UserAgent(),
+				Video:     video.ID,
+				Name:      video.Name,
+				Position:  video.Progress,
+				Started:   now,
+			},
+			streams: make(map[*sessionStream]bool),
+			sampled: now,
+		}
+		t.sessions[id] = session
+	}
+	session.Quality = r.URL.Query().Get("quality")
+	session.LastActive = now
+
+	ctx, cancel := context.WithCancel(r.Context())
+	stream := &sessionStream{ResponseWriter: w, session: session, cancel: cancel}
+	session.streams[stream] = true
+
+	return stream, r.WithContext(ctx), true
+}
+
+func (t *sessionTracker) end(stream *sessionStream) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(stream.session.streams, stream)
+	stream.session.LastActive = time.Now()
+	stream.cancel()
+}
+
+// played records the position saved by the player of the session.
+func (t *sessionTracker) played(r *http.Request, video string, position float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if session := t.sessions[sessionID(r, video)]; session != nil {
+		session.Position = position
+		session.LastActive = time.Now()
+	}
+}
+
+// stop ends the requests of the session, and rejects the next ones for
+// sessionStopBlock.
+func (t *sessionTracker) stop(id string) (StreamSession, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	session := t.sessions[id]
+	if session == nil {
+		return StreamSession{}, false
+	}
+
+	for stream := range session.streams {
+		stream.stopped.Store(true)
+		stream.cancel()
+	}
+	delete(t.sessions, id)
+	t.stopped[id] = time.Now().Add(sessionStopBlock)
+
+	return session.StreamSession, true
+}
+
+// list returns the sessions, the most recent first.
+func (t *sessionTracker) list() []StreamSession {
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.prune(now)
+
+	list := []StreamSession{}
+	for _, session := range t.sessions {
+		bytes := session.bytes.Load()
+		if elapsed := now.Sub(session.sampled); elapsed >= time.Second {
+			session.Bandwidth = int64(float64(bytes-session.sampledBytes) / elapsed.Seconds())
+			session.sampled = now
+			session.sampledBytes = bytes
+		}
+
+		entry := session.StreamSession
+		entry.Bytes = bytes
+		entry.Streaming = len(session.streams) > 0
+		if entry.Streaming {
+			entry.LastActive = now
+		} else {
+			entry.Bandwidth = 0
+		}
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Started.After(list[j].Started)
+	})
+
+	return list
+}
+
+// prune forgets the idle sessions and the expired stops.
+func (t *sessionTracker) prune(now time.Time) {
+	for id, session := range t.sessions {
+		if len(session.streams) == 0 && now.Sub(session.LastActive) > sessionIdle {
+			delete(t.sessions, id)
+		}
+	}
+	for id, until := range t.stopped {
+		if now.After(until) {
+			delete(t.stopped, id)
+		}
+	}
+}
+
+// sessionStream is the response of a request of a session, counting the
+// bytes sent and failing once the session is stopped.
+type sessionStream struct {
+	http.ResponseWriter
+	session *streamSession
+	cancel  context.CancelFunc
+	stopped atomic.Bool
+}
+
+func (s *sessionStream) Write(p []byte) (int, error) {
+	if s.stopped.Load() {
+		return 0, errSessionStopped
+	}
+
+	n, err := s.ResponseWriter.Write(p)
+	s.session.bytes.Add(int64(n))
+
+	return n, err
+}
+
+// ReadFrom keeps sending the files with sendfile, counting the bytes sent
+// after each chunk.
+func (s *sessionStream) ReadFrom(r io.Reader) (int64, error) {
+	from, ok := s.ResponseWriter.(io.ReaderFrom)
+	if !ok {
+		return io.Copy(struct{ io.Writer }{s}, r)
+	}
+
+	counted := countingReaderFrom{from, func(n int64) {
+		s.session.bytes.Add(n)
+	}}
+
+	return copyChunks(counted, r, func() error {
+		if s.stopped.Load() {
+			return errSessionStopped
+		}
+		return nil
+	})
+}
+
+func (s *sessionStream) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// countingReaderFrom reports the bytes of each ReadFrom call.
+type countingReaderFrom struct {
+	io.ReaderFrom
+	count func(int64)
+}
+
+func (c countingReaderFrom) ReadFrom(r io.Reader) (int64, error) {
+	n, err := c.ReaderFrom.ReadFrom(r)
+	c.count(n)
+
+	return n, err
+}
+
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+
+	value := float64(bytes)
+	for _, suffix := range []string{"KiB", "MiB", "GiB", "TiB"} {
+		value /= unit
+		if value < unit || suffix == "TiB" {
+			return fmt.Sprintf("%.1f %s", value, suffix)
+		}
+	}
+
+	return ""
+}
+
+func createSessionsTemplate() *template.Template {
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Sessions - {{.Title}}</title>
+    <meta http-equiv="refresh" content="5">
+    <style>
+        body {
+            font-family: Arial, sans-serif;
+            margin: 20px;
+        }
+        table {
+            border-collapse: collapse;
+        }
+        th, td {
+            border-bottom: 1px solid #ddd;
+            padding: 6px 10px;
+            text-align: left;
+        }
+        .user-agent {
+            color: #666;
+            font-size: 0.85em;
+        }
+    </style>
+</head>
+<body>
+    <p><a href="/">Back to the library</a></p>
+    <h1>Currently watching</h1>
+    {{if .Sessions}}
+    <table>
+        <tr><th>User</th><th>Video</th><th>Position</th><th>Quality</th><th>Bandwidth</th><th>Sent</th><th>Started</th><th></th></tr>
+        {{range .Sessions}}
+        <tr>
+            <td>{{if .User}}{{.User}}{{else}}{{.IP}}{{end}}<div class="user-agent" title="{{.UserAgent}}">{{.IP}}</div></td>
+            <td><a href="/watch/{{.Video}}">{{.Name}}</a></td>
+            <td>{{formatTimestamp .Position}}</td>
+            <td>{{if .Quality}}{{.Quality}}{{else}}Original{{end}}</td>
+            <td>{{if .Streaming}}{{formatBytes .Bandwidth}}/s{{else}}Idle since {{.LastActive.Format "15:04:05"}}{{end}}</td>
+            <td>{{formatBytes .Bytes}}</td>
+            <td>{{.Started.Format "15:04:05"}}</td>
+            <td>
+                <form method="post" action="/sessions">
+                    <input type="hidden" name="session" value="{{.ID}}">
+                    <button type="submit">Stop</button>
+                </form>
+            </td>
+        </tr>
+        {{end}}
+    </table>
+    {{else}}
+    <p>Nobody is watching.</p>
+    {{end}}
+</body>
+</html>`
+
+	funcs := template.FuncMap{
+		"formatTimestamp": formatTimestamp,
+		"formatBytes":     formatBytes,
+	}
+
+	return template.Must(template.New("sessions").Funcs(funcs).Parse(tmpl))
+}
+
+// handleSessions lists the playback sessions as a page, or as JSON for API
+// clients. A POST request stops the session of the session parameter.
+func handleSessions(w http.ResponseWriter, r *http.Request, path string, tmpl *template.Template) {
+	if r.Method == http.MethodPost {
+		session, ok := sessions.stop(r.FormValue("session"))
+		if !ok {
+			notFound(w, r)
+			return
+		}
+
+		audit(r, path, auditStopSession, session.Name, session.IP)
+
+		if acceptsJSON(r) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		http.Redirect(w, r, "/sessions", http.StatusSeeOther)
+		return
+	}
+
+	list := sessions.list()
+	if acceptsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+
+	data := struct {
+		Title    string
+		Sessions []StreamSession
+	}{
+		Title:    pageTitle,
+		Sessions: list,
+	}
+	tmpl.Execute(w, data)
+}
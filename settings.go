@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	settingsFile = "video_settings.json"
+
+	viewList   = "list"
+	viewGrid   = "grid"
+	viewSeries = "series"
+
+	themeDefault      = ""
+	themeHighContrast = "high-contrast"
+
+	scaleDefault = ""
+	scaleLarge   = "large"
+	scaleLarger  = "larger"
+
+	modeDefault = ""
+	modeTV      = "tv"
+
+	// modeCookie remembers the mode chosen with the mode parameter, so that
+	// the TV can use the TV mode while the other devices keep the default
+	// one. Its "desktop" value opts out of the mode of the settings.
+	modeCookie  = "mode"
+	modeDesktop = "desktop"
+
+	maxScreensaverDelay = 240
+)
+
+type Settings struct {
+	View         string
+	Sort         string
+	Theme        string `json:",omitempty"`
+	Scale        string `json:",omitempty"`
+	Mode         string `json:",omitempty"`
+	Screensaver  int    `json:",omitempty"`
+	Playlists    []SmartPlaylist
+	HomeSections []HomeSection
+	Plan         *WatchPlan `json:",omitempty"`
+
+	// Orders holds the custom order of the videos of folders, by folder
+	// ("" for the library root).
+	Orders map[string][]string `json:",omitempty"`
+
+	// Titles holds the titles displayed instead of the file names, by
+	// video name.
+	Titles map[string]string `json:",omitempty"`
+
+	// KeptEntries holds the files of the orphaned entries of the watch state
+	// kept on purpose, for files that will come back.
+	KeptEntries []string `json:",omitempty"`
+}
+
+func defaultSettings() Settings {
+	return Settings{
+		View:         viewList,
+		Sort:         sortByNumber,
+		HomeSections: defaultHomeSections(),
+	}
+}
+
+func loadSettings(path string) (Settings, error) {
+	settings := defaultSettings()
+
+	jsonData, err := os.ReadFile(filepath.Join(path, settingsFile))
+	if err != nil {
+		return settings, nil
+	}
+
+	if err := json.Unmarshal(jsonData, &settings); err != nil {
+		return defaultSettings(), err
+	}
+
+	return settings, nil
+}
+
+func saveSettings(settings Settings, path string) error {
+	jsonData, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+
+	prettyJSON := &bytes.Buffer{}
+	if err := json.Indent(prettyJSON, jsonData, "", "    "); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(path, settingsFile), prettyJSON.Bytes(), 0644)
+}
+
+func handleSettings(w http.ResponseWriter, r *http.Request, path string) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+
+	settings, err := loadSettings(path)
+	if err != nil {
+		log.Printf("Error loading settings: %v", err)
+	}
+
+	if view := r.FormValue("view"); view != "" {
+		if view != viewList && view != viewGrid && view != viewSeries {
+			httpError(w, r, "Invalid view value", http.StatusBadRequest)
+			return
+		}
+		settings.View = view
+	}
+
+	if order := r.FormValue("sort"); order != "" {
+		if order != sortByNumber && order != sortByName && order != sortByRecent {
+			httpError(w, r, "Invalid sort value", http.StatusBadRequest)
+			return
+		}
+		settings.Sort = order
+	}
+
+	if r.Form.Has("theme") {
+		theme := r.FormValue("theme")
+		if theme != themeDefault && theme != themeHighContrast {
+			httpError(w, r, "Invalid theme value", http.StatusBadRequest)
+			return
+		}
+		settings.Theme = theme
+	}
+
+	if r.Form.Has("scale") {
+		scale := r.FormValue("scale")
+		if scale != scaleDefault && scale != scaleLarge && scale != scaleLarger {
+			httpError(w, r, "Invalid scale value", http.StatusBadRequest)
+			return
+		}
+		settings.Scale = scale
+	}
+
+	if r.Form.Has("mode") {
+		mode := r.FormValue("mode")
+		if mode != modeDefault && mode != modeTV {
+			httpError(w, r, "Invalid mode value", http.StatusBadRequest)
+			return
+		}
+		settings.Mode = mode
+	}
+
+	if r.Form.Has("screensaver") {
+		delay, err := strconv.Atoi(r.FormValue("screensaver"))
+		if err != nil || delay < 0 || delay > maxScreensaverDelay {
+			httpError(w, r, "Invalid screensaver value", http.StatusBadRequest)
+			return
+		}
+		settings.Screensaver = delay
+	}
+
+	if err := saveSettings(settings, path); err != nil {
+		log.Printf("Error saving settings: %v", err)
+		httpError(w, r, "Error saving settings", http.StatusInternalServerError)
+		return
+	}
+	audit(r, path, auditSettings, "", r.PostForm.Encode())
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// requestMode returns the interface mode of the request: the one chosen for
+// this browser with ?mode=tv or ?mode=desktop, or the one of the settings.
+// An empty mode parameter forgets the choice of the browser.
+func requestMode(w http.ResponseWriter, r *http.Request, settings Settings) string {
+	mode := settings.Mode
+
+	if r.URL.Query().Has("mode") {
+		switch value := r.URL.Query().Get("mode"); value {
+		case modeTV, modeDesktop:
+			http.SetCookie(w, &http.Cookie{Name: modeCookie, Value: value, Path: "/", MaxAge: 365 * 24 * 3600, SameSite: http.SameSiteLaxMode})
+			mode = value
+		default:
+			http.SetCookie(w, &http.Cookie{Name: modeCookie, Path: "/", MaxAge: -1})
+		}
+	} else if cookie, err := r.Cookie(modeCookie); err == nil && (cookie.Value == modeTV || cookie.Value == modeDesktop) {
+		mode = cookie.Value
+	}
+
+	if mode == modeDesktop {
+		return modeDefault
+	}
+
+	return mode
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// silenceMu runs one detection at a time, as it decodes the whole audio
+// track of the video.
+var silenceMu sync.Mutex
+
+// Silence is a part of a video without sound, in seconds.
+type Silence struct {
+	Start float64
+	End   float64
+}
+
+// handleSilences returns the silent parts of a video, skipped by the player
+// when "Skip silence" is on. They are detected by ffmpeg on the first
+// request, which can take a while for long videos, and then cached.
+func handleSilences(w http.ResponseWriter, r *http.Request, videoFiles []VideoFile, path string) {
+	if !transcodeEnabled() {
+		notFound(w, r)
+		return
+	}
+
+	i := findVideoFile(videoFiles, strings.TrimPrefix(r.URL.Path, "/silences/"))
+	if i < 0 {
+		notFound(w, r)
+		return
+	}
+
+	silences, err := detectSilences(videoFiles[i].Path, libraryCacheDir(path))
+	if err != nil {
+		log.Printf("Error detecting silences: %v", err)
+		httpError(w, r, "Error detecting silences", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(silences)
+}
+
+func detectSilences(videoPath string, cache string) ([]Silence, error) {
+	info, err := os.Stat(videoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(cache, "silences")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	// The settings are part of the key, so that changing them detects the
+	// silences again.
+	key := sha256.Sum256([]byte(fmt.Sprintf("%s:%d:%d:%s:%v", videoPath, info.Size(), info.ModTime().UnixNano(), silenceNoise, silenceDuration)))
+	file := filepath.Join(dir, hex.EncodeToString(key[:16])+".json")
+
+	silenceMu.Lock()
+	defer silenceMu.Unlock()
+
+	silences := []Silence{}
+	if jsonData, err := os.ReadFile(file); err == nil && json.Unmarshal(jsonData, &silences) == nil {
+		return silences, nil
+	}
+
+	debug("Detect silences of \"%s\"", videoPath)
+
+	filter := fmt.Sprintf("silencedetect=noise=%s:d=%g", silenceNoise, silenceDuration.Seconds())
+	cmd := exec.Command(ffmpegPath, "-hide_banner", "-nostats", "-i", videoPath, "-map", "0:a:0", "-af", filter, "-f", "null", "-")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg: %v: %s", err, lastLine(output))
+	}
+
+	silences = parseSilences(output, probeDuration(videoPath))
+
+	jsonData, err := json.Marshal(silences)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(file, jsonData, 0644); err != nil {
+		return nil, err
+	}
+
+	return silences, nil
+}
+
+// parseSilences reads the silence_start and silence_end lines logged by the
+// silencedetect filter. A silence lasting until the end of the video has no
+// end line, it ends with the video when its duration is known.
+func parseSilences(output []byte, duration float64) []Silence {
+	silences := []Silence{}
+	start := -1.0
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if _, value, ok := strings.Cut(line, "silence_start: "); ok {
+			if seconds, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				start = max(seconds, 0)
+			}
+		} else if _, value, ok := strings.Cut(line, "silence_end: "); ok && start >= 0 {
+			value, _, _ = strings.Cut(value, " ")
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+				silences = append(silences, Silence{Start: start, End: seconds})
+			}
+			start = -1
+		}
+	}
+	if start >= 0 && duration > start {
+		silences = append(silences, Silence{Start: start, End: duration})
+	}
+
+	return silences
+}
+
+// lastLine returns the last line of the output of a command, which holds the
+// error of ffmpeg.
+func lastLine(output []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+
+	return lines[len(lines)-1]
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	snapshotDir = "video_snapshots"
+
+	snapshotTimeFormat    = "2006-01-02T150405"
+	snapshotCheckInterval = time.Hour
+)
+
+var snapshotRetention int
+
+// SnapshotChange is a video whose state differs between a snapshot and the
+// current state.
+type SnapshotChange struct {
+	Name     string
+	Snapshot VideoFile
+	Current  VideoFile
+}
+
+func snapshotsEnabled() bool {
+	return snapshotRetention > 0
+}
+
+// listSnapshots returns the names of the snapshots, the most recent first.
+func listSnapshots(path string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(path, snapshotDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []string
+	for _, entry := range entries {
+		if name, ok := strings.CutSuffix(entry.Name(), ".json"); ok && !entry.IsDir() {
+			snapshots = append(snapshots, name)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(snapshots)))
+
+	return snapshots, nil
+}
+
+// validSnapshotName makes sure a snapshot name from a request only designates
+// a file of the snapshot directory.
+func validSnapshotName(name string) bool {
+	_, err := time.Parse(snapshotTimeFormat, name)
+
+	return err == nil
+}
+
+// writeSnapshot saves the current watch state in the snapshot directory.
+func writeSnapshot(path string) (string, error) {
+	viewedVideos, err := loadViewedVideos(path)
+	if err != nil {
+		return "", err
+	}
+
+	jsonData, err := encodeState(sortedVideoStates(viewedVideos))
+	if err != nil {
+		return "", err
+	}
+
+	prettyJSON := &bytes.Buffer{}
+	if err := json.Indent(prettyJSON, jsonData, "", "    "); err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Join(path, snapshotDir), 0755); err != nil {
+		return "", err
+	}
+
+	name := time.Now().Format(snapshotTimeFormat)
+	if err := os.WriteFile(filepath.Join(path, snapshotDir, name+".json"), prettyJSON.Bytes(), 0644); err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+func loadSnapshot(path string, name string) (map[string]VideoFile, error) {
+	jsonData, err := os.ReadFile(filepath.Join(path, snapshotDir, name+".json"))
+	if err != nil {
+		return nil, err
+	}
+
+	savedVideos, err := decodeState(jsonData, path)
+	if err != nil {
+		return nil, err
+	}
+
+	videos := make(map[string]VideoFile)
+	for _, video := range savedVideos {
+		videos[video.File] = video
+	}
+
+	return videos, nil
+}
+
+func sortedVideoStates(videos map[string]VideoFile) []VideoFile {
+	videoFiles := make([]VideoFile, 0, len(videos))
+	for _, video := range videos {
+		videoFiles = append(videoFiles, video)
+	}
+	sort.Slice(videoFiles, func(i, j int) bool {
+		return videoFiles[i].File < videoFiles[j].File
+	})
+
+	return videoFiles
+}
+
+// pruneSnapshots removes the snapshots older than the retention period.
+func pruneSnapshots(path string) error {
+	snapshots, err := listSnapshots(path)
+	if err != nil {
+		return err
+	}
+
+	limit := time.Now().AddDate(0, 0, -snapshotRetention)
+	for _, name := range snapshots {
+		t, err := time.ParseInLocation(snapshotTimeFormat, name, time.Local)
+		if err != nil || !t.Before(limit) {
+			continue
+		}
+
+		debug("Removing snapshot %s", name)
+		if err := os.Remove(filepath.Join(path, snapshotDir, name+".json")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// startSnapshots takes a snapshot of the watch state every day, and removes
+// the ones older than -snapshot-retention days.
+func startSnapshots(path string) {
+	go func() {
+		ticker := time.NewTicker(snapshotCheckInterval)
+		defer ticker.Stop()
+
+		for ; ; <-ticker.C {
+			snapshots, err := listSnapshots(path)
+			if err != nil {
+				log.Printf("Error listing snapshots: %v", err)
+				continue
+			}
+
+			today := time.Now().Format(time.DateOnly)
+			if len(snapshots) == 0 || !strings.HasPrefix(snapshots[0], today) {
+				if _, err := writeSnapshot(path); err != nil {
+					log.Printf("Error writing snapshot: %v", err)
+				}
+			}
+
+			if err := pruneSnapshots(path); err != nil {
+				log.Printf("Error removing old snapshots: %v", err)
+			}
+		}
+	}()
+}
+
+// diffSnapshot returns the videos whose watch state changed since the
+// snapshot.
+func diffSnapshot(snapshot map[string]VideoFile, current map[string]VideoFile) []SnapshotChange {
+	var changes []SnapshotChange
+	for name := range videoStateNames(snapshot, current) {
+		before, after := snapshot[name], current[name]
+		if before.Viewed == after.Viewed && before.Progress == after.Progress && sameTime(before.ReviewAt, after.ReviewAt) {
+			continue
+		}
+		changes = append(changes, SnapshotChange{Name: name, Snapshot: before, Current: after})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Name < changes[j].Name
+	})
+
+	return changes
+}
+
+func sameTime(a *time.Time, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return a.Equal(*b)
+}
+
+func videoStateNames(a map[string]VideoFile, b map[string]VideoFile) map[string]bool {
+	names := make(map[string]bool)
+	for name := range a {
+		names[name] = true
+	}
+	for name := range b {
+		names[name] = true
+	}
+
+	return names
+}
+
+func createSnapshotsTemplate() *template.Template {
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Snapshots - {{.Title}}</title>
+    <style>
+        body {
+            font-family: Arial, sans-serif;
+            margin: 20px;
+        }
+        table {
+            border-collapse: collapse;
+        }
+        th, td {
+            border-bottom: 1px solid #ddd;
+            padding: 6px 10px;
+            text-align: left;
+        }
+    </style>
+</head>
+<body>
+    <p><a href="/">Back to the library</a></p>
+    <h1>Snapshots</h1>
+    {{if .Snapshot}}
+    <h2>Changes since {{.Snapshot}}</h2>
+    {{if .Changes}}
+    <form method="post" action="/snapshots/restore">
+        <input type="hidden" name="snapshot" value="{{.Snapshot}}">
+        <table>
+            <tr><th>Restore</th><th>Video</th><th>In the snapshot</th><th>Now</th></tr>
+            {{range .Changes}}
+            <tr>
+                <td><input type="checkbox" name="video" value="{{.Name}}" checked aria-label="Restore {{.Name}}"></td>
+                <td>{{.Name}}</td>
+                <td>{{template "state" .Snapshot}}</td>
+                <td>{{template "state" .Current}}</td>
+            </tr>
+            {{end}}
+        </table>
+        <p><button type="submit">Restore the selected videos</button></p>
+    </form>
+    {{else}}
+    <p>The watch state did not change since this snapshot.</p>
+    {{end}}
+    {{end}}
+    <h2>Available snapshots</h2>
+    {{if .Snapshots}}
+    <ul>
+        {{range .Snapshots}}
+        <li><a href="/snapshots?snapshot={{.}}">{{.}}</a></li>
+        {{end}}
+    </ul>
+    {{else}}
+    <p>No snapshots yet.</p>
+    {{end}}
+    <form method="post" action="/snapshots">
+        <button type="submit">Take a snapshot now</button>
+    </form>
+</body>
+</html>
+{{define "state"}}{{if .Viewed}}Watched{{else if .Progress}}At {{formatTimestamp .Progress}}{{else}}Not started{{end}}{{if .ReviewAt}}, review on {{.ReviewAt.Format "2006-01-02"}}{{end}}{{end}}`
+
+	funcs := template.FuncMap{
+		"formatTimestamp": formatTimestamp,
+	}
+
+	return template.Must(template.New("snapshots").Funcs(funcs).Parse(tmpl))
+}
+
+// handleSnapshots lists the snapshots and the changes since the one of the
+// snapshot parameter. A POST request takes a snapshot.
+func handleSnapshots(w http.ResponseWriter, r *http.Request, path string, tmpl *template.Template) {
+	if r.Method == http.MethodPost {
+		name, err := writeSnapshot(path)
+		if err != nil {
+			log.Printf("Error writing snapshot: %v", err)
+			httpError(w, r, "Error writing snapshot", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/snapshots?snapshot="+name, http.StatusSeeOther)
+		return
+	}
+
+	snapshots, err := listSnapshots(path)
+	if err != nil {
+		log.Printf("Error listing snapshots: %v", err)
+		httpError(w, r, "Error listing snapshots", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Title     string
+		Snapshots []string
+		Snapshot  string
+		Changes   []SnapshotChange
+	}{
+		Title:     pageTitle,
+		Snapshots: snapshots,
+	}
+
+	if name := r.URL.Query().Get("snapshot"); name != "" {
+		if !validSnapshotName(name) {
+			notFound(w, r)
+			return
+		}
+
+		snapshot, err := loadSnapshot(path, name)
+		if os.IsNotExist(err) {
+			notFound(w, r)
+			return
+		}
+		if err != nil {
+			log.Printf("Error loading snapshot: %v", err)
+			httpError(w, r, "Error loading snapshot", http.StatusInternalServerError)
+			return
+		}
+
+		current, err := loadViewedVideos(path)
+		if err != nil {
+			log.Printf("Error loading video progress: %v", err)
+			httpError(w, r, "Error loading video progress", http.StatusInternalServerError)
+			return
+		}
+
+		data.Snapshot = name
+		data.Changes = diffSnapshot(snapshot, current)
+	}
+
+	tmpl.Execute(w, data)
+}
+
+// handleSnapshotRestore puts back the state of the selected videos from a
+// snapshot. The current state is snapshotted first, so a restore can be
+// undone.
+func handleSnapshotRestore(w http.ResponseWriter, r *http.Request, path string, rescan func()) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		httpError(w, r, "Invalid form", http.StatusBadRequest)
+		return
+	}
+
+	name := r.PostForm.Get("snapshot")
+	if !validSnapshotName(name) {
+		notFound(w, r)
+		return
+	}
+
+	snapshot, err := loadSnapshot(path, name)
+	if os.IsNotExist(err) {
+		notFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading snapshot: %v", err)
+		httpError(w, r, "Error loading snapshot", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := writeSnapshot(path); err != nil {
+		log.Printf("Error writing snapshot: %v", err)
+		httpError(w, r, "Error writing snapshot", http.StatusInternalServerError)
+		return
+	}
+
+	progressMu.Lock()
+	stateEventsMu.Lock()
+	current, err := loadViewedVideos(path)
+	if err == nil {
+		for _, video := range r.PostForm["video"] {
+			if state, ok := snapshot[video]; ok {
+				current[video] = state
+			} else {
+				delete(current, video)
+			}
+		}
+		err = saveViewedVideos(sortedVideoStates(current), path)
+	}
+	stateEventsMu.Unlock()
+	progressMu.Unlock()
+
+	if err != nil {
+		log.Printf("Error restoring video progress: %v", err)
+		httpError(w, r, "Error restoring video progress: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	audit(r, path, auditRestore, "", fmt.Sprintf("%d videos from %s", len(r.PostForm["video"]), name))
+	rescan()
+
+	http.Redirect(w, r, "/snapshots", http.StatusSeeOther)
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	stateEventsFile = "video_events.log"
+
+	storageFile   = "file"
+	storageEvents = "events"
+
+	stateCompactInterval = time.Hour
+)
+
+var (
+	// storageMode selects how the watch state is saved: the whole state is
+	// rewritten on every change ("file"), or the changed video is appended
+	// to the event log and the state is derived from the last snapshot and
+	// the events ("events").
+	storageMode string
+
+	stateEventsMu sync.Mutex
+
+	saveError   string
+	saveErrorMu sync.Mutex
+)
+
+// StateEvent is a line of the event log, the state of a video after a change.
+type StateEvent struct {
+	Time  time.Time
+	Video VideoFile
+}
+
+// stateVersion is the version of the video_data.json format written by this
+// build. Bump it and append a migration whenever the format changes.
+const stateVersion = 3
+
+type videoState struct {
+	Version int         `json:"version"`
+	Videos  []VideoFile `json:"videos"`
+}
+
+// stateMigrations upgrade the raw state of the library at root from version
+// i+1 to version i+2. The root is empty for the states of other libraries.
+var stateMigrations = []func(data json.RawMessage, root string) (json.RawMessage, error){
+	// Version 1 was a bare list of videos.
+	func(data json.RawMessage, root string) (json.RawMessage, error) {
+		return json.Marshal(struct {
+			Version int             `json:"version"`
+			Videos  json.RawMessage `json:"videos"`
+		}{2, data})
+	},
+	// Version 2 keyed the states by file name, which the videos with the
+	// same name in different folders shared.
+	func(data json.RawMessage, root string) (json.RawMessage, error) {
+		var state struct {
+			Version int                          `json:"version"`
+			Videos  []map[string]json.RawMessage `json:"videos"`
+		}
+		if err := json.Unmarshal(data, &state); err != nil {
+			return nil, err
+		}
+
+		for _, entry := range state.Videos {
+			var video VideoFile
+			json.Unmarshal(entry["Name"], &video.Name)
+			json.Unmarshal(entry["Path"], &video.Path)
+
+			file, err := json.Marshal(legacyStateFile(root, video))
+			if err != nil {
+				return nil, err
+			}
+			entry["File"] = file
+		}
+		state.Version = 3
+
+		return json.Marshal(state)
+	},
+}
+
+// legacyStateFile returns the file of the library at root a state keyed by
+// file name is for: its path relative to the library, or when the library
+// was moved, the end of its path naming a file of the library. It falls back
+// to the name, which is the path of a file at the root of the library.
+func legacyStateFile(root string, video VideoFile) string {
+	if root == "" || video.Path == "" {
+		return video.Name
+	}
+
+	if rel, err := filepath.Rel(root, video.Path); err == nil && filepath.IsLocal(rel) {
+		return filepath.ToSlash(rel)
+	}
+
+	parts := strings.Split(filepath.ToSlash(video.Path), "/")
+	for i := 1; i < len(parts)-1; i++ {
+		file := path.Join(parts[i:]...)
+		if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(file))); err == nil {
+			return file
+		}
+	}
+
+	return video.Name
+}
+
+func stateFileVersion(data []byte) (int, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		return 1, nil
+	}
+
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return 0, err
+	}
+
+	return header.Version, nil
+}
+
+// decodeState reads the state of the library at root, or of another library
+// when root is empty.
+func decodeState(data []byte, root string) ([]VideoFile, error) {
+	version, err := stateFileVersion(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if version < 1 || version > stateVersion {
+		return nil, fmt.Errorf("unsupported %s version %d (this build reads up to version %d)", videoDataFile, version, stateVersion)
+	}
+
+	for ; version < stateVersion; version++ {
+		debug("Migrating %s from version %d to %d", videoDataFile, version, version+1)
+		if data, err = stateMigrations[version-1](data, root); err != nil {
+			return nil, fmt.Errorf("migrating %s to version %d: %w", videoDataFile, version+1, err)
+		}
+	}
+
+	var state videoState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+
+	return state.Videos, nil
+}
+
+func encodeState(videoFiles []VideoFile) ([]byte, error) {
+	return json.Marshal(videoState{Version: stateVersion, Videos: videoFiles})
+}
+
+// saveVideoState saves the change made to the video at index i.
+func saveVideoState(videoFiles []VideoFile, i int, path string) error {
+	videoFiles[i].Updated = time.Now()
+	syncWatchedMarker(videoFiles[i], path)
+
+	if storageMode != storageEvents {
+		states, err := withOrphanedStates(videoFiles, path)
+		if err != nil {
+			recordSaveResult(err)
+			return err
+		}
+		return saveViewedVideos(states, path)
+	}
+
+	err := appendStateEvent(videoFiles[i], path)
+	recordSaveResult(err)
+
+	return err
+}
+
+func appendStateEvent(video VideoFile, path string) error {
+	jsonData, err := json.Marshal(StateEvent{Time: time.Now(), Video: video})
+	if err != nil {
+		return err
+	}
+
+	stateEventsMu.Lock()
+	defer stateEventsMu.Unlock()
+
+	file, err := os.OpenFile(filepath.Join(path, stateEventsFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(jsonData, '\n'))
+
+	return err
+}
+
+// recordSaveResult remembers whether the last save of the watch state
+// failed, so that the pages warn that the progress is not kept.
+func recordSaveResult(err error) {
+	saveErrorMu.Lock()
+	defer saveErrorMu.Unlock()
+
+	if err == nil {
+		saveError = ""
+		return
+	}
+	saveError = err.Error()
+}
+
+// stateSaveError returns why the watch state cannot be saved, or "".
+func stateSaveError() string {
+	saveErrorMu.Lock()
+	defer saveErrorMu.Unlock()
+
+	return saveError
+}
+
+// replayStateEvents applies the events of the log to the state loaded from
+// the snapshot. A truncated last line, left by a crash, is ignored.
+func replayStateEvents(path string, viewedVideos map[string]VideoFile) error {
+	file, err := os.Open(filepath.Join(path, stateEventsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return readStateEvents(file, path, viewedVideos)
+}
+
+// readStateEvents applies the events of the log of the library at root, or of
+// another library when root is empty.
+func readStateEvents(r io.Reader, root string, viewedVideos map[string]VideoFile) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var event StateEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			debug("Skipping invalid state event: %v", err)
+			continue
+		}
+		// The events logged before version 3 are keyed by file name.
+		if event.Video.File == "" {
+			event.Video.File = legacyStateFile(root, event.Video)
+		}
+		viewedVideos[event.Video.File] = event.Video
+	}
+
+	return scanner.Err()
+}
+
+// compactState writes the state derived from the events as the new snapshot,
+// which removes the event log.
+func compactState(path string) error {
+	stateEventsMu.Lock()
+	defer stateEventsMu.Unlock()
+
+	viewedVideos, err := loadViewedVideos(path)
+	if err != nil {
+		return err
+	}
+
+	return saveViewedVideos(sortedVideoStates(viewedVideos), path)
+}
+
+func startStateCompaction(path string) {
+	go func() {
+		ticker := time.NewTicker(stateCompactInterval)
+		defer ticker.Stop()
+
+		for ; ; <-ticker.C {
+			if err := compactState(path); err != nil {
+				log.Printf("Error compacting the watch state: %v", err)
+			}
+		}
+	}()
+}
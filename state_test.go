@@ -0,0 +1,117 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDecodeState(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "Show", "Season 1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "Show", "Season 1", "e01.mp4"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	local := filepath.Join(root, "Show", "Season 1", "e01.mp4")
+
+	tests := []struct {
+		name     string
+		data     string
+		root     string
+		wantFile string
+		wantErr  bool
+	}{
+		{
+			name:     "version 1",
+			data:     `[{"Name":"e01.mp4","Path":"` + local + `","Viewed":true,"Progress":12}]`,
+			root:     root,
+			wantFile: "Show/Season 1/e01.mp4",
+		},
+		{
+			name:     "version 2",
+			data:     `{"version":2,"videos":[{"Name":"e01.mp4","Path":"` + local + `","Viewed":true,"Progress":12}]}`,
+			root:     root,
+			wantFile: "Show/Season 1/e01.mp4",
+		},
+		{
+			name:     "version 2 of a moved library",
+			data:     `{"version":2,"videos":[{"Name":"e01.mp4","Path":"/old/library/Show/Season 1/e01.mp4","Viewed":true,"Progress":12}]}`,
+			root:     root,
+			wantFile: "Show/Season 1/e01.mp4",
+		},
+		{
+			name:     "version 2 of another library",
+			data:     `{"version":2,"videos":[{"Name":"e01.mp4","Path":"/elsewhere/Show/Season 1/e01.mp4","Viewed":true,"Progress":12}]}`,
+			wantFile: "e01.mp4",
+		},
+		{
+			name:     "version 3",
+			data:     `{"version":3,"videos":[{"Name":"e01.mp4","File":"Show/Season 1/e01.mp4","Viewed":true,"Progress":12}]}`,
+			root:     root,
+			wantFile: "Show/Season 1/e01.mp4",
+		},
+		{name: "future version", data: `{"version":99,"videos":[]}`, root: root, wantErr: true},
+		{name: "missing version", data: `{"videos":[]}`, root: root, wantErr: true},
+		{name: "invalid", data: `{`, root: root, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			videos, err := decodeState([]byte(test.data), test.root)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("decodeState() = %v, want an error", videos)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeState(): %v", err)
+			}
+			if len(videos) != 1 {
+				t.Fatalf("decodeState() returned %d videos, want 1", len(videos))
+			}
+
+			video := videos[0]
+			if video.File != test.wantFile {
+				t.Errorf("File = %q, want %q", video.File, test.wantFile)
+			}
+			if video.Name != "e01.mp4" || !video.Viewed || video.Progress != 12 {
+				t.Errorf("decodeState() = %+v, the state was not kept", video)
+			}
+		})
+	}
+}
+
+func TestLegacyStateFile(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "a", "b"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "a", "b", "c.mp4"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		root  string
+		video VideoFile
+		want  string
+	}{
+		{name: "inside", root: root, video: VideoFile{Name: "c.mp4", Path: filepath.Join(root, "a", "b", "c.mp4")}, want: "a/b/c.mp4"},
+		{name: "at the root", root: root, video: VideoFile{Name: "d.mp4", Path: filepath.Join(root, "d.mp4")}, want: "d.mp4"},
+		{name: "moved", root: root, video: VideoFile{Name: "c.mp4", Path: "/mnt/old/a/b/c.mp4"}, want: "a/b/c.mp4"},
+		{name: "unknown", root: root, video: VideoFile{Name: "x.mp4", Path: "/mnt/old/x/x.mp4"}, want: "x.mp4"},
+		{name: "no path", root: root, video: VideoFile{Name: "c.mp4"}, want: "c.mp4"},
+		{name: "no root", video: VideoFile{Name: "c.mp4", Path: filepath.Join(root, "a", "b", "c.mp4")}, want: "c.mp4"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if file := legacyStateFile(test.root, test.video); file != test.want {
+				t.Errorf("legacyStateFile() = %q, want %q", file, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	statsFile = "video_stats.json"
+
+	// maxWatchDelta is the largest progress jump counted as watch time, larger
+	// jumps being seeks rather than playback.
+	maxWatchDelta = 60
+)
+
+// DailyStats is the watch activity of a day. Watched and Focused (watched
+// during the focus periods of the study timer) are in seconds. Served is the
+// number of bytes of videos streamed or downloaded, by video name and by
+// client (user or IP) in ServedByVideo and ServedByClient.
+type DailyStats struct {
+	Watched        float64
+	Focused        float64 `json:",omitempty"`
+	Completed      int
+	Served         int64            `json:",omitempty"`
+	ServedByVideo  map[string]int64 `json:",omitempty"`
+	ServedByClient map[string]int64 `json:",omitempty"`
+}
+
+var statsMu sync.Mutex
+
+func statsDay(t time.Time) string {
+	return t.Format(time.DateOnly)
+}
+
+func loadStats(path string) map[string]DailyStats {
+	stats := make(map[string]DailyStats)
+
+	jsonData, err := os.ReadFile(filepath.Join(path, statsFile))
+	if err != nil {
+		return stats
+	}
+
+	if err := json.Unmarshal(jsonData, &stats); err != nil {
+		log.Printf("Error loading watch statistics: %v", err)
+	}
+
+	return stats
+}
+
+func updateStats(path string, update func(*DailyStats)) {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	stats := loadStats(path)
+	day := stats[statsDay(time.Now())]
+	update(&day)
+	stats[statsDay(time.Now())] = day
+
+	jsonData, err := json.Marshal(stats)
+	if err != nil {
+		log.Printf("Error marshaling watch statistics: %v", err)
+		return
+	}
+
+	prettyJSON := &bytes.Buffer{}
+	if err := json.Indent(prettyJSON, jsonData, "", "    "); err == nil {
+		if err := os.WriteFile(filepath.Join(path, statsFile), prettyJSON.Bytes(), 0644); err != nil {
+			log.Printf("Error saving watch statistics: %v", err)
+		}
+	}
+}
+
+// recordWatchTime counts the progress made since the previous update as
+// watch time.
+func recordWatchTime(path string, previous float64, current float64) {
+	delta := current - previous
+	if delta <= 0 || delta > maxWatchDelta {
+		return
+	}
+
+	updateStats(path, func(day *DailyStats) {
+		day.Watched += delta
+	})
+}
+
+func recordStats(path string) {
+	subscribe(func(event Event) {
+		if event.Type == eventVideoCompleted {
+			updateStats(path, func(day *DailyStats) {
+				day.Completed++
+			})
+		}
+	})
+}
+
+// statsSince sums the activity of the days since the given time.
+func statsSince(path string, since time.Time) DailyStats {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	total := DailyStats{
+		ServedByVideo:  make(map[string]int64),
+		ServedByClient: make(map[string]int64),
+	}
+	for day, stats := range loadStats(path) {
+		if day >= statsDay(since) {
+			total.Watched += stats.Watched
+			total.Focused += stats.Focused
+			total.Completed += stats.Completed
+			total.Served += stats.Served
+			for video, bytes := range stats.ServedByVideo {
+				total.ServedByVideo[video] += bytes
+			}
+			for client, bytes := range stats.ServedByClient {
+				total.ServedByClient[client] += bytes
+			}
+		}
+	}
+
+	return total
+}
+
+// handleFocusTime records the seconds watched during a focus period of the
+// study timer.
+func handleFocusTime(w http.ResponseWriter, r *http.Request, path string) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+
+	seconds, err := strconv.ParseFloat(r.FormValue("seconds"), 64)
+	if err != nil || seconds < 0 || seconds > 3600 {
+		httpError(w, r, "Invalid focus time", http.StatusBadRequest)
+		return
+	}
+
+	updateStats(path, func(day *DailyStats) {
+		day.Focused += seconds
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TrafficEntry is the number of bytes served of a video, or to a client.
+type TrafficEntry struct {
+	Name  string
+	ID    string `json:",omitempty"`
+	Bytes int64
+}
+
+// StatsDay is the activity of a day of the statistics page.
+type StatsDay struct {
+	Day string
+	DailyStats
+}
+
+func sortedTraffic(served map[string]int64, limit int) []TrafficEntry {
+	entries := []TrafficEntry{}
+	for name, bytes := range served {
+		entries = append(entries, TrafficEntry{Name: name, Bytes: bytes})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Bytes != entries[j].Bytes {
+			return entries[i].Bytes > entries[j].Bytes
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	return entries
+}
+
+func createStatsTemplate() *template.Template {
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Statistics - {{.Title}}</title>
+    <style>
+        body {
+            font-family: Arial, sans-serif;
+            margin: 20px;
+        }
+        table {
+            border-collapse: collapse;
+            margin-bottom: 20px;
+        }
+        th, td {
+            border-bottom: 1px solid #ddd;
+            padding: 6px 10px;
+            text-align: left;
+        }
+    </style>
+</head>
+<body>
+    <p><a href="/">Back to the library</a></p>
+    <h1>Statistics</h1>
+    <p>
+        {{range .Periods}}{{if eq . $.Days}}<strong>{{template "period" .}}</strong>{{else}}<a href="/stats?days={{.}}">{{template "period" .}}</a>{{end}} {{end}}
+    </p>
+    <p>
+        Minutes watched: {{minutes .Total.Watched}}<br>
+        Videos completed: {{.Total.Completed}}<br>
+        Data served: {{formatBytes .Total.Served}}
+    </p>
+    <h2>Most streamed videos</h2>
+    {{if .Videos}}
+    <table>
+        <tr><th>Video</th><th>Data served</th></tr>
+        {{range .Videos}}
+        <tr>
+            <td>{{if .ID}}<a href="/watch/{{.ID}}">{{.Name}}</a>{{else}}{{.Name}}{{end}}</td>
+            <td>{{formatBytes .Bytes}}</td>
+        </tr>
+        {{end}}
+    </table>
+    {{else}}
+    <p>No videos served yet.</p>
+    {{end}}
+    {{if .Clients}}
+    <h2>Clients</h2>
+    <table>
+        <tr><th>Client</th><th>Data served</th></tr>
+        {{range .Clients}}
+        <tr><td>{{.Name}}</td><td>{{formatBytes .Bytes}}</td></tr>
+        {{end}}
+    </table>
+    {{end}}
+    {{if .History}}
+    <h2>By day</h2>
+    <table>
+        <tr><th>Day</th><th>Minutes watched</th><th>Videos completed</th><th>Data served</th></tr>
+        {{range .History}}
+        <tr><td>{{.Day}}</td><td>{{minutes .Watched}}</td><td>{{.Completed}}</td><td>{{formatBytes .Served}}</td></tr>
+        {{end}}
+    </table>
+    {{end}}
+</body>
+</html>
+{{define "period"}}{{if .}}Last {{.}} days{{else}}All time{{end}}{{end}}`
+
+	funcs := template.FuncMap{
+		"formatBytes": formatBytes,
+		"minutes": func(seconds float64) int {
+			return int(seconds / 60)
+		},
+	}
+
+	return template.Must(template.New("stats").Funcs(funcs).Parse(tmpl))
+}
+
+// handleStats shows the watch activity and the data served over the last
+// days (30 by default, all of them with days=0), as a page or as JSON for
+// API clients.
+func handleStats(w http.ResponseWriter, r *http.Request, videoFiles []VideoFile, path string, tmpl *template.Template) {
+	days, ok := statsDays(r)
+	if !ok {
+		httpError(w, r, "Invalid number of days", http.StatusBadRequest)
+		return
+	}
+	report := statsReport(path, videoFiles, days)
+
+	if acceptsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+		return
+	}
+
+	data := struct {
+		StatsReport
+		Title   string
+		Periods []int
+	}{
+		StatsReport: report,
+		Title:       pageTitle,
+		Periods:     []int{7, 30, 365, 0},
+	}
+	tmpl.Execute(w, data)
+}
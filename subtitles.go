@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxSubtitleDelay = 10 * 60 * 1000
+
+var (
+	srtTimestampRegexp = regexp.MustCompile(`(\d{2}:\d{2}:\d{2}),(\d{3})`)
+	cueTimestampRegexp = regexp.MustCompile(`(?:(\d+):)?(\d{2}):(\d{2})\.(\d{3})`)
+)
+
+// SubtitleTrack is a subtitle file served as a WebVTT track of the player.
+type SubtitleTrack struct {
+	Label string
+	Lang  string
+	URL   string
+}
+
+// subtitleFiles returns the SRT and WebVTT files next to a video, such as
+// "video.srt" or "video.en.vtt".
+func subtitleFiles(video VideoFile) []string {
+	base := strings.TrimSuffix(video.Path, filepath.Ext(video.Path))
+
+	var files []string
+	for _, ext := range []string{".srt", ".vtt"} {
+		matches, _ := filepath.Glob(globEscape(base) + "*" + ext)
+		files = append(files, matches...)
+	}
+
+	return files
+}
+
+func subtitleTracks(video VideoFile) []SubtitleTrack {
+	base := strings.TrimSuffix(video.Path, filepath.Ext(video.Path))
+
+	var tracks []SubtitleTrack
+	for i, file := range subtitleFiles(video) {
+		lang := strings.Trim(strings.TrimSuffix(strings.TrimPrefix(file, base), filepath.Ext(file)), ".")
+		label := lang
+		if label == "" {
+			label = "Subtitles"
+		}
+
+		tracks = append(tracks, SubtitleTrack{
+			Label: label,
+			Lang:  lang,
+			URL:   "/subtitles/" + video.ID + "?track=" + strconv.Itoa(i),
+		})
+	}
+
+	return tracks
+}
+
+// shiftCues delays the cues of a WebVTT file, the cues moved before the
+// start of the video starting at 0.
+func shiftCues(content []byte, delay time.Duration) []byte {
+	lines := bytes.Split(content, []byte("\n"))
+	for i, line := range lines {
+		if !bytes.Contains(line, []byte("-->")) {
+			continue
+		}
+
+		lines[i] = cueTimestampRegexp.ReplaceAllFunc(line, func(timestamp []byte) []byte {
+			parts := cueTimestampRegexp.FindSubmatch(timestamp)
+			hours, _ := strconv.Atoi(string(parts[1]))
+			minutes, _ := strconv.Atoi(string(parts[2]))
+			seconds, _ := strconv.Atoi(string(parts[3]))
+			millis, _ := strconv.Atoi(string(parts[4]))
+
+			t := time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second + time.Duration(millis)*time.Millisecond + delay
+			t = max(t, 0)
+
+			return []byte(fmt.Sprintf("%02d:%02d:%02d.%03d", int(t.Hours()), int(t.Minutes())%60, int(t.Seconds())%60, t.Milliseconds()%1000))
+		})
+	}
+
+	return bytes.Join(lines, []byte("\n"))
+}
+
+// handleSubtitles serves a subtitle file of a video as WebVTT, the only
+// format supported by browsers, converting SRT files on the fly. The cues are
+// shifted by the delay parameter, or else by the delay saved for the video.
+func handleSubtitles(w http.ResponseWriter, r *http.Request, videoFiles []VideoFile, path string) {
+	i := findVideoFile(videoFiles, strings.TrimPrefix(r.URL.Path, "/subtitles/"))
+	if i < 0 {
+		notFound(w, r)
+		return
+	}
+
+	files := subtitleFiles(videoFiles[i])
+	track, err := strconv.Atoi(r.URL.Query().Get("track"))
+	if err != nil || track < 0 || track >= len(files) {
+		notFound(w, r)
+		return
+	}
+
+	content, err := os.ReadFile(files[track])
+	if err != nil {
+		notFound(w, r)
+		return
+	}
+
+	delay := videoFiles[i].SubtitleDelay
+	if value := r.URL.Query().Get("delay"); value != "" {
+		delay, err = strconv.Atoi(value)
+		if err != nil || delay < -maxSubtitleDelay || delay > maxSubtitleDelay {
+			httpError(w, r, "Invalid subtitle delay", http.StatusBadRequest)
+			return
+		}
+	} else if viewedVideos, err := loadViewedVideos(path); err == nil {
+		delay = viewedVideos[videoFiles[i].File].SubtitleDelay
+	}
+
+	w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	if strings.EqualFold(filepath.Ext(files[track]), ".srt") {
+		w.Write([]byte("WEBVTT\n\n"))
+		content = srtTimestampRegexp.ReplaceAll(content, []byte("$1.$2"))
+	}
+	if delay != 0 {
+		content = shiftCues(content, time.Duration(delay)*time.Millisecond)
+	}
+	w.Write(content)
+}
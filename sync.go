@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxStateSize bounds the state posted to /api/state.
+const maxStateSize = 32 << 20
+
+var (
+	syncPeer     string
+	syncInterval time.Duration
+	syncToken    string
+)
+
+func syncEnabled() bool {
+	return syncPeer != ""
+}
+
+// stateAPIEnabled reports whether /api/state is served, to the peers knowing
+// the sync token.
+func stateAPIEnabled() bool {
+	return syncToken != ""
+}
+
+// changedAt returns when the watch state of a video last changed. The states
+// saved before the Updated field existed fall back to the last watch time.
+func changedAt(video VideoFile) time.Time {
+	if !video.Updated.IsZero() {
+		return video.Updated
+	}
+
+	return video.Current
+}
+
+// mergeVideoStates applies the incoming states that are more recent than the
+// local ones, and returns the files of the videos that changed.
+func mergeVideoStates(local map[string]VideoFile, incoming []VideoFile) []string {
+	var changed []string
+	for _, video := range incoming {
+		current, ok := local[video.File]
+		if ok && !changedAt(video).After(changedAt(current)) {
+			continue
+		}
+		if !ok && !video.Viewed && video.Progress == 0 && video.ReviewAt == nil {
+			continue
+		}
+
+		current.Name = video.Name
+		current.File = video.File
+		current.Viewed = video.Viewed
+		current.Current = video.Current
+		current.Progress = video.Progress
+		current.ReviewAt = video.ReviewAt
+		current.SubtitleDelay = video.SubtitleDelay
+		current.Updated = changedAt(video)
+		local[video.File] = current
+		changed = append(changed, video.File)
+	}
+
+	return changed
+}
+
+// applyVideoStates merges incoming states into the saved watch state.
+func applyVideoStates(path string, incoming []VideoFile) ([]string, error) {
+	progressMu.Lock()
+	defer progressMu.Unlock()
+	stateEventsMu.Lock()
+	defer stateEventsMu.Unlock()
+
+	local, err := loadViewedVideos(path)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := mergeVideoStates(local, incoming)
+	if len(changed) > 0 {
+		if err := saveViewedVideos(sortedVideoStates(local), path); err != nil {
+			return nil, err
+		}
+	}
+
+	return changed, nil
+}
+
+// handleState exposes the watch state to the other instances: GET returns
+// it, POST merges the posted state, the most recent change of each video
+// winning. The sync token is required.
+func handleState(w http.ResponseWriter, r *http.Request, path string, rescan func()) {
+	authorization := []byte(r.Header.Get("Authorization"))
+	if syncToken == "" || subtle.ConstantTimeCompare(authorization, []byte("Bearer "+syncToken)) != 1 {
+		httpError(w, r, "Invalid sync token", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		viewedVideos, err := loadViewedVideos(path)
+		if err != nil {
+			log.Printf("Error loading video progress: %v", err)
+			httpError(w, r, "Error loading video progress", http.StatusInternalServerError)
+			return
+		}
+
+		jsonData, err := encodeState(sortedVideoStates(viewedVideos))
+		if err != nil {
+			httpError(w, r, "Error encoding video progress", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonData)
+	case http.MethodPost:
+		jsonData, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxStateSize))
+		if err != nil {
+			httpError(w, r, "Invalid state", http.StatusBadRequest)
+			return
+		}
+
+		incoming, err := decodeState(jsonData, "")
+		if err != nil {
+			httpError(w, r, "Invalid state: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		changed, err := applyVideoStates(path, incoming)
+		if err != nil {
+			log.Printf("Error merging video progress: %v", err)
+			httpError(w, r, "Error merging video progress", http.StatusInternalServerError)
+			return
+		}
+		if len(changed) > 0 {
+			audit(r, path, auditSync, "", fmt.Sprintf("%d videos updated", len(changed)))
+			rescan()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"Updated": len(changed)})
+	default:
+		methodNotAllowed(w, r, "GET, HEAD, POST")
+	}
+}
+
+// startSync pulls the state of -sync-peer and pushes the merged state back
+// every -sync-interval, so that both instances converge.
+func startSync(path string, rescan func()) {
+	go func() {
+		ticker := time.NewTicker(syncInterval)
+		defer ticker.Stop()
+
+		for ; ; <-ticker.C {
+			if err := syncWithPeer(path, rescan); err != nil {
+				log.Printf("Error syncing with %s: %v", syncPeer, err)
+			}
+		}
+	}()
+}
+
+func syncWithPeer(path string, rescan func()) error {
+	endpoint := strings.TrimSuffix(syncPeer, "/") + "/api/state"
+
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	body, err := sendSyncRequest(req)
+	if err != nil {
+		return err
+	}
+
+	incoming, err := decodeState(body, "")
+	if err != nil {
+		return err
+	}
+
+	changed, err := applyVideoStates(path, incoming)
+	if err != nil {
+		return err
+	}
+	if len(changed) > 0 {
+		debug("Pulled %d videos from %s", len(changed), syncPeer)
+		rescan()
+	}
+
+	viewedVideos, err := loadViewedVideos(path)
+	if err != nil {
+		return err
+	}
+	jsonData, err := encodeState(sortedVideoStates(viewedVideos))
+	if err != nil {
+		return err
+	}
+
+	req, err = http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(jsonData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	_, err = sendSyncRequest(req)
+
+	return err
+}
+
+func sendSyncRequest(req *http.Request) ([]byte, error) {
+	if syncToken != "" {
+		req.Header.Set("Authorization", "Bearer "+syncToken)
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The viewer is served on the tailnet through the Tailscale daemon of the
+// machine (-tailscale), rather than an embedded node, to keep the binary
+// free of dependencies: it listens on the Tailscale addresses of the machine,
+// reachable at its MagicDNS name, and identifies the users of the requests
+// with the local API of the daemon.
+const (
+	tailscaleLocalAPI = "http://local-tailscaled.sock/localapi/v0/"
+	tailscaleWhoisTTL = time.Minute
+	tailscaleWhoisMax = 1024
+)
+
+var (
+	tailscaleEnabled bool
+	tailscaleSocket  string
+	tailscaleUsers   string
+
+	tailscaleClient = &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", tailscaleSocket)
+			},
+		},
+	}
+
+	tailscaleWhois   = make(map[netip.Addr]tailscaleIdentity)
+	tailscaleWhoisMu sync.Mutex
+)
+
+// tailscaleStatus is the part of the status of the daemon describing this
+// machine.
+type tailscaleStatus struct {
+	BackendState string
+	Self         struct {
+		DNSName      string
+		TailscaleIPs []netip.Addr
+	}
+}
+
+// tailscaleUserKey is the context key of the login name of the tailnet user
+// of a request.
+type tailscaleUserKey struct{}
+
+type tailscaleIdentity struct {
+	LoginName string
+	Expires   time.Time
+}
+
+func tailscaleGet(endpoint string, value any) error {
+	response, err := tailscaleClient.Get(tailscaleLocalAPI + endpoint)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("tailscaled responded %s", response.Status)
+	}
+
+	return json.NewDecoder(response.Body).Decode(value)
+}
+
+// tailscaleAddresses returns the addresses to listen on, the Tailscale
+// addresses of the machine, and its MagicDNS name.
+func tailscaleAddresses(port string) ([]string, string, error) {
+	var status tailscaleStatus
+	if err := tailscaleGet("status", &status); err != nil {
+		return nil, "", err
+	}
+	if status.BackendState != "Running" {
+		return nil, "", fmt.Errorf("Tailscale is %s", strings.ToLower(status.BackendState))
+	}
+	if len(status.Self.TailscaleIPs) == 0 {
+		return nil, "", errors.New("this machine has no Tailscale address")
+	}
+
+	var addrs []string
+	for _, ip := range status.Self.TailscaleIPs {
+		addrs = append(addrs, net.JoinHostPort(ip.String(), port))
+	}
+
+	return addrs, strings.TrimSuffix(status.Self.DNSName, "."), nil
+}
+
+// tailscaleUser returns the login name of the tailnet user of the node at
+// the address, cached for a minute. The expired identities are dropped when
+// the cache is full, and the whole cache if none is.
+func tailscaleUser(remoteAddr string) (string, error) {
+	addrPort, err := netip.ParseAddrPort(remoteAddr)
+	if err != nil {
+		return "", err
+	}
+
+	tailscaleWhoisMu.Lock()
+	identity, ok := tailscaleWhois[addrPort.Addr()]
+	tailscaleWhoisMu.Unlock()
+	if ok && time.Now().Before(identity.Expires) {
+		return identity.LoginName, nil
+	}
+
+	var whois struct {
+		UserProfile struct {
+			LoginName string
+		}
+	}
+	if err := tailscaleGet("whois?addr="+url.QueryEscape(remoteAddr), &whois); err != nil {
+		return "", err
+	}
+
+	tailscaleWhoisMu.Lock()
+	if len(tailscaleWhois) >= tailscaleWhoisMax {
+		now := time.Now()
+		for addr, identity := range tailscaleWhois {
+			if !now.Before(identity.Expires) {
+				delete(tailscaleWhois, addr)
+			}
+		}
+		if len(tailscaleWhois) >= tailscaleWhoisMax {
+			clear(tailscaleWhois)
+		}
+	}
+	tailscaleWhois[addrPort.Addr()] = tailscaleIdentity{
+		LoginName: whois.UserProfile.LoginName,
+		Expires:   time.Now().Add(tailscaleWhoisTTL),
+	}
+	tailscaleWhoisMu.Unlock()
+
+	return whois.UserProfile.LoginName, nil
+}
+
+// requireTailscaleIdentity rejects the requests received on the Tailscale
+// addresses from unknown nodes, or from the users not in -tailscale-users
+// when set. The login name of the user is passed in the context of the
+// request, for the audit log.
+func requireTailscaleIdentity(addrs []string, next http.Handler) http.Handler {
+	var users []string
+	for _, user := range strings.Split(tailscaleUsers, ",") {
+		if user = strings.TrimSpace(user); user != "" {
+			users = append(users, user)
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+		if !ok || !slices.Contains(addrs, local.String()) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := tailscaleUser(r.RemoteAddr)
+		if err != nil {
+			debug("Error identifying the Tailscale user of %s: %v", r.RemoteAddr, err)
+			httpError(w, r, "Access denied", http.StatusForbidden)
+			return
+		}
+		if len(users) > 0 && !slices.Contains(users, user) {
+			debug("Rejected request from the Tailscale user %s", user)
+			httpError(w, r, "Access denied", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tailscaleUserKey{}, user)))
+	})
+}
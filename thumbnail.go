@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+func thumbnailsEnabled() bool {
+	return ffmpegPath != ""
+}
+
+func handleThumbnail(w http.ResponseWriter, r *http.Request, videoFiles []VideoFile, path string) {
+	if !thumbnailsEnabled() {
+		notFound(w, r)
+		return
+	}
+
+	i := findVideoFile(videoFiles, strings.TrimPrefix(r.URL.Path, "/thumbnail/"))
+	if i < 0 {
+		notFound(w, r)
+		return
+	}
+
+	thumbnail, err := thumbnailFile(videoFiles[i].Path, libraryCacheDir(path))
+	if err != nil {
+		log.Printf("Error generating thumbnail: %v", err)
+		httpError(w, r, "Error generating thumbnail", http.StatusInternalServerError)
+		return
+	}
+	if _, err := os.Stat(thumbnail); err != nil {
+		err := jobs.run(r.Context(), Job{Kind: jobKindThumbnail, Video: videoFiles[i].Name, Path: videoFiles[i].Path})
+		if err != nil {
+			log.Printf("Error generating thumbnail: %v", err)
+			httpError(w, r, "Error generating thumbnail", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	http.ServeFile(w, r, thumbnail)
+}
+
+// thumbnailFile returns the path of the thumbnail of the video in the cache,
+// which changes with the video file.
+func thumbnailFile(videoPath string, cache string) (string, error) {
+	info, err := os.Stat(videoPath)
+	if err != nil {
+		return "", err
+	}
+
+	key := sha256.Sum256([]byte(fmt.Sprintf("%s:%d:%d", videoPath, info.Size(), info.ModTime().UnixNano())))
+
+	return filepath.Join(cache, "thumbnails", hex.EncodeToString(key[:16])+".jpg"), nil
+}
+
+func generateThumbnail(videoPath string, cache string) (string, error) {
+	thumbnail, err := thumbnailFile(videoPath, cache)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(thumbnail), 0755); err != nil {
+		return "", err
+	}
+
+	if _, err := os.Stat(thumbnail); err == nil {
+		return thumbnail, nil
+	}
+
+	debug("Generate thumbnail for \"%s\"", videoPath)
+
+	tmpFile := thumbnail + ".tmp.jpg"
+	cmd := exec.Command(ffmpegPath, "-v", "error", "-y", "-i", videoPath, "-vf", "thumbnail,scale=640:-1", "-frames:v", "1", tmpFile)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(tmpFile)
+		return "", fmt.Errorf("ffmpeg: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	if err := os.Rename(tmpFile, thumbnail); err != nil {
+		return "", err
+	}
+
+	return thumbnail, nil
+}
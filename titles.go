@@ -0,0 +1,81 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+)
+
+const maxTitleLength = 200
+
+// applyTitles gives the videos their custom titles, which take precedence
+// over the titles of the sequence manifests.
+func applyTitles(videoFiles []VideoFile, titles map[string]string) {
+	for i := range videoFiles {
+		if title := titles[videoFiles[i].Name]; title != "" {
+			videoFiles[i].Title = title
+		}
+	}
+}
+
+// handleTitle sets the title displayed instead of the file name of a video:
+// the title of the form, or the one of its metadata with source=metadata.
+// An empty title goes back to the file name. The state and the URLs keep
+// using the file.
+func handleTitle(w http.ResponseWriter, r *http.Request, videoFiles []VideoFile, path string, rescan func()) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+
+	i := findVideoFile(videoFiles, strings.TrimPrefix(r.URL.Path, "/title/"))
+	if i < 0 {
+		notFound(w, r)
+		return
+	}
+
+	title := strings.Join(strings.Fields(r.FormValue("title")), " ")
+	if r.FormValue("source") == "metadata" {
+		metadata := videoMetadata(videoFiles[i])
+		if metadata == nil || metadata.Title == "" {
+			httpError(w, r, "The video has no metadata title", http.StatusBadRequest)
+			return
+		}
+		// Episodes keep their show and number.
+		video := videoFiles[i]
+		video.Title = ""
+		if video.Episode > 0 {
+			video.EpisodeTitle = metadata.Title
+			title = video.DisplayName()
+		} else {
+			title = metadata.Title
+		}
+	}
+	if len(title) > maxTitleLength {
+		httpError(w, r, "Title too long", http.StatusBadRequest)
+		return
+	}
+
+	settings, err := loadSettings(path)
+	if err != nil {
+		log.Printf("Error loading settings: %v", err)
+	}
+
+	if title == "" {
+		delete(settings.Titles, videoFiles[i].Name)
+	} else {
+		if settings.Titles == nil {
+			settings.Titles = make(map[string]string)
+		}
+		settings.Titles[videoFiles[i].Name] = title
+	}
+
+	if err := saveSettings(settings, path); err != nil {
+		log.Printf("Error saving settings: %v", err)
+		httpError(w, r, "Error saving settings", http.StatusInternalServerError)
+		return
+	}
+	rescan()
+
+	redirectToReferer(w, r)
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+
+	spanStatusError = 2
+
+	traceExportInterval = 5 * time.Second
+	maxQueuedSpans      = 2048
+)
+
+var (
+	otlpEndpoint string
+
+	finishedSpans   []*Span
+	finishedSpansMu sync.Mutex
+)
+
+// Span is a traced operation, exported with the OTLP/HTTP JSON encoding.
+type Span struct {
+	traceID    string
+	spanID     string
+	parentID   string
+	name       string
+	kind       int
+	start      time.Time
+	end        time.Time
+	attributes map[string]any
+	err        error
+}
+
+type spanContextKey struct{}
+
+func tracingEnabled() bool {
+	return otlpEndpoint != ""
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// startSpan starts a child span of the span of the context, or a new trace.
+// It returns a nil span, whose methods do nothing, when tracing is disabled.
+func startSpan(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	if !tracingEnabled() {
+		return ctx, nil
+	}
+
+	span := &Span{
+		traceID:    randomHex(16),
+		spanID:     randomHex(8),
+		name:       name,
+		kind:       kind,
+		start:      time.Now(),
+		attributes: make(map[string]any),
+	}
+	if parent, ok := ctx.Value(spanContextKey{}).(*Span); ok {
+		span.traceID = parent.traceID
+		span.parentID = parent.spanID
+	}
+
+	return context.WithValue(ctx, spanContextKey{}, span), span
+}
+
+func (s *Span) SetAttribute(key string, value any) {
+	if s != nil {
+		s.attributes[key] = value
+	}
+}
+
+func (s *Span) SetError(err error) {
+	if s != nil {
+		s.err = err
+	}
+}
+
+// End queues the span for the exporter, dropping it when the collector
+// cannot keep up.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+
+	finishedSpansMu.Lock()
+	defer finishedSpansMu.Unlock()
+
+	if len(finishedSpans) < maxQueuedSpans {
+		finishedSpans = append(finishedSpans, s)
+	}
+}
+
+// parseTraceparent returns the trace and parent span IDs of a W3C
+// traceparent header.
+func parseTraceparent(header string) (string, string, bool) {
+	parts := strings.Split(header, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return "", "", false
+	}
+
+	return parts[1], parts[2], true
+}
+
+type tracedResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *tracedResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// ReadFrom keeps http.ServeFile able to use sendfile through the wrapper.
+func (w *tracedResponseWriter) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(w.ResponseWriter, r)
+}
+
+func (w *tracedResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *tracedResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// traceRequests records a server span for each request.
+func traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := startSpan(r.Context(), r.Method, spanKindServer)
+		if traceID, parentID, ok := parseTraceparent(r.Header.Get("Traceparent")); ok {
+			span.traceID, span.parentID = traceID, parentID
+		}
+
+		tw := &tracedResponseWriter{ResponseWriter: w}
+		r = r.WithContext(ctx)
+		next.ServeHTTP(tw, r)
+
+		if tw.status == 0 {
+			tw.status = http.StatusOK
+		}
+		if r.Pattern != "" {
+			span.name = r.Method + " " + r.Pattern
+		}
+		span.SetAttribute("http.request.method", r.Method)
+		span.SetAttribute("url.path", r.URL.Path)
+		span.SetAttribute("http.response.status_code", tw.status)
+		if tw.status >= 500 {
+			span.SetError(fmt.Errorf("%s", http.StatusText(tw.status)))
+		}
+		span.End()
+	})
+}
+
+func startTraceExporter() {
+	go func() {
+		ticker := time.NewTicker(traceExportInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			finishedSpansMu.Lock()
+			spans := finishedSpans
+			finishedSpans = nil
+			finishedSpansMu.Unlock()
+
+			if len(spans) > 0 {
+				if err := exportSpans(spans); err != nil {
+					log.Printf("Error exporting traces: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+func otlpAttributes(attributes map[string]any) []map[string]any {
+	var result []map[string]any
+	for key, value := range attributes {
+		var v map[string]any
+		switch value := value.(type) {
+		case int:
+			v = map[string]any{"intValue": strconv.Itoa(value)}
+		case int64:
+			v = map[string]any{"intValue": strconv.FormatInt(value, 10)}
+		case float64:
+			v = map[string]any{"doubleValue": value}
+		case bool:
+			v = map[string]any{"boolValue": value}
+		default:
+			v = map[string]any{"stringValue": fmt.Sprint(value)}
+		}
+		result = append(result, map[string]any{"key": key, "value": v})
+	}
+
+	return result
+}
+
+func exportSpans(spans []*Span) error {
+	var otlpSpans []map[string]any
+	for _, span := range spans {
+		otlpSpan := map[string]any{
+			"traceId":           span.traceID,
+			"spanId":            span.spanID,
+			"parentSpanId":      span.parentID,
+			"name":              span.name,
+			"kind":              span.kind,
+			"startTimeUnixNano": strconv.FormatInt(span.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(span.end.UnixNano(), 10),
+			"attributes":        otlpAttributes(span.attributes),
+		}
+		if span.err != nil {
+			otlpSpan["status"] = map[string]any{"code": spanStatusError, "message": span.err.Error()}
+		}
+		otlpSpans = append(otlpSpans, otlpSpan)
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []map[string]any{{
+			"resource": map[string]any{
+				"attributes": otlpAttributes(map[string]any{"service.name": "videos-viewer", "library": pageTitle}),
+			},
+			"scopeSpans": []map[string]any{{
+				"scope": map[string]any{"name": "videos-viewer"},
+				"spans": otlpSpans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := webhookClient.Post(strings.TrimSuffix(otlpEndpoint, "/")+"/v1/traces", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// trafficFlushInterval is how often the bytes served are added to the
+// statistics, rather than writing them on every chunk.
+const trafficFlushInterval = time.Minute
+
+var (
+	// pendingTraffic holds the bytes served since the last flush, by video
+	// then by client.
+	pendingTraffic   = make(map[string]map[string]int64)
+	pendingTrafficMu sync.Mutex
+)
+
+// trafficClient names the client of the request in the statistics, the
+// user authenticated by a reverse proxy or the IP.
+func trafficClient(r *http.Request) string {
+	if user := requestUser(r); user != "" {
+		return user
+	}
+
+	return clientIP(r)
+}
+
+func recordTraffic(video string, client string, bytes int64) {
+	if bytes <= 0 {
+		return
+	}
+
+	pendingTrafficMu.Lock()
+	defer pendingTrafficMu.Unlock()
+
+	if pendingTraffic[video] == nil {
+		pendingTraffic[video] = make(map[string]int64)
+	}
+	pendingTraffic[video][client] += bytes
+}
+
+// startTrafficRecorder adds the bytes served to the statistics of the day
+// every trafficFlushInterval.
+func startTrafficRecorder(path string) {
+	go func() {
+		ticker := time.NewTicker(trafficFlushInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			flushTraffic(path)
+		}
+	}()
+}
+
+func flushTraffic(path string) {
+	pendingTrafficMu.Lock()
+	traffic := pendingTraffic
+	pendingTraffic = make(map[string]map[string]int64)
+	pendingTrafficMu.Unlock()
+
+	if len(traffic) == 0 {
+		return
+	}
+
+	updateStats(path, func(day *DailyStats) {
+		if day.ServedByVideo == nil {
+			day.ServedByVideo = make(map[string]int64)
+		}
+		if day.ServedByClient == nil {
+			day.ServedByClient = make(map[string]int64)
+		}
+		for video, clients := range traffic {
+			for client, bytes := range clients {
+				day.Served += bytes
+				day.ServedByVideo[video] += bytes
+				day.ServedByClient[client] += bytes
+			}
+		}
+	})
+}
+
+// trafficWriter counts the bytes of the video sent to the client.
+type trafficWriter struct {
+	http.ResponseWriter
+	video  string
+	client string
+}
+
+func countTraffic(w http.ResponseWriter, r *http.Request, video VideoFile) *trafficWriter {
+	return &trafficWriter{ResponseWriter: w, video: video.Name, client: trafficClient(r)}
+}
+
+func (t *trafficWriter) Write(p []byte) (int, error) {
+	n, err := t.ResponseWriter.Write(p)
+	recordTraffic(t.video, t.client, int64(n))
+
+	return n, err
+}
+
+// ReadFrom keeps sending the files with sendfile, counting the bytes sent
+// after each chunk.
+func (t *trafficWriter) ReadFrom(r io.Reader) (int64, error) {
+	from, ok := t.ResponseWriter.(io.ReaderFrom)
+	if !ok {
+		return io.Copy(struct{ io.Writer }{t}, r)
+	}
+
+	counted := countingReaderFrom{from, func(n int64) {
+		recordTraffic(t.video, t.client, n)
+	}}
+
+	return copyChunks(counted, r, func() error { return nil })
+}
+
+func (t *trafficWriter) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	transcodeCacheSize   int64
+	transcodeUnsupported bool
+	maxLiveTranscodes    int
+
+	// liveTranscodes holds a slot per stream being transcoded, at most
+	// -max-live-transcodes, nil without limit.
+	liveTranscodes chan struct{}
+
+	// Codecs copied as they are into the MP4 of the original quality, the
+	// other ones being transcoded.
+	mp4VideoCodecs = map[string]bool{"h264": true, "vp9": true, "av1": true}
+	mp4AudioCodecs = map[string]bool{"aac": true, "mp3": true, "opus": true, "flac": true}
+)
+
+// TranscodeProfile is a lower bitrate rendition of the videos, for slow
+// connections. AudioOnly renditions keep only the audio track, to listen to
+// a video over mobile data. Original renditions convert the videos browsers
+// cannot play (.mkv, .avi...) to MP4 at their quality. Subtitle is set per
+// request to burn a bitmap subtitle stream into the video: it is the stream
+// index plus one, 0 burning nothing.
+type TranscodeProfile struct {
+	Name         string
+	Height       int
+	VideoBitrate string
+	AudioBitrate string
+	AudioOnly    bool
+	Original     bool
+	Subtitle     int `json:"-"`
+}
+
+var transcodeProfiles = []TranscodeProfile{
+	{Name: "720p", Height: 720, VideoBitrate: "2500k", AudioBitrate: "128k"},
+	{Name: "480p", Height: 480, VideoBitrate: "1000k", AudioBitrate: "96k"},
+	{Name: "audio", AudioBitrate: "96k", AudioOnly: true},
+}
+
+// originalProfile is not listed with the qualities, the watch page selecting
+// it for the videos the browser cannot play, with -transcode.
+var originalProfile = TranscodeProfile{Name: "original", AudioBitrate: "192k", Original: true}
+
+func transcodeEnabled() bool {
+	return ffmpegPath != ""
+}
+
+func findTranscodeProfile(name string) *TranscodeProfile {
+	if name == originalProfile.Name && transcodeUnsupported {
+		return &originalProfile
+	}
+
+	for i := range transcodeProfiles {
+		if transcodeProfiles[i].Name == name {
+			return &transcodeProfiles[i]
+		}
+	}
+
+	return nil
+}
+
+func transcodeCacheDir(path string) string {
+	return filepath.Join(libraryCacheDir(path), "transcodes")
+}
+
+// transcodeCacheFile returns where the transcoded video is cached. The key
+// changes when the file is replaced or modified.
+func transcodeCacheFile(path string, video VideoFile, profile TranscodeProfile) (string, error) {
+	info, err := os.Stat(video.Path)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%d", video.Path, info.Size(), info.ModTime().UnixNano())))
+
+	name := hex.EncodeToString(sum[:16]) + "-" + profile.Name
+	if profile.Subtitle > 0 {
+		name += "-s" + strconv.Itoa(profile.Subtitle-1)
+	}
+
+	return filepath.Join(transcodeCacheDir(path), name+".mp4"), nil
+}
+
+// transcodeSubtitle reads the subtitles parameter of a request, the index
+// of the bitmap subtitle stream to burn into the transcoded video.
+func transcodeSubtitle(r *http.Request, profile *TranscodeProfile) bool {
+	value := r.URL.Query().Get("subtitles")
+	if value == "" {
+		return true
+	}
+
+	index, err := strconv.Atoi(value)
+	if err != nil || index < 0 {
+		return false
+	}
+	// There is no picture to burn the subtitles into.
+	if !profile.AudioOnly {
+		profile.Subtitle = index + 1
+	}
+
+	return true
+}
+
+func transcodeArgs(videoPath string, profile TranscodeProfile, start float64) []string {
+	if profile.Original {
+		return originalArgs(videoPath, profile, start)
+	}
+
+	if profile.AudioOnly {
+		return []string{
+			"-v", "error",
+			"-ss", strconv.FormatFloat(start, 'f', 3, 64),
+			"-i", videoPath,
+			"-vn", "-sn", "-map", "0:a:0",
+			"-c:a", "aac", "-b:a", profile.AudioBitrate, "-ac", "2",
+		}
+	}
+
+	scale := "scale=-2:'min(" + strconv.Itoa(profile.Height) + ",ih)'"
+	filter := []string{"-vf", scale}
+	if profile.Subtitle > 0 {
+		// The input seek applies to the subtitle stream as well, so the
+		// overlay stays in sync with the video.
+		filter = []string{
+			"-filter_complex", "[0:v][0:s:" + strconv.Itoa(profile.Subtitle-1) + "]overlay," + scale + "[v]",
+			"-map", "[v]", "-map", "0:a:0?",
+		}
+	}
+
+	return append([]string{
+		"-v", "error",
+		"-ss", strconv.FormatFloat(start, 'f', 3, 64),
+		"-i", videoPath,
+	}, append(filter,
+		"-c:v", "libx264", "-preset", "veryfast",
+		"-b:v", profile.VideoBitrate, "-maxrate", profile.VideoBitrate, "-bufsize", profile.VideoBitrate,
+		"-c:a", "aac", "-b:a", profile.AudioBitrate, "-ac", "2",
+	)...)
+}
+
+// originalArgs remuxes the streams that MP4 files can hold and browsers can
+// decode, and transcodes the other ones (or all of them when ffprobe cannot
+// tell their codecs). Copied video streams start at the keyframe before the
+// start position.
+func originalArgs(videoPath string, profile TranscodeProfile, start float64) []string {
+	videoCodec, audioCodec, err := probeCodecs(videoPath)
+	if err != nil {
+		debug("Error probing the codecs of \"%s\": %v", videoPath, err)
+	}
+
+	args := []string{
+		"-v", "error",
+		"-ss", strconv.FormatFloat(start, 'f', 3, 64),
+		"-i", videoPath,
+	}
+
+	if profile.Subtitle > 0 {
+		args = append(args,
+			"-filter_complex", "[0:v][0:s:"+strconv.Itoa(profile.Subtitle-1)+"]overlay[v]",
+			"-map", "[v]", "-map", "0:a:0?",
+		)
+	} else {
+		args = append(args, "-map", "0:v:0", "-map", "0:a:0?")
+	}
+
+	// The burned subtitles change the picture.
+	if mp4VideoCodecs[videoCodec] && profile.Subtitle == 0 {
+		args = append(args, "-c:v", "copy")
+	} else {
+		args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-crf", "20", "-pix_fmt", "yuv420p")
+	}
+
+	if mp4AudioCodecs[audioCodec] {
+		args = append(args, "-c:a", "copy")
+	} else {
+		args = append(args, "-c:a", "aac", "-b:a", profile.AudioBitrate)
+	}
+
+	return args
+}
+
+// serveTranscode serves the cached transcoded video when there is one.
+// Otherwise the video is transcoded in the background for the next time,
+// and streamed meanwhile from the start position (in seconds).
+func serveTranscode(w http.ResponseWriter, r *http.Request, path string, video VideoFile, profile TranscodeProfile, start float64) {
+	file, err := transcodeCacheFile(path, video, profile)
+	if err != nil {
+		notFound(w, r)
+		return
+	}
+
+	if _, err := os.Stat(file); err == nil {
+		// The modification time orders the files for the LRU eviction.
+		now := time.Now()
+		os.Chtimes(file, now, now)
+
+		w.Header().Set("Content-Type", "video/mp4")
+		http.ServeFile(w, r, file)
+		return
+	}
+
+	if transcodeCacheSize > 0 {
+		jobs.add(Job{Kind: jobKindTranscode, Video: video.Name, Path: video.Path, Profile: profile.Name, Subtitle: profile.Subtitle}, false)
+	}
+
+	streamTranscode(w, r, video, profile, start)
+}
+
+// streamTranscode streams the video transcoded with the given profile from
+// the start position. The stream cannot be seeked, the player requests a new
+// one starting at the seeked position instead.
+func streamTranscode(w http.ResponseWriter, r *http.Request, video VideoFile, profile TranscodeProfile, start float64) {
+	if liveTranscodes != nil {
+		select {
+		case liveTranscodes <- struct{}{}:
+			defer func() { <-liveTranscodes }()
+		default:
+			w.Header().Set("Retry-After", "30")
+			httpError(w, r, "Too many videos are being transcoded, try again later", http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	args := append(transcodeArgs(video.Path, profile, start),
+		"-movflags", "frag_keyframe+empty_moov+default_base_moof",
+		"-f", "mp4", "pipe:1",
+	)
+	cmd := exec.CommandContext(r.Context(), ffmpegPath, args...)
+	cmd.Stdout = w
+	if isDebugMode {
+		cmd.Stderr = log.Writer()
+	}
+
+	_, span := startSpan(r.Context(), "transcode", spanKindInternal)
+	span.SetAttribute("video", video.Name)
+	span.SetAttribute("profile", profile.Name)
+	span.SetAttribute("start", start)
+	span.SetAttribute("subtitle", profile.Subtitle)
+	defer span.End()
+
+	debug("Transcode \"%s\" to %s from %.0fs", video.Name, profile.Name, start)
+
+	w.Header().Set("Content-Type", "video/mp4")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := cmd.Run(); err != nil && r.Context().Err() == nil {
+		span.SetError(err)
+		debug("Error transcoding \"%s\": %v", video.Name, err)
+	}
+}
+
+// cacheTranscode transcodes the video with the profile into the cache of
+// the library, run by the job queue.
+func cacheTranscode(path string, video VideoFile, profile TranscodeProfile) error {
+	file, err := transcodeCacheFile(path, video, profile)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(file); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(transcodeCacheDir(path), 0755); err != nil {
+		return err
+	}
+
+	_, span := startSpan(context.Background(), "transcode", spanKindInternal)
+	span.SetAttribute("video", video.Name)
+	span.SetAttribute("profile", profile.Name)
+	defer span.End()
+
+	tmp := strings.TrimSuffix(file, ".mp4") + ".tmp.mp4"
+	args := append(transcodeArgs(video.Path, profile, 0), "-movflags", "+faststart", "-y", tmp)
+	if output, err := exec.Command(ffmpegPath, args...).CombinedOutput(); err != nil {
+		span.SetError(err)
+		os.Remove(tmp)
+		return fmt.Errorf("ffmpeg: %v: %s", err, lastLine(output))
+	}
+
+	if err := os.Rename(tmp, file); err != nil {
+		return err
+	}
+
+	debug("Cached the %s transcode of \"%s\"", profile.Name, video.Name)
+	evictTranscodes(path)
+
+	return nil
+}
+
+// evictTranscodes removes the least recently used transcoded videos of the
+// library until its cache fits in -transcode-cache-size.
+func evictTranscodes(path string) {
+	entries, err := os.ReadDir(transcodeCacheDir(path))
+	if err != nil {
+		return
+	}
+
+	var files []os.FileInfo
+	var total int64
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || strings.HasSuffix(entry.Name(), ".tmp.mp4") {
+			continue
+		}
+		files = append(files, info)
+		total += info.Size()
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+
+	for _, info := range files {
+		if total <= transcodeCacheSize {
+			break
+		}
+		if err := os.Remove(filepath.Join(transcodeCacheDir(path), info.Name())); err != nil {
+			log.Printf("Error evicting a transcoded video: %v", err)
+			continue
+		}
+
+		debug("Evicted the transcoded video %s", info.Name())
+		total -= info.Size()
+	}
+}
+
+func handleTranscodeStatus(w http.ResponseWriter, r *http.Request, videoFiles []VideoFile, path string) {
+	i := findVideoFile(videoFiles, strings.TrimPrefix(r.URL.Path, "/transcode-status/"))
+	profile := findTranscodeProfile(r.URL.Query().Get("quality"))
+	if i < 0 || profile == nil {
+		notFound(w, r)
+		return
+	}
+
+	selected := *profile
+	if !transcodeSubtitle(r, &selected) {
+		httpError(w, r, "Invalid subtitles", http.StatusBadRequest)
+		return
+	}
+
+	cached := false
+	if file, err := transcodeCacheFile(path, videoFiles[i], selected); err == nil {
+		_, err = os.Stat(file)
+		cached = err == nil
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"Cached": cached})
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	updateChecksums = "SHA256SUMS"
+	maxUpdateSize   = 200 << 20
+)
+
+// updateReleasesURL is where the latest release is looked up, a fork
+// setting its own with -ldflags "-X main.updateReleasesURL=...".
+var updateReleasesURL = "https://api.github.com/repos/jdecool/videos-viewer/releases/latest"
+
+// updatePublicKey is the base64 Ed25519 public key verifying the signature of
+// the checksums of the releases (SHA256SUMS.sig). It is set when building a
+// release, the builds without it being only updated with "insecure", which
+// verifies the checksums alone.
+var updatePublicKey = ""
+
+var updateClient = &http.Client{Timeout: 5 * time.Minute}
+
+// release is a GitHub release, as returned by its API.
+type release struct {
+	TagName string `json:"tag_name"`
+	Assets  []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// assetURL returns the download URL of the file of the release.
+func (r release) assetURL(name string) (string, bool) {
+	for _, asset := range r.Assets {
+		if asset.Name == name {
+			return asset.URL, true
+		}
+	}
+
+	return "", false
+}
+
+// runUpdate replaces the binary with the one of the latest release for this
+// platform, after verifying its checksum and the signature of the checksums.
+// With "check", it only tells whether a newer release is available; with
+// "force", development builds are updated as well; with "insecure", builds
+// without a release key are updated without verifying the signature.
+func runUpdate(args []string) int {
+	options := make(map[string]bool)
+	for _, arg := range args {
+		options[strings.TrimLeft(arg, "-")] = true
+	}
+	check, force, insecure := options["check"], options["force"], options["insecure"]
+
+	build := buildInfo()
+	latest, err := latestRelease()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error checking the latest release: %v\n", err)
+		return 1
+	}
+
+	if build.Version == "dev" && !force {
+		fmt.Printf("The latest release is %s, this is a development build (use \"update force\" to replace it).\n", latest.TagName)
+		return 0
+	}
+	if build.Version != "dev" && compareVersions(latest.TagName, build.Version) <= 0 {
+		fmt.Printf("%s is up to date.\n", build.Version)
+		return 0
+	}
+	if check {
+		fmt.Printf("%s is available (current: %s).\n", latest.TagName, build.Version)
+		return 0
+	}
+
+	if updatePublicKey == "" && !insecure {
+		fmt.Fprintln(os.Stderr, "This build has no release key to verify the signature of the release (use \"update insecure\" to only verify its checksum).")
+		return 1
+	}
+
+	name := "videos-viewer-" + build.OS + "-" + build.Arch
+	if build.OS == "windows" {
+		name += ".exe"
+	}
+
+	binary, err := downloadRelease(latest, name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error downloading %s: %v\n", latest.TagName, err)
+		return 1
+	}
+
+	if err := replaceExecutable(binary); err != nil {
+		fmt.Fprintf(os.Stderr, "Error replacing the binary: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Updated from %s to %s, restart the server to use it.\n", build.Version, latest.TagName)
+
+	return 0
+}
+
+func latestRelease() (release, error) {
+	var latest release
+
+	request, err := http.NewRequest(http.MethodGet, updateReleasesURL, nil)
+	if err != nil {
+		return latest, err
+	}
+	request.Header.Set("Accept", "application/vnd.github+json")
+
+	response, err := updateClient.Do(request)
+	if err != nil {
+		return latest, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return latest, fmt.Errorf("GitHub responded %s", response.Status)
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(&latest); err != nil {
+		return latest, err
+	}
+	if latest.TagName == "" {
+		return latest, errors.New("no release found")
+	}
+
+	return latest, nil
+}
+
+// downloadRelease downloads the binary of the release, and verifies it
+// against the checksums, which are verified against their signature.
+func downloadRelease(latest release, name string) ([]byte, error) {
+	binaryURL, ok := latest.assetURL(name)
+	if !ok {
+		return nil, fmt.Errorf("no %s binary in the release", name)
+	}
+	checksumsURL, ok := latest.assetURL(updateChecksums)
+	if !ok {
+		return nil, fmt.Errorf("no %s in the release", updateChecksums)
+	}
+
+	checksums, err := download(checksumsURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if updatePublicKey != "" {
+		signatureURL, ok := latest.assetURL(updateChecksums + ".sig")
+		if !ok {
+			return nil, fmt.Errorf("the release is not signed")
+		}
+		signature, err := download(signatureURL)
+		if err != nil {
+			return nil, err
+		}
+		if err := verifySignature(checksums, signature); err != nil {
+			return nil, err
+		}
+	} else {
+		fmt.Fprintln(os.Stderr, "Warning: this build has no release key, only the checksum is verified.")
+	}
+
+	expected, ok := releaseChecksum(checksums, name)
+	if !ok {
+		return nil, fmt.Errorf("no checksum for %s", name)
+	}
+
+	binary, err := download(binaryURL)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(binary)
+	if hex.EncodeToString(sum[:]) != expected {
+		return nil, errors.New("checksum mismatch")
+	}
+
+	return binary, nil
+}
+
+func download(url string) ([]byte, error) {
+	response, err := updateClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s responded %s", url, response.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(response.Body, maxUpdateSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxUpdateSize {
+		return nil, fmt.Errorf("%s is too large", url)
+	}
+
+	return data, nil
+}
+
+// verifySignature verifies the Ed25519 signature of the checksums, raw or
+// base64 encoded.
+func verifySignature(checksums []byte, signature []byte) error {
+	key, err := base64.StdEncoding.DecodeString(updatePublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return errors.New("invalid release key")
+	}
+
+	if len(signature) != ed25519.SignatureSize {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+		if err != nil {
+			return errors.New("invalid signature")
+		}
+		signature = decoded
+	}
+
+	if !ed25519.Verify(ed25519.PublicKey(key), checksums, signature) {
+		return errors.New("signature mismatch")
+	}
+
+	return nil
+}
+
+// releaseChecksum returns the checksum of the file in the output of
+// sha256sum.
+func releaseChecksum(checksums []byte, name string) (string, bool) {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		sum, file, ok := strings.Cut(scanner.Text(), "  ")
+		if ok && strings.TrimPrefix(file, "*") == name {
+			return strings.ToLower(sum), true
+		}
+	}
+
+	return "", false
+}
+
+// compareVersions compares two vX.Y.Z versions, returning a negative number
+// when a is older than b, 0 when they are the same, and a positive number
+// otherwise.
+func compareVersions(a string, b string) int {
+	partsA := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	partsB := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < max(len(partsA), len(partsB)); i++ {
+		var numberA, numberB int
+		if i < len(partsA) {
+			numberA, _ = strconv.Atoi(partsA[i])
+		}
+		if i < len(partsB) {
+			numberB, _ = strconv.Atoi(partsB[i])
+		}
+		if numberA != numberB {
+			return numberA - numberB
+		}
+	}
+
+	return 0
+}
+
+// replaceExecutable replaces the running binary, once the new one has been
+// checked to run on this machine.
+func replaceExecutable(binary []byte) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if executable, err = filepath.EvalSymlinks(executable); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(executable)
+	if err != nil {
+		return err
+	}
+
+	// The new binary is written next to the current one, so that it is
+	// renamed over it on the same filesystem.
+	tmp := executable + ".new"
+	if err := os.WriteFile(tmp, binary, info.Mode().Perm()|0100); err != nil {
+		return err
+	}
+	if output, err := exec.Command(tmp, "-version").CombinedOutput(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("the new binary does not run: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	// A running binary cannot be replaced on Windows, only renamed.
+	if runtime.GOOS == "windows" {
+		os.Remove(executable + ".old")
+		if err := os.Rename(executable, executable+".old"); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+
+	if err := os.Rename(tmp, executable); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"testing"
+)
+
+func TestVerifySignature(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(key string) { updatePublicKey = key }(updatePublicKey)
+
+	checksums := []byte("0123abcd  videos-viewer-linux-amd64\n")
+	signature := ed25519.Sign(privateKey, checksums)
+	encoded := base64.StdEncoding.EncodeToString(signature)
+	key := base64.StdEncoding.EncodeToString(publicKey)
+
+	tests := []struct {
+		name      string
+		key       string
+		checksums []byte
+		signature []byte
+		wantErr   bool
+	}{
+		{name: "raw", key: key, checksums: checksums, signature: signature},
+		{name: "base64", key: key, checksums: checksums, signature: []byte(encoded)},
+		{name: "base64 with newline", key: key, checksums: checksums, signature: []byte(encoded + "\n")},
+		{name: "other checksums", key: key, checksums: []byte("ffff  videos-viewer-linux-amd64\n"), signature: signature, wantErr: true},
+		{name: "other key", key: key, checksums: checksums, signature: ed25519.Sign(otherKey, checksums), wantErr: true},
+		{name: "invalid base64", key: key, checksums: checksums, signature: []byte("not a signature"), wantErr: true},
+		{name: "truncated", key: key, checksums: checksums, signature: signature[:32], wantErr: true},
+		{name: "no release key", key: "", checksums: checksums, signature: signature, wantErr: true},
+		{name: "invalid release key", key: "AAAA", checksums: checksums, signature: signature, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			updatePublicKey = test.key
+
+			err := verifySignature(test.checksums, test.signature)
+			if (err != nil) != test.wantErr {
+				t.Errorf("verifySignature() error = %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	maxUploadChunkSize = 64 << 20
+	maxUploadMemory    = 32 << 20
+)
+
+var errUploadExists = errors.New("file already exists")
+
+// uploadLocks serializes the writes to the same partial file, by path, so
+// that two chunks sent at the same offset are not both appended.
+var (
+	uploadLocks   = make(map[string]*uploadLock)
+	uploadLocksMu sync.Mutex
+)
+
+type uploadLock struct {
+	sync.Mutex
+	users int
+}
+
+// lockUpload locks the partial file, and returns the function unlocking it.
+func lockUpload(partFile string) func() {
+	uploadLocksMu.Lock()
+	lock, ok := uploadLocks[partFile]
+	if !ok {
+		lock = &uploadLock{}
+		uploadLocks[partFile] = lock
+	}
+	lock.users++
+	uploadLocksMu.Unlock()
+
+	lock.Lock()
+
+	return func() {
+		lock.Unlock()
+
+		uploadLocksMu.Lock()
+		lock.users--
+		if lock.users == 0 {
+			delete(uploadLocks, partFile)
+		}
+		uploadLocksMu.Unlock()
+	}
+}
+
+// finishUpload moves the complete partial file to its target, unless a file
+// appeared there in the meantime.
+func finishUpload(partFile string, target string) error {
+	if _, err := os.Stat(target); err == nil {
+		os.Remove(partFile)
+		return errUploadExists
+	}
+
+	return os.Rename(partFile, target)
+}
+
+// handleUpload accepts plain multipart uploads (POST) as well as resumable
+// chunked uploads: HEAD reports the number of bytes already received for a
+// file in the Upload-Offset header, and PATCH appends a chunk at the given
+// offset.
+func handleUpload(w http.ResponseWriter, r *http.Request, path string, rescan func()) {
+	if !allowUpload {
+		notFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		handleMultipartUpload(w, r, path, rescan)
+	case http.MethodHead:
+		handleUploadOffset(w, r, path)
+	case http.MethodPatch:
+		handleUploadChunk(w, r, path, rescan)
+	default:
+		methodNotAllowed(w, r, "POST, HEAD, PATCH")
+	}
+}
+
+func handleMultipartUpload(w http.ResponseWriter, r *http.Request, path string, rescan func()) {
+	if err := r.ParseMultipartForm(maxUploadMemory); err != nil {
+		httpError(w, r, "Invalid upload", http.StatusBadRequest)
+		return
+	}
+
+	for _, header := range r.MultipartForm.File["file"] {
+		target, err := uploadTarget(path, r.FormValue("folder"), header.Filename)
+		if err != nil {
+			httpError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if _, err := os.Stat(target); err == nil {
+			httpError(w, r, "File already exists", http.StatusConflict)
+			return
+		}
+
+		err = saveUploadedFile(header, target)
+		if errors.Is(err, errUploadExists) {
+			httpError(w, r, "File already exists", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Printf("Error saving uploaded file: %v", err)
+			httpError(w, r, "Error saving uploaded file", http.StatusInternalServerError)
+			return
+		}
+
+		debug("Uploaded \"%s\"", target)
+		audit(r, path, auditUpload, filepath.Base(target), "")
+	}
+
+	rescan()
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func saveUploadedFile(header *multipart.FileHeader, target string) error {
+	src, err := header.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	partFile := uploadPartFile(target)
+	defer lockUpload(partFile)()
+
+	dst, err := os.Create(partFile)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(partFile)
+		return err
+	}
+
+	if err := dst.Close(); err != nil {
+		os.Remove(partFile)
+		return err
+	}
+
+	return finishUpload(partFile, target)
+}
+
+func handleUploadOffset(w http.ResponseWriter, r *http.Request, path string) {
+	target, err := uploadTarget(path, r.URL.Query().Get("folder"), r.URL.Query().Get("name"))
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := os.Stat(target); err == nil {
+		httpError(w, r, "File already exists", http.StatusConflict)
+		return
+	}
+
+	var offset int64
+	if info, err := os.Stat(uploadPartFile(target)); err == nil {
+		offset = info.Size()
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleUploadChunk(w http.ResponseWriter, r *http.Request, path string, rescan func()) {
+	query := r.URL.Query()
+
+	target, err := uploadTarget(path, query.Get("folder"), query.Get("name"))
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	size, err := strconv.ParseInt(query.Get("size"), 10, 64)
+	if err != nil || size < 0 {
+		httpError(w, r, "Invalid size value", http.StatusBadRequest)
+		return
+	}
+
+	offset, err := strconv.ParseInt(query.Get("offset"), 10, 64)
+	if err != nil {
+		httpError(w, r, "Invalid offset value", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := os.Stat(target); err == nil {
+		httpError(w, r, "File already exists", http.StatusConflict)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		log.Printf("Error creating upload folder: %v", err)
+		httpError(w, r, "Error saving uploaded file", http.StatusInternalServerError)
+		return
+	}
+
+	partFile := uploadPartFile(target)
+	defer lockUpload(partFile)()
+
+	file, err := os.OpenFile(partFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Printf("Error opening upload file: %v", err)
+		httpError(w, r, "Error saving uploaded file", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		log.Printf("Error reading upload file: %v", err)
+		httpError(w, r, "Error saving uploaded file", http.StatusInternalServerError)
+		return
+	}
+
+	current := info.Size()
+	w.Header().Set("Upload-Offset", strconv.FormatInt(current, 10))
+	if offset != current {
+		httpError(w, r, "Offset mismatch", http.StatusConflict)
+		return
+	}
+
+	written, err := io.Copy(file, http.MaxBytesReader(w, r.Body, maxUploadChunkSize))
+	current += written
+	w.Header().Set("Upload-Offset", strconv.FormatInt(current, 10))
+	if err != nil {
+		log.Printf("Error writing upload chunk: %v", err)
+		httpError(w, r, "Error saving uploaded file", http.StatusInternalServerError)
+		return
+	}
+
+	if current > size {
+		file.Close()
+		os.Remove(partFile)
+		httpError(w, r, "Upload exceeds announced size", http.StatusBadRequest)
+		return
+	}
+
+	if current == size {
+		file.Close()
+		err = finishUpload(partFile, target)
+		if errors.Is(err, errUploadExists) {
+			httpError(w, r, "File already exists", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Printf("Error finalizing upload: %v", err)
+			httpError(w, r, "Error saving uploaded file", http.StatusInternalServerError)
+			return
+		}
+
+		debug("Uploaded \"%s\"", target)
+		audit(r, path, auditUpload, filepath.Base(target), "")
+		rescan()
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// uploadTarget resolves the destination of an uploaded file, making sure it
+// stays inside the library and is a supported video file.
+func uploadTarget(path string, folder string, name string) (string, error) {
+	name = filepath.Base(filepath.Clean("/" + name))
+	if name == "/" || name == "." || !videoExtensions[strings.ToLower(filepath.Ext(name))] {
+		return "", fmt.Errorf("unsupported file: %q", name)
+	}
+
+	folder = filepath.Clean("/" + filepath.FromSlash(folder))
+	if strings.Contains(folder, "..") {
+		return "", errors.New("invalid folder")
+	}
+
+	return filepath.Join(path, folder, name), nil
+}
+
+func uploadPartFile(target string) string {
+	return filepath.Join(filepath.Dir(target), "."+filepath.Base(target)+".part")
+}
+
+func videoFolders(videoFiles []VideoFile, path string) []string {
+	seen := make(map[string]bool)
+	var folders []string
+	for _, video := range videoFiles {
+		folder := videoFolder(video, path)
+		if folder != "" && !seen[folder] {
+			seen[folder] = true
+			folders = append(folders, folder)
+		}
+	}
+
+	sort.Strings(folders)
+
+	return folders
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	runtimedebug "runtime/debug"
+	"strings"
+)
+
+// version is set when building a release, with
+// -ldflags "-X main.version=v1.2.0".
+var version = "dev"
+
+// BuildInfo describes the running binary.
+type BuildInfo struct {
+	Version   string
+	Revision  string `json:",omitempty"`
+	Time      string `json:",omitempty"`
+	Modified  bool   `json:",omitempty"`
+	GoVersion string
+	OS        string
+	Arch      string
+}
+
+// buildInfo returns the version of the binary, and the commit it was built
+// from when known.
+func buildInfo() BuildInfo {
+	build := BuildInfo{
+		Version:   version,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+	}
+
+	info, ok := runtimedebug.ReadBuildInfo()
+	if !ok {
+		return build
+	}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			build.Revision = setting.Value
+		case "vcs.time":
+			build.Time = setting.Value
+		case "vcs.modified":
+			build.Modified = setting.Value == "true"
+		case "GOARM":
+			build.Arch += "v" + setting.Value
+		}
+	}
+	// The binaries installed with go install know their module version, the
+	// local builds only a pseudo-version made of the revision.
+	module := info.Main.Version
+	if build.Version == "dev" && module != "" && module != "(devel)" && (build.Revision == "" || !strings.Contains(module, build.Revision[:min(len(build.Revision), 12)])) {
+		build.Version = module
+	}
+
+	return build
+}
+
+func (b BuildInfo) String() string {
+	s := "videos-viewer " + b.Version
+	if b.Revision != "" {
+		revision := b.Revision[:min(len(b.Revision), 12)]
+		if b.Modified {
+			revision += "-modified"
+		}
+		s += " (" + revision
+		if b.Time != "" {
+			s += ", " + b.Time
+		}
+		s += ")"
+	}
+
+	return s + fmt.Sprintf(" %s %s/%s", b.GoVersion, b.OS, b.Arch)
+}
+
+// handleVersion returns the version of the running binary.
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildInfo())
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+
+	wsMaxMessageSize = 64 << 10
+	wsWriteTimeout   = 10 * time.Second
+)
+
+// wsConn is a server-side WebSocket connection (RFC 6455), only supporting
+// what the viewer needs: small text messages.
+type wsConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	mu     sync.Mutex
+}
+
+// upgradeWebSocket completes the WebSocket handshake. Browsers do not apply
+// the same-origin policy to WebSockets, so the connections opened by other
+// websites are rejected.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || r.Header.Get("Sec-WebSocket-Key") == "" {
+		httpError(w, r, "WebSocket connection expected", http.StatusBadRequest)
+		return nil, errors.New("not a websocket handshake")
+	}
+	if !sameOrigin(r) {
+		httpError(w, r, "Cross-origin request rejected", http.StatusForbidden)
+		return nil, errors.New("cross-origin websocket")
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		httpError(w, r, "WebSocket not supported", http.StatusInternalServerError)
+		return nil, err
+	}
+
+	// The connection outlives the request, so the timeouts of the server do
+	// not apply.
+	conn.SetDeadline(time.Time{})
+
+	hash := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + wsGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(hash[:]))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &wsConn{conn: conn, reader: rw.Reader}, nil
+}
+
+// ReadMessage returns the next text or binary message, answering the pings
+// in between.
+func (c *wsConn) ReadMessage() ([]byte, error) {
+	var message []byte
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case wsOpPing:
+			c.writeFrame(wsOpPong, payload)
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			c.writeFrame(wsOpClose, nil)
+			return nil, io.EOF
+		case wsOpText, wsOpBinary, wsOpContinuation:
+			message = append(message, payload...)
+			if len(message) > wsMaxMessageSize {
+				return nil, errors.New("websocket message too large")
+			}
+		default:
+			return nil, fmt.Errorf("unknown websocket opcode %d", opcode)
+		}
+
+		if fin {
+			return message, nil
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (bool, byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	fin := header[0]&0x80 != 0
+	opcode := header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if length > wsMaxMessageSize {
+		return false, 0, nil, errors.New("websocket frame too large")
+	}
+	if !masked {
+		return false, 0, nil, errors.New("unmasked websocket frame from the client")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return fin, opcode, payload, nil
+}
+
+// WriteMessage sends a text message. It is safe for concurrent use.
+func (c *wsConn) WriteMessage(data []byte) error {
+	return c.writeFrame(wsOpText, data)
+}
+
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	header := []byte{0x80 | opcode}
+	switch length := len(payload); {
+	case length < 126:
+		header = append(header, byte(length))
+	case length <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	_, err := c.conn.Write(append(header, payload...))
+
+	return err
+}
+
+func (c *wsConn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,26 @@
+//go:build linux
+
+package main
+
+import "syscall"
+
+// getXattr tells whether the extended attribute is set on the file.
+func getXattr(path string, name string) (bool, error) {
+	_, err := syscall.Getxattr(path, name, nil)
+	if err == syscall.ENODATA {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func setXattr(path string, name string, set bool) error {
+	if !set {
+		if err := syscall.Removexattr(path, name); err != nil && err != syscall.ENODATA {
+			return err
+		}
+		return nil
+	}
+
+	return syscall.Setxattr(path, name, []byte("1"), 0)
+}
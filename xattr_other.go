@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+var errXattrUnsupported = errors.New("extended attributes are not supported on this platform")
+
+func getXattr(path string, name string) (bool, error) {
+	return false, errXattrUnsupported
+}
+
+func setXattr(path string, name string, set bool) error {
+	return errXattrUnsupported
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	urlDownloadQueued      = "queued"
+	urlDownloadDownloading = "downloading"
+	urlDownloadDone        = "done"
+	urlDownloadFailed      = "failed"
+)
+
+var (
+	urlDownloads     []*URLDownload
+	urlDownloadsMu   sync.Mutex
+	urlDownloadQueue = make(chan *URLDownload, 100)
+
+	ytdlpProgressRegexp = regexp.MustCompile(`^\[download\]\s+(\d+(?:\.\d+)?)%`)
+)
+
+type URLDownload struct {
+	ID       int
+	URL      string
+	Status   string
+	Progress float64
+	Error    string
+}
+
+func addByURLEnabled() bool {
+	return ytdlpPath != "" && ytdlpFolder != ""
+}
+
+func startURLDownloader(path string, rescan func()) {
+	go func() {
+		for download := range urlDownloadQueue {
+			err := runURLDownload(download, filepath.Join(path, ytdlpFolder))
+
+			urlDownloadsMu.Lock()
+			if err != nil {
+				download.Status = urlDownloadFailed
+				download.Error = err.Error()
+			} else {
+				download.Status = urlDownloadDone
+				download.Progress = 100
+			}
+			urlDownloadsMu.Unlock()
+
+			if err == nil {
+				rescan()
+			}
+		}
+	}()
+}
+
+func runURLDownload(download *URLDownload, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	urlDownloadsMu.Lock()
+	download.Status = urlDownloadDownloading
+	urlDownloadsMu.Unlock()
+
+	debug("Download \"%s\" with yt-dlp", download.URL)
+
+	cmd := exec.Command(ytdlpPath,
+		"--newline",
+		"--no-playlist",
+		"-f", "bv*[ext=mp4]+ba[ext=m4a]/b[ext=mp4]/bv*+ba/b",
+		"--merge-output-format", "mp4",
+		"-o", filepath.Join(dir, "%(title)s.%(ext)s"),
+		"--", download.URL,
+	)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		if m := ytdlpProgressRegexp.FindStringSubmatch(scanner.Text()); m != nil {
+			progress, _ := strconv.ParseFloat(m[1], 64)
+
+			urlDownloadsMu.Lock()
+			download.Progress = progress
+			urlDownloadsMu.Unlock()
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return errors.New(msg)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func handleAddURL(w http.ResponseWriter, r *http.Request) {
+	if !addByURLEnabled() {
+		notFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+
+	target, err := url.Parse(r.FormValue("url"))
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		httpError(w, r, "Invalid URL", http.StatusBadRequest)
+		return
+	}
+
+	urlDownloadsMu.Lock()
+	download := &URLDownload{
+		ID:     len(urlDownloads) + 1,
+		URL:    target.String(),
+		Status: urlDownloadQueued,
+	}
+	urlDownloads = append(urlDownloads, download)
+	urlDownloadsMu.Unlock()
+
+	select {
+	case urlDownloadQueue <- download:
+	default:
+		urlDownloadsMu.Lock()
+		download.Status = urlDownloadFailed
+		download.Error = "download queue is full"
+		urlDownloadsMu.Unlock()
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func handleURLDownloads(w http.ResponseWriter, r *http.Request) {
+	if !addByURLEnabled() {
+		notFound(w, r)
+		return
+	}
+
+	urlDownloadsMu.Lock()
+	downloads := make([]URLDownload, 0, len(urlDownloads))
+	for _, download := range urlDownloads {
+		downloads = append(downloads, *download)
+	}
+	urlDownloadsMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(downloads)
+}